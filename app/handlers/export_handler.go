@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"color-blind-simulator-1/app/utils"
+)
+
+// ExportHandler serves a simulation as a downloadable .cube LUT, ICC abstract
+// profile, SVG filter or CSS snippet from /api/export/{type}. The vision, model,
+// severity and size query parameters select the simulation.
+func ExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	format, err := utils.ParseExportFormat(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/export"), "/"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	model, err := simulationModel(query.Get("model"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	export := utils.Export{Vision: query.Get("vision"), Model: model}
+	if export.Vision == "" {
+		export.Vision = "deuteranopia"
+	}
+	if v := query.Get("severity"); v != "" {
+		severity, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(severity >= 0 && severity <= 1) {
+			http.Error(w, "Severity must be a number in [0, 1]", http.StatusBadRequest)
+			return
+		}
+		export.Severity = &severity
+	}
+	if v := query.Get("size"); v != "" {
+		// The ICC table could go up to 255, but its size grows with the cube of the grid
+		if export.LUTSize, err = strconv.Atoi(v); err != nil || export.LUTSize < 2 || export.LUTSize > utils.MaxLUTSize {
+			http.Error(w, fmt.Sprintf("LUT size must be between 2 and %d", utils.MaxLUTSize), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := export.Write(&buf, format); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.Name()+"."+string(format)))
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExportHandlerQueryLimits(t *testing.T) {
+	tests := []struct {
+		url      string
+		wantCode int
+	}{
+		{"/api/export/cube?vision=protanomaly&model=machado&severity=0&size=5", http.StatusOK},
+		{"/api/export/svg?vision=deuteranomaly&severity=0", http.StatusOK},
+		{"/api/export/svg?vision=deuteranomaly&severity=-0.1", http.StatusBadRequest},
+		{"/api/export/svg?vision=deuteranomaly&severity=1.5", http.StatusBadRequest},
+		{"/api/export/svg?vision=deuteranomaly&severity=NaN", http.StatusBadRequest},
+		{"/api/export/icc?size=17", http.StatusOK},
+		{"/api/export/icc?size=130", http.StatusBadRequest},
+		{"/api/export/icc?size=255", http.StatusBadRequest},
+		{"/api/export/cube?size=1", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		ExportHandler(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+		if w.Code != tt.wantCode {
+			t.Errorf("%s: status %d, want %d: %s", tt.url, w.Code, tt.wantCode, strings.TrimSpace(w.Body.String()))
+		}
+	}
+}
+
+func TestExportHandlerNamesZeroSeverity(t *testing.T) {
+	w := httptest.NewRecorder()
+	ExportHandler(w, httptest.NewRequest(http.MethodGet, "/api/export/css?vision=tritanomaly&model=machado&severity=0", nil))
+	if got := w.Header().Get("Content-Disposition"); !strings.Contains(got, "tritanomaly-machado-0.css") {
+		t.Errorf("Content-Disposition %q, want a file name recording severity 0", got)
+	}
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"color-blind-simulator-1/app/jobs"
+	"color-blind-simulator-1/app/pipeline"
+)
+
+// JobsHandler accepts pipeline jobs with POST /api/jobs, reports on them with
+// GET /api/jobs/{id} and cancels them with DELETE /api/jobs/{id}
+func JobsHandler(manager *jobs.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/jobs"), "/")
+		if id == "" {
+			if r.Method != http.MethodPost {
+				http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			submitJob(w, r, manager)
+			return
+		}
+
+		var snapshot jobs.Snapshot
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			snapshot, err = manager.Get(id)
+		case http.MethodDelete:
+			snapshot, err = manager.Cancel(id)
+		default:
+			http.Error(w, "Only GET and DELETE allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		switch {
+		case errors.Is(err, jobs.ErrNotFound):
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		case errors.Is(err, jobs.ErrFinished):
+			http.Error(w, "Job already finished", http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(snapshot)
+	}
+}
+
+// submitJob validates the pipeline and queues the uploaded image, answering
+// before any processing starts
+func submitJob(w http.ResponseWriter, r *http.Request, manager *jobs.Manager) {
+	spec, err := pipeline.SpecFromRequest(r)
+	if err != nil {
+		pipeline.WriteError(w, err)
+		return
+	}
+	split, err := pipeline.SplitOptionsFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	spec.Split = split
+	steps, err := pipeline.Compile(spec)
+	if err != nil {
+		pipeline.WriteError(w, err)
+		return
+	}
+	format, err := pipeline.FormatFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		http.Error(w, "Error reading file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, "Error reading image", http.StatusInternalServerError)
+		return
+	}
+
+	snapshot, err := manager.Submit(jobs.Request{Pipeline: steps, Image: data, Split: split, Format: format})
+	if errors.Is(err, jobs.ErrQueueFull) {
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "Too many jobs queued, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/jobs/"+snapshot.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(snapshot)
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"color-blind-simulator-1/app/utils"
+)
+
+// Upper bound on palette size so the pairwise matrices stay small
+const maxPaletteColors = 64
+
+// PaletteCheckRequest is the JSON body accepted by PaletteCheckHandler
+type PaletteCheckRequest struct {
+	Colors    []string `json:"colors"`
+	Model     string   `json:"model,omitempty"`
+	Threshold float64  `json:"threshold,omitempty"`
+}
+
+// PaletteCheckHandler reports which palette colors become indistinguishable for each type of color vision
+func PaletteCheckHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PaletteCheckRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid palette data", http.StatusBadRequest)
+		return
+	}
+	if len(req.Colors) < 2 || len(req.Colors) > maxPaletteColors {
+		http.Error(w, "Palette must contain between 2 and 64 colors", http.StatusBadRequest)
+		return
+	}
+
+	model, err := simulationModel(req.Model)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	checks, err := utils.CheckPalette(req.Colors, model, req.Threshold)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"colors": req.Colors,
+		"model":  model,
+		"checks": checks,
+	})
+}
+
+// simulationModel defaults the palette and export tools to the Machado model rather than the legacy matrices
+func simulationModel(name string) (utils.SimulationModel, error) {
+	if name == "" {
+		return utils.ModelMachado, nil
+	}
+	return utils.ParseSimulationModel(name)
+}
+
+// Upper bound on generated palette size so the search stays fast
+const maxGeneratedColors = 16
+
+// PaletteGenerateRequest is the JSON body accepted by PaletteGenerateHandler
+type PaletteGenerateRequest struct {
+	Count   int      `json:"count"`
+	Fixed   []string `json:"fixed,omitempty"`
+	Visions []string `json:"visions,omitempty"`
+	Model   string   `json:"model,omitempty"`
+}
+
+// PaletteGenerateHandler suggests a palette that stays distinguishable for the requested vision types
+func PaletteGenerateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PaletteGenerateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid palette request", http.StatusBadRequest)
+		return
+	}
+	if req.Count < 2 || req.Count > maxGeneratedColors {
+		http.Error(w, "Count must be between 2 and 16", http.StatusBadRequest)
+		return
+	}
+
+	model, err := simulationModel(req.Model)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	palette, err := utils.GeneratePalette(req.Count, req.Fixed, req.Visions, model)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(palette)
+}
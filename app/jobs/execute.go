@@ -0,0 +1,119 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	"color-blind-simulator-1/app/pipeline"
+	"color-blind-simulator-1/app/storage"
+	"color-blind-simulator-1/app/utils"
+)
+
+// ErrInvalidImage is returned when the uploaded image cannot be decoded
+var ErrInvalidImage = errors.New("error decoding image")
+
+// Request is a validated pipeline to run on an uploaded image
+type Request struct {
+	Pipeline *pipeline.Pipeline
+	Image    []byte
+	// Split, when set, also saves a comparison of the original and final image
+	Split *utils.SplitOptions
+	// Format of the saved images; FormatAuto picks PNG for transparent or 16-bit
+	// images and JPEG otherwise
+	Format utils.ImageFormat
+}
+
+// Analysis is the report of an analysis step
+type Analysis struct {
+	Step      int         `json:"step"`
+	Operation string      `json:"operation"`
+	Report    interface{} `json:"report"`
+}
+
+// Result lists the URLs of the saved images, starting with the original
+type Result struct {
+	Job        string     `json:"job"`
+	Images     []string   `json:"images"`
+	Operations []string   `json:"operations"`
+	Split      string     `json:"split,omitempty"`
+	Analysis   []Analysis `json:"analysis,omitempty"`
+	// ColorProfile names the embedded profile the upload was converted to sRGB from
+	ColorProfile string `json:"color_profile,omitempty"`
+}
+
+// Execute decodes the image, runs the pipeline and saves the original, every step
+// and the optional split view in out. onStep, if not nil, is called with the result
+// so far once the original and then each step is saved. Execution stops between
+// steps once ctx is done.
+func Execute(ctx context.Context, req Request, out *storage.JobOutput, onStep func(Result)) (Result, error) {
+	result := Result{Job: out.ID, Images: []string{}, Operations: []string{}}
+
+	src, profile, err := utils.DecodeImageProfile(bytes.NewReader(req.Image))
+	if err != nil {
+		return result, ErrInvalidImage
+	}
+	if profile != nil {
+		result.ColorProfile = profile.Name
+	}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+
+	url, err := save(out, "original", src, req.Format)
+	if err != nil {
+		return result, err
+	}
+	result.Images = append(result.Images, url)
+	if onStep != nil {
+		onStep(result)
+	}
+
+	processed, err := req.Pipeline.Run(src, func(step pipeline.StepResult) error {
+		// The comparison grid is kept lossless for download unless a format was requested
+		format := req.Format
+		if format == utils.FormatAuto && step.Operation == "grid" {
+			format = utils.FormatPNG
+		}
+		url, err := save(out, fmt.Sprintf("step_%d_%s", step.Index+1, step.Operation), step.Image, format)
+		if err != nil {
+			return err
+		}
+		result.Images = append(result.Images, url)
+		result.Operations = append(result.Operations, step.Operation)
+		if step.Report != nil {
+			result.Analysis = append(result.Analysis, Analysis{step.Index + 1, step.Operation, step.Report})
+		}
+
+		if onStep != nil {
+			onStep(result)
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return result, err
+	}
+
+	// Combine the original and final result into one comparison image
+	if req.Split != nil {
+		splitImage, err := utils.SplitView(src, processed, *req.Split)
+		if err != nil {
+			return result, err
+		}
+		if result.Split, err = save(out, "split_"+string(req.Split.Mode), splitImage, req.Format); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// save writes img to out as name plus the extension of the resolved format
+func save(out *storage.JobOutput, name string, img image.Image, format utils.ImageFormat) (string, error) {
+	format = format.Resolve(img)
+	return out.Write(name+"."+format.Extension(), func(w io.Writer) error {
+		return utils.EncodeImage(w, img, format)
+	})
+}
@@ -0,0 +1,280 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"color-blind-simulator-1/app/storage"
+)
+
+// Status is the state of a job
+type Status string
+
+const (
+	StatusQueued   Status = "queued"
+	StatusRunning  Status = "running"
+	StatusDone     Status = "done"
+	StatusFailed   Status = "failed"
+	StatusCanceled Status = "canceled"
+)
+
+// Finished reports whether the job has stopped for good
+func (s Status) Finished() bool {
+	return s == StatusDone || s == StatusFailed || s == StatusCanceled
+}
+
+var (
+	// ErrNotFound is returned for unknown or expired job IDs
+	ErrNotFound = errors.New("job not found")
+	// ErrFinished is returned when canceling a job that has already stopped
+	ErrFinished = errors.New("job already finished")
+)
+
+// DefaultQueueSize bounds the number of jobs waiting for a worker
+const DefaultQueueSize = 64
+
+// How often finished jobs past the output retention are forgotten
+const pruneInterval = 5 * time.Minute
+
+// Progress counts the completed steps of a job
+type Progress struct {
+	Completed int `json:"completed"`
+	Total     int `json:"total"`
+}
+
+// StepStatus reports one pipeline step of a job
+type StepStatus struct {
+	Index     int    `json:"index"`
+	Operation string `json:"operation"`
+	Done      bool   `json:"done"`
+	URL       string `json:"url,omitempty"`
+}
+
+// Snapshot is a copy of a job's state, safe to serialise
+type Snapshot struct {
+	ID         string       `json:"id"`
+	Status     Status       `json:"status"`
+	Progress   Progress     `json:"progress"`
+	Steps      []StepStatus `json:"steps"`
+	Result     Result       `json:"result"`
+	Error      string       `json:"error,omitempty"`
+	CreatedAt  time.Time    `json:"created_at"`
+	StartedAt  *time.Time   `json:"started_at,omitempty"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+}
+
+type job struct {
+	snapshot Snapshot
+	req      Request
+	cancel   context.CancelFunc
+}
+
+// Manager runs submitted jobs on a fixed pool of workers and keeps their state
+// until their output expires
+type Manager struct {
+	queue   Queue
+	outputs *storage.OutputStore
+	workers int
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+// NewManager creates a manager running at most workers jobs at once
+func NewManager(queue Queue, outputs *storage.OutputStore, workers int) *Manager {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Manager{queue: queue, outputs: outputs, workers: workers, jobs: map[string]*job{}}
+}
+
+// Start launches the workers and the pruning of expired jobs; they stop when ctx is done
+func (m *Manager) Start(ctx context.Context) {
+	for i := 0; i < m.workers; i++ {
+		go m.work(ctx)
+	}
+	go func() {
+		ticker := time.NewTicker(pruneInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				m.prune(now)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Submit queues a request and returns the new job's state
+func (m *Manager) Submit(req Request) (Snapshot, error) {
+	id, err := storage.NewJobID()
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	operations := req.Pipeline.Operations()
+	j := &job{
+		req: req,
+		snapshot: Snapshot{
+			ID:        id,
+			Status:    StatusQueued,
+			Progress:  Progress{Total: len(operations)},
+			Steps:     make([]StepStatus, len(operations)),
+			Result:    Result{Job: id, Images: []string{}, Operations: []string{}},
+			CreatedAt: time.Now(),
+		},
+	}
+	for i, op := range operations {
+		j.snapshot.Steps[i] = StepStatus{Index: i + 1, Operation: op}
+	}
+
+	m.mu.Lock()
+	m.jobs[id] = j
+	m.mu.Unlock()
+
+	if err := m.queue.Push(id); err != nil {
+		m.mu.Lock()
+		delete(m.jobs, id)
+		m.mu.Unlock()
+		return Snapshot{}, err
+	}
+	return m.Get(id)
+}
+
+// Get returns the current state of a job
+func (m *Manager) Get(id string) (Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return Snapshot{}, ErrNotFound
+	}
+	return j.snapshot.copy(), nil
+}
+
+// Cancel stops a queued or running job. A running job stops after its current step.
+func (m *Manager) Cancel(id string) (Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return Snapshot{}, ErrNotFound
+	}
+	switch j.snapshot.Status {
+	case StatusQueued:
+		j.finish(StatusCanceled, context.Canceled)
+	case StatusRunning:
+		j.cancel()
+	default:
+		return j.snapshot.copy(), ErrFinished
+	}
+	return j.snapshot.copy(), nil
+}
+
+// work runs queued jobs one at a time until ctx is done
+func (m *Manager) work(ctx context.Context) {
+	for {
+		id, err := m.queue.Pop(ctx)
+		if err != nil {
+			return
+		}
+		m.run(ctx, id)
+	}
+}
+
+// run executes one job, recording its progress as each step is saved
+func (m *Manager) run(ctx context.Context, id string) {
+	m.mu.Lock()
+	j, ok := m.jobs[id]
+	if !ok || j.snapshot.Status != StatusQueued {
+		// Canceled or expired while waiting
+		m.mu.Unlock()
+		return
+	}
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	j.cancel = cancel
+	j.snapshot.Status = StatusRunning
+	now := time.Now()
+	j.snapshot.StartedAt = &now
+	req := j.req
+	m.mu.Unlock()
+
+	out, err := m.outputs.Open(id)
+	var result Result
+	if err == nil {
+		result, err = Execute(jobCtx, req, out, func(partial Result) {
+			m.mu.Lock()
+			j.update(partial)
+			m.mu.Unlock()
+		})
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j.update(result)
+	switch {
+	case err == nil:
+		j.finish(StatusDone, nil)
+	case errors.Is(err, context.Canceled):
+		j.finish(StatusCanceled, err)
+	default:
+		log.Printf("Job %s failed: %v", id, err)
+		j.finish(StatusFailed, err)
+	}
+}
+
+// prune forgets finished jobs whose output has expired
+func (m *Manager) prune(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, j := range m.jobs {
+		if f := j.snapshot.FinishedAt; f != nil && now.Sub(*f) >= m.outputs.Retention() {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+// update records the steps completed so far; the caller holds the manager's lock
+func (j *job) update(result Result) {
+	if len(result.Images) == 0 {
+		return
+	}
+	j.snapshot.Result = result.copy()
+	// Images start with the original, followed by one per completed step
+	completed := len(result.Images) - 1
+	j.snapshot.Progress.Completed = completed
+	for i := 0; i < completed && i < len(j.snapshot.Steps); i++ {
+		j.snapshot.Steps[i].Done = true
+		j.snapshot.Steps[i].URL = result.Images[i+1]
+	}
+}
+
+// finish moves the job to a final status and releases the uploaded image
+func (j *job) finish(status Status, err error) {
+	j.snapshot.Status = status
+	if err != nil {
+		j.snapshot.Error = err.Error()
+	}
+	now := time.Now()
+	j.snapshot.FinishedAt = &now
+	j.req.Image = nil
+}
+
+func (s Snapshot) copy() Snapshot {
+	s.Steps = append([]StepStatus(nil), s.Steps...)
+	s.Result = s.Result.copy()
+	return s
+}
+
+func (r Result) copy() Result {
+	r.Images = append([]string{}, r.Images...)
+	r.Operations = append([]string{}, r.Operations...)
+	r.Analysis = append([]Analysis(nil), r.Analysis...)
+	return r
+}
@@ -0,0 +1,307 @@
+package jobs_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"color-blind-simulator-1/app/handlers"
+	"color-blind-simulator-1/app/jobs"
+	"color-blind-simulator-1/app/pipeline"
+	"color-blind-simulator-1/app/storage"
+	"color-blind-simulator-1/app/utils"
+)
+
+// How long a test waits for a job to reach an expected state
+const waitTimeout = 5 * time.Second
+
+// gate is the state of the test_gate operation, which blocks until released
+type gate struct {
+	entered chan struct{}
+	release chan struct{}
+	running atomic.Int32
+	peak    atomic.Int32
+}
+
+// currentGate is swapped by each test before it submits jobs using test_gate
+var currentGate atomic.Pointer[gate]
+
+func newGate(t *testing.T) *gate {
+	g := &gate{entered: make(chan struct{}, 64), release: make(chan struct{})}
+	currentGate.Store(g)
+	t.Cleanup(g.open)
+	return g
+}
+
+// open lets every blocked and future test_gate step finish
+func (g *gate) open() {
+	select {
+	case <-g.release:
+	default:
+		close(g.release)
+	}
+}
+
+// waitEntered waits until n steps have entered the gate
+func (g *gate) waitEntered(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-g.entered:
+		case <-time.After(waitTimeout):
+			t.Fatalf("only %d of %d gated steps started", i, n)
+		}
+	}
+}
+
+func init() {
+	utils.RegisterOperation(utils.Operation{Name: "test_gate", Code: 9001,
+		Run: func(img image.Image, p utils.Params) (image.Image, interface{}, error) {
+			g := currentGate.Load()
+			n := g.running.Add(1)
+			for peak := g.peak.Load(); n > peak && !g.peak.CompareAndSwap(peak, n); peak = g.peak.Load() {
+			}
+			g.entered <- struct{}{}
+			<-g.release
+			g.running.Add(-1)
+			return img, nil, nil
+		}})
+}
+
+// newManager starts a manager writing to a temporary output store
+func newManager(t *testing.T, queueSize, workers int) *jobs.Manager {
+	t.Helper()
+	outputs, err := storage.NewOutputStore(t.TempDir(), "/output", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := jobs.NewManager(jobs.NewMemoryQueue(queueSize), outputs, workers)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	m.Start(ctx)
+	return m
+}
+
+func testImage(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for i := 0; i < len(img.Pix); i += 4 {
+		copy(img.Pix[i:], []byte{200, 80, 40, 255})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func request(t *testing.T, operations ...string) jobs.Request {
+	t.Helper()
+	spec := pipeline.Spec{}
+	for _, op := range operations {
+		spec.Steps = append(spec.Steps, pipeline.Step{Operation: op})
+	}
+	p, err := pipeline.Compile(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jobs.Request{Pipeline: p, Image: testImage(t), Format: utils.FormatPNG}
+}
+
+// waitFor polls the job until check accepts its state
+func waitFor(t *testing.T, m *jobs.Manager, id string, check func(jobs.Snapshot) bool) jobs.Snapshot {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		s, err := m.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if check(s) {
+			return s
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s stuck in %+v", id, s)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func finished(s jobs.Snapshot) bool { return s.Status.Finished() }
+
+// serve sends a request to the jobs handler and decodes the snapshot it returns
+func serve(t *testing.T, h http.Handler, r *http.Request, wantCode int) jobs.Snapshot {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != wantCode {
+		t.Fatalf("%s %s: status %d, want %d: %s", r.Method, r.URL, w.Code, wantCode, w.Body)
+	}
+	var s jobs.Snapshot
+	if wantCode < 300 {
+		if err := json.NewDecoder(w.Body).Decode(&s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+// postJob builds a POST /api/jobs upload running the given JSON pipeline
+func postJob(t *testing.T, spec string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("pipeline", spec)
+	fw, err := mw.CreateFormFile("image", "test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(testImage(t))
+	mw.Close()
+	r := httptest.NewRequest(http.MethodPost, "/api/jobs?format=png", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestJobSubmitProgressDone(t *testing.T) {
+	g := newGate(t)
+	m := newManager(t, 4, 1)
+	h := handlers.JobsHandler(m)
+
+	s := serve(t, h, postJob(t, `{"steps":[{"operation":"grayscale"},{"operation":"test_gate"}]}`), http.StatusAccepted)
+	if s.Status != jobs.StatusQueued && s.Status != jobs.StatusRunning {
+		t.Fatalf("new job has status %s", s.Status)
+	}
+	if s.Progress.Total != 2 {
+		t.Fatalf("progress total %d, want 2", s.Progress.Total)
+	}
+
+	// The first step is reported while the second is still running
+	g.waitEntered(t, 1)
+	s = serve(t, h, httptest.NewRequest(http.MethodGet, "/api/jobs/"+s.ID, nil), http.StatusOK)
+	if s.Status != jobs.StatusRunning || s.Progress.Completed != 1 || !s.Steps[0].Done || s.Steps[1].Done {
+		t.Fatalf("mid-run state %+v, want running with step 1 of 2 done", s)
+	}
+
+	g.open()
+	s = waitFor(t, m, s.ID, finished)
+	if s.Status != jobs.StatusDone || s.Progress.Completed != 2 {
+		t.Fatalf("final state %+v, want done with 2 steps", s)
+	}
+	if len(s.Result.Images) != 3 {
+		t.Fatalf("result has %d images, want the original and 2 steps", len(s.Result.Images))
+	}
+	for _, step := range s.Steps {
+		if !step.Done || step.URL == "" {
+			t.Errorf("step %+v not reported as saved", step)
+		}
+	}
+}
+
+func TestJobDeleteCancelsRunningJob(t *testing.T) {
+	g := newGate(t)
+	m := newManager(t, 4, 1)
+	h := handlers.JobsHandler(m)
+
+	s := serve(t, h, postJob(t, `{"steps":[{"operation":"test_gate"},{"operation":"grayscale"}]}`), http.StatusAccepted)
+	g.waitEntered(t, 1)
+
+	s = serve(t, h, httptest.NewRequest(http.MethodDelete, "/api/jobs/"+s.ID, nil), http.StatusOK)
+	if s.Status != jobs.StatusRunning {
+		t.Fatalf("status right after DELETE is %s, want running until the step ends", s.Status)
+	}
+
+	// The job stops once the current step returns
+	g.open()
+	s = waitFor(t, m, s.ID, finished)
+	if s.Status != jobs.StatusCanceled {
+		t.Fatalf("status %s, want canceled", s.Status)
+	}
+	if s.Steps[1].Done {
+		t.Errorf("step after the cancellation ran")
+	}
+
+	// A second DELETE finds the job already finished
+	serve(t, h, httptest.NewRequest(http.MethodDelete, "/api/jobs/"+s.ID, nil), http.StatusConflict)
+}
+
+func TestJobQueueFullRejectsSubmit(t *testing.T) {
+	g := newGate(t)
+	m := newManager(t, 1, 1)
+
+	// One job occupies the worker and one fills the queue
+	running, err := m.Submit(request(t, "test_gate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.waitEntered(t, 1)
+	queued, err := m.Submit(request(t, "test_gate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Submit(request(t, "grayscale")); !errors.Is(err, jobs.ErrQueueFull) {
+		t.Fatalf("submit to a full queue returned %v, want ErrQueueFull", err)
+	}
+	serve(t, handlers.JobsHandler(m), postJob(t, `{"steps":[{"operation":"grayscale"}]}`), http.StatusServiceUnavailable)
+
+	// Canceling a queued job stops it before it starts
+	s, err := m.Cancel(queued.ID)
+	if err != nil || s.Status != jobs.StatusCanceled {
+		t.Fatalf("cancel of queued job gave %s, %v", s.Status, err)
+	}
+	g.open()
+	if s := waitFor(t, m, running.ID, finished); s.Status != jobs.StatusDone {
+		t.Fatalf("running job ended %s, want done", s.Status)
+	}
+}
+
+func TestJobWorkersBoundConcurrency(t *testing.T) {
+	const workers, submitted = 2, 6
+	g := newGate(t)
+	m := newManager(t, submitted, workers)
+
+	var ids []string
+	for i := 0; i < submitted; i++ {
+		s, err := m.Submit(request(t, "test_gate"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, s.ID)
+	}
+
+	g.waitEntered(t, workers)
+	// Give any extra worker a chance to start a job it should not have
+	time.Sleep(50 * time.Millisecond)
+	if n := g.running.Load(); n != workers {
+		t.Fatalf("%d jobs running, want %d", n, workers)
+	}
+	queued := 0
+	for _, id := range ids {
+		if s, _ := m.Get(id); s.Status == jobs.StatusQueued {
+			queued++
+		}
+	}
+	if queued != submitted-workers {
+		t.Errorf("%d jobs queued, want %d", queued, submitted-workers)
+	}
+
+	g.open()
+	for _, id := range ids {
+		if s := waitFor(t, m, id, finished); s.Status != jobs.StatusDone {
+			t.Errorf("job %s ended %s", id, s.Status)
+		}
+	}
+	if peak := g.peak.Load(); peak > workers {
+		t.Errorf("%d jobs ran at once, want at most %d", peak, workers)
+	}
+}
@@ -0,0 +1,53 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrQueueFull is returned when no more jobs can be queued
+var ErrQueueFull = errors.New("job queue is full")
+
+// Queue hands job IDs from Submit to the workers. Implementations must be safe
+// for concurrent use.
+type Queue interface {
+	// Push queues a job ID, returning ErrQueueFull if there is no room
+	Push(id string) error
+	// Pop blocks until a job ID is available or ctx is done
+	Pop(ctx context.Context) (string, error)
+}
+
+// MemoryQueue is a bounded in-process queue
+type MemoryQueue struct {
+	ids chan string
+}
+
+// NewMemoryQueue creates a queue holding at most size job IDs
+func NewMemoryQueue(size int) *MemoryQueue {
+	return &MemoryQueue{ids: make(chan string, size)}
+}
+
+// Push queues a job ID without blocking
+func (q *MemoryQueue) Push(id string) error {
+	select {
+	case q.ids <- id:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Pop waits for the next job ID
+func (q *MemoryQueue) Pop(ctx context.Context) (string, error) {
+	select {
+	case id := <-q.ids:
+		return id, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Len returns the number of queued job IDs
+func (q *MemoryQueue) Len() int {
+	return len(q.ids)
+}
@@ -0,0 +1,39 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"color-blind-simulator-1/app/utils"
+)
+
+// SpecFromRequest reads a JSON pipeline from the "pipeline" form field or query
+// parameter, falling back to the repeated operation query parameters
+func SpecFromRequest(r *http.Request) (Spec, error) {
+	if raw := r.FormValue("pipeline"); raw != "" {
+		return ParseSpec([]byte(raw))
+	}
+	return SpecFromQuery(r.URL.Query())
+}
+
+// FormatFromRequest reads the output image format from the format parameter or,
+// failing that, from image types listed in the Accept header
+func FormatFromRequest(r *http.Request) (utils.ImageFormat, error) {
+	if name := r.FormValue("format"); name != "" {
+		return utils.ParseImageFormat(name)
+	}
+	return utils.NegotiateFormat(r.Header.Get("Accept")), nil
+}
+
+// WriteError reports an invalid pipeline as JSON, listing every failing step when available
+func WriteError(w http.ResponseWriter, err error) {
+	body := map[string]interface{}{"error": err.Error()}
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		body["steps"] = verr.Steps
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(body)
+}
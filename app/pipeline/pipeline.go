@@ -0,0 +1,132 @@
+package pipeline
+
+import (
+	"fmt"
+	"image"
+	"strings"
+
+	"color-blind-simulator-1/app/utils"
+)
+
+// MaxSteps bounds the length of a pipeline
+const MaxSteps = 32
+
+// Step is one operation in a pipeline spec with its own parameters
+type Step struct {
+	Operation string                 `json:"operation"`
+	Params    map[string]interface{} `json:"params,omitempty"`
+}
+
+// Spec is the JSON description of a pipeline
+type Spec struct {
+	Steps []Step `json:"steps"`
+	// Split, set from the request, asks for a comparison of the original and final
+	// image, so every step must keep the image size
+	Split *utils.SplitOptions `json:"-"`
+}
+
+// StepError reports why a single step of a spec is invalid
+type StepError struct {
+	Index     int    `json:"index"`
+	Operation string `json:"operation"`
+	Message   string `json:"message"`
+}
+
+func (e StepError) Error() string {
+	return fmt.Sprintf("step %d (%s): %s", e.Index+1, e.Operation, e.Message)
+}
+
+// ValidationError lists every invalid step of a spec
+type ValidationError struct {
+	Steps []StepError `json:"steps"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Steps))
+	for i, s := range e.Steps {
+		messages[i] = s.Error()
+	}
+	return "invalid pipeline: " + strings.Join(messages, "; ")
+}
+
+// Pipeline is a validated sequence of operations ready to run
+type Pipeline struct {
+	steps []compiledStep
+}
+
+type compiledStep struct {
+	op     utils.Operation
+	params utils.Params
+}
+
+// StepResult is passed to the callback after each step finishes
+type StepResult struct {
+	Index     int
+	Operation string
+	Image     image.Image
+	Report    interface{}
+}
+
+// Compile validates every step of the spec up front, filling in parameter defaults.
+// With a split view, steps that change the image size are rejected. All invalid
+// steps are reported together in a *ValidationError.
+func Compile(spec Spec) (*Pipeline, error) {
+	if len(spec.Steps) > MaxSteps {
+		return nil, fmt.Errorf("pipeline has %d steps, at most %d are allowed", len(spec.Steps), MaxSteps)
+	}
+
+	p := &Pipeline{}
+	verr := &ValidationError{}
+	for i, step := range spec.Steps {
+		op, ok := utils.LookupOperation(step.Operation)
+		if !ok {
+			verr.Steps = append(verr.Steps, StepError{i, step.Operation, "unknown operation"})
+			continue
+		}
+
+		params, errs := utils.ValidateParams(op, step.Params)
+		for _, err := range errs {
+			verr.Steps = append(verr.Steps, StepError{i, step.Operation, err.Error()})
+		}
+		if len(errs) == 0 && spec.Split != nil && op.ChangesSize != nil && op.ChangesSize(params) {
+			verr.Steps = append(verr.Steps, StepError{i, step.Operation, "changes the image size, so it cannot be used with a split view"})
+		}
+		p.steps = append(p.steps, compiledStep{op, params})
+	}
+	if len(verr.Steps) > 0 {
+		return nil, verr
+	}
+	return p, nil
+}
+
+// Len returns the number of steps
+func (p *Pipeline) Len() int {
+	return len(p.steps)
+}
+
+// Operations returns the operation name of each step in order
+func (p *Pipeline) Operations() []string {
+	names := make([]string, len(p.steps))
+	for i, step := range p.steps {
+		names[i] = step.op.Name
+	}
+	return names
+}
+
+// Run applies each step in order, calling onStep with every intermediate result.
+// It stops at the first step or callback that fails.
+func (p *Pipeline) Run(img image.Image, onStep func(StepResult) error) (image.Image, error) {
+	for i, step := range p.steps {
+		out, report, err := step.op.Run(img, step.params)
+		if err != nil {
+			return nil, StepError{i, step.op.Name, err.Error()}
+		}
+		img = out
+		if onStep != nil {
+			if err := onStep(StepResult{Index: i, Operation: step.op.Name, Image: img, Report: report}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return img, nil
+}
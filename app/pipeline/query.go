@@ -0,0 +1,135 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"color-blind-simulator-1/app/utils"
+)
+
+// Query parameters shared by every step in the legacy query-string form, keyed by
+// the step parameter they fill in
+var sharedQueryParams = map[string]string{
+	"angle":         "angle",
+	"model":         "model",
+	"deficiency":    "deficiency",
+	"columns":       "grid_columns",
+	"width":         "grid_width",
+	"captions":      "captions",
+	"min_contrast":  "min_contrast",
+	"fill":          "fill",
+	"fit":           "fit",
+	"interpolation": "interpolation",
+}
+
+// Query parameters read by a single operation in the legacy query-string form, for
+// parameters whose names other operations use with a different meaning. Daltonize
+// switches from its legacy filter when given a deficiency, so the shared deficiency
+// does not apply to it.
+var operationQueryParams = map[string]map[string]string{
+	"daltonize":   {"deficiency": "daltonize_deficiency"},
+	"crop":        {"x": "crop_x", "y": "crop_y", "width": "crop_width", "height": "crop_height"},
+	"resize":      {"width": "resize_width", "height": "resize_height", "filter": "resize_filter"},
+	"affine":      {"matrix": "affine_matrix"},
+	"perspective": {"matrix": "perspective_matrix"},
+}
+
+// ParseSpec decodes a JSON pipeline spec, rejecting unknown fields
+func ParseSpec(data []byte) (Spec, error) {
+	var spec Spec
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil {
+		return Spec{}, fmt.Errorf("invalid pipeline spec: %v", err)
+	}
+	return spec, nil
+}
+
+// SpecFromQuery converts the legacy form, repeated operation parameters such as
+// operation=protanomaly:0.4 with shared angle, model and deficiency parameters,
+// into a spec. A value after the colon is the severity of a simulation or the
+// strength of a correction; recolor_<algorithm> selects a recoloring algorithm.
+func SpecFromQuery(query url.Values) (Spec, error) {
+	var spec Spec
+	for _, raw := range query["operation"] {
+		name, amount, hasAmount := strings.Cut(raw, ":")
+		step := Step{Operation: name, Params: map[string]interface{}{}}
+		if algorithm, ok := strings.CutPrefix(name, "recolor_"); ok {
+			step.Operation = "recolor"
+			step.Params["algorithm"] = algorithm
+		}
+
+		op, ok := utils.LookupOperation(step.Operation)
+		if !ok {
+			// Leave unknown operations for Compile to report
+			spec.Steps = append(spec.Steps, step)
+			continue
+		}
+
+		if hasAmount && !hasParam(op, "severity") && !hasParam(op, "strength") {
+			return Spec{}, fmt.Errorf("operation %s: %s takes no severity or strength after the colon", raw, op.Name)
+		}
+		for _, param := range op.Params {
+			key, ok := operationQueryParams[op.Name][param.Name]
+			if !ok {
+				key = sharedQueryParams[param.Name]
+			}
+			value := query.Get(key)
+			if hasAmount && (param.Name == "severity" || param.Name == "strength") {
+				value = amount
+			}
+			if value == "" {
+				continue
+			}
+			v, err := param.ParseString(value)
+			if err != nil {
+				return Spec{}, fmt.Errorf("operation %s: %v", raw, err)
+			}
+			step.Params[param.Name] = v
+		}
+		spec.Steps = append(spec.Steps, step)
+	}
+	return spec, nil
+}
+
+// hasParam reports whether the operation accepts the named parameter
+func hasParam(op utils.Operation, name string) bool {
+	for _, param := range op.Params {
+		if param.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitOptionsFromQuery reads the split, split_x, split_y and lens_radius query
+// parameters. It returns nil when no split view was requested.
+func SplitOptionsFromQuery(query url.Values) (*utils.SplitOptions, error) {
+	name := query.Get("split")
+	if name == "" {
+		return nil, nil
+	}
+	mode, err := utils.ParseSplitMode(name)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := utils.DefaultSplitOptions(mode)
+	for param, field := range map[string]*float64{"split_x": &opts.X, "split_y": &opts.Y, "lens_radius": &opts.Radius} {
+		if v := query.Get(param); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q: must be a number", param, v)
+			}
+			*field = f
+		}
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return &opts, nil
+}
@@ -0,0 +1,90 @@
+package pipeline_test
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"color-blind-simulator-1/app/pipeline"
+)
+
+func TestSpecFromQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []pipeline.Step
+	}{
+		{"operation=protanomaly:0.4&model=machado", []pipeline.Step{
+			{Operation: "protanomaly", Params: map[string]interface{}{"model": "machado", "severity": 0.4}},
+		}},
+		{"operation=daltonize_tritanopia:0.8", []pipeline.Step{
+			{Operation: "daltonize_tritanopia", Params: map[string]interface{}{"strength": 0.8}},
+		}},
+		{"operation=recolor_daltonize&deficiency=deutan", []pipeline.Step{
+			{Operation: "recolor", Params: map[string]interface{}{"algorithm": "daltonize", "deficiency": "deutan"}},
+		}},
+		{"operation=crop&crop_x=2&width=50", []pipeline.Step{
+			{Operation: "crop", Params: map[string]interface{}{"x": 2.0}},
+		}},
+		// The shared deficiency would move daltonize off its legacy filter
+		{"operation=daltonize&operation=confusion_map&deficiency=tritan", []pipeline.Step{
+			{Operation: "daltonize", Params: map[string]interface{}{}},
+			{Operation: "confusion_map", Params: map[string]interface{}{"deficiency": "tritan"}},
+		}},
+		{"operation=daltonize&daltonize_deficiency=deutan", []pipeline.Step{
+			{Operation: "daltonize", Params: map[string]interface{}{"deficiency": "deutan"}},
+		}},
+	}
+	for _, tt := range tests {
+		query, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		spec, err := pipeline.SpecFromQuery(query)
+		if err != nil {
+			t.Errorf("%s: %v", tt.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(spec.Steps, tt.want) {
+			t.Errorf("%s: steps %+v, want %+v", tt.query, spec.Steps, tt.want)
+		}
+	}
+}
+
+func TestSpecFromQueryRejectsUnusedAmounts(t *testing.T) {
+	for _, query := range []string{"operation=rotate:30", "operation=flip:0.5", "operation=grayscale&operation=achromatopsia:1"} {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if spec, err := pipeline.SpecFromQuery(values); err == nil {
+			t.Errorf("%s: got steps %+v, want an error", query, spec.Steps)
+		}
+	}
+}
+
+func TestCompileRejectsInvalidCombinations(t *testing.T) {
+	tests := []struct {
+		name string
+		step pipeline.Step
+		want string
+	}{
+		{"legacy daltonize with a LUT", pipeline.Step{Operation: "daltonize", Params: map[string]interface{}{"lut": "trilinear"}}, "lut"},
+	}
+	for _, tt := range tests {
+		_, err := pipeline.Compile(pipeline.Spec{Steps: []pipeline.Step{tt.step}})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want one mentioning %q", tt.name, err, tt.want)
+		}
+	}
+
+	// The LUT is fine once the request leaves the legacy filter
+	for _, params := range []map[string]interface{}{
+		{"lut": "trilinear", "deficiency": "deutan"},
+		{"lut": "tetrahedral", "strength": 0.5},
+	} {
+		if _, err := pipeline.Compile(pipeline.Spec{Steps: []pipeline.Step{{Operation: "daltonize", Params: params}}}); err != nil {
+			t.Errorf("daltonize with %v: %v", params, err)
+		}
+	}
+}
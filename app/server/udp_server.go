@@ -3,15 +3,14 @@ package server
 import (
 	"bytes"
 	"encoding/binary"
-	"image"
+	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"net"
-	"os"
-	"path/filepath"
 
+	"color-blind-simulator-1/app/storage"
 	"color-blind-simulator-1/app/utils"
-
-	"github.com/disintegration/imaging"
 )
 
 const (
@@ -19,28 +18,16 @@ const (
 	MaxSize = 65507 // Maximum UDP packet size
 )
 
-// Operation types
-const (
-	OpFlip          = 1
-	OpRotate        = 2
-	OpRotateShear   = 3
-	OpGrayscale     = 4
-	OpBoxBlur       = 5
-	OpGaussianBlur  = 6
-	OpEdgeDetection = 7
-	OpProtanopia    = 8
-	OpDeuteranopia  = 9
-	OpTritanopia    = 10
-	OpProtanomaly   = 11
-	OpDeuteranomaly = 12
-	OpTritanomaly   = 13
-	OpAchromatopsia = 14
-	OpMonochromacy  = 15
-	OpDaltonize     = 16
-)
+// udpDefaults are parameters the UDP protocol applied before packets could carry
+// their own; they still apply to any of these parameters a packet leaves out
+var udpDefaults = map[uint32]map[string]interface{}{
+	utils.OpRotate:      {"angle": 45.0},
+	utils.OpRotateShear: {"angle": 45.0},
+}
 
-// StartUDPServer starts a UDP server for image processing
-func StartUDPServer() {
+// StartUDPServer starts a UDP server for image processing. Each packet's result
+// is saved in its own job directory of outputs.
+func StartUDPServer(outputs *storage.OutputStore) {
 	addr, err := net.ResolveUDPAddr("udp", UDPPort)
 	if err != nil {
 		log.Fatal(err)
@@ -63,80 +50,82 @@ func StartUDPServer() {
 		}
 
 		// Process the received data
-		go handleUDPPacket(conn, remoteAddr, buffer[:n])
+		go handleUDPPacket(conn, remoteAddr, bytes.Clone(buffer[:n]), outputs)
 	}
 }
 
-func handleUDPPacket(conn *net.UDPConn, addr *net.UDPAddr, data []byte) {
+func handleUDPPacket(conn *net.UDPConn, addr *net.UDPAddr, data []byte, outputs *storage.OutputStore) {
 	// First 4 bytes are the operation type
 	if len(data) < 4 {
 		return
 	}
 
 	opType := binary.BigEndian.Uint32(data[:4])
-	imageData := data[4:]
+	op, ok := utils.OperationByCode(opType)
+	if !ok {
+		log.Printf("Unknown UDP operation type %d", opType)
+		return
+	}
 
-	// Create output directory if it doesn't exist
-	outputDir := "output"
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		log.Printf("Error creating output directory: %v", err)
+	// An optional JSON object of parameters, ended by a newline, may follow the
+	// operation type. Encoded images never start with '{'.
+	imageData := data[4:]
+	var raw map[string]interface{}
+	if len(imageData) > 0 && imageData[0] == '{' {
+		end := bytes.IndexByte(imageData, '\n')
+		if end < 0 {
+			log.Printf("Unterminated parameters for operation %s", op.Name)
+			return
+		}
+		if err := json.Unmarshal(imageData[:end], &raw); err != nil {
+			log.Printf("Invalid parameters for operation %s: %v", op.Name, err)
+			return
+		}
+		imageData = imageData[end+1:]
+	}
+	for name, value := range udpDefaults[opType] {
+		if _, given := raw[name]; !given {
+			if raw == nil {
+				raw = map[string]interface{}{}
+			}
+			raw[name] = value
+		}
+	}
+	params, errs := utils.ValidateParams(op, raw)
+	if len(errs) > 0 {
+		log.Printf("Invalid parameters for operation %s: %v", op.Name, errors.Join(errs...))
 		return
 	}
 
 	// Decode the image
-	src, err := imaging.Decode(bytes.NewReader(imageData))
+	src, err := utils.DecodeImage(bytes.NewReader(imageData))
 	if err != nil {
 		log.Printf("Error decoding image: %v", err)
 		return
 	}
 
-	// Process the image based on operation type
-	var processedImage image.Image
-	switch opType {
-	case OpFlip:
-		processedImage = utils.FlipImage(src)
-	case OpRotate:
-		processedImage = utils.RotateImage(src, 45) // Default 45-degree rotation
-	case OpRotateShear:
-		processedImage = utils.RotateImageWithShear(src, 45)
-	case OpGrayscale:
-		processedImage = utils.ConvertToGrayscale(src)
-	case OpBoxBlur:
-		processedImage = utils.ApplyBoxBlur(src)
-	case OpGaussianBlur:
-		processedImage = utils.ApplyGaussianBlur(src)
-	case OpEdgeDetection:
-		processedImage = utils.ApplyEdgeDetection(src)
-	case OpProtanopia:
-		processedImage = utils.SimulateColorBlindness(src, utils.ProtanopiaMatrix)
-	case OpDeuteranopia:
-		processedImage = utils.SimulateColorBlindness(src, utils.DeuteranopiaMatrix)
-	case OpTritanopia:
-		processedImage = utils.SimulateColorBlindness(src, utils.TritanopiaMatrix)
-	case OpProtanomaly:
-		processedImage = utils.SimulateColorBlindness(src, utils.ProtanomalyMatrix)
-	case OpDeuteranomaly:
-		processedImage = utils.SimulateColorBlindness(src, utils.DeuteranomalyMatrix)
-	case OpTritanomaly:
-		processedImage = utils.SimulateColorBlindness(src, utils.TritanomalyMatrix)
-	case OpAchromatopsia:
-		processedImage = utils.SimulateColorBlindness(src, utils.AchromatopsiaMatrix)
-	case OpMonochromacy:
-		processedImage = utils.SimulateColorBlindness(src, utils.MonochromacyMatrix)
-	case OpDaltonize:
-		processedImage = utils.Daltonize(src, utils.ProtanopiaMatrix)
-	default:
-		processedImage = src
+	processedImage, _, err := op.Run(src, params)
+	if err != nil {
+		log.Printf("Error processing image: %v", err)
+		return
 	}
 
 	// Save the processed image
-	filename := filepath.Join(outputDir, "udp_processed.jpg")
-	if err := imaging.Save(processedImage, filename); err != nil {
+	job, err := outputs.Create()
+	if err != nil {
+		log.Printf("Error creating job output: %v", err)
+		return
+	}
+	format := utils.FormatAuto.Resolve(processedImage)
+	url, err := job.Write("udp_processed."+format.Extension(), func(w io.Writer) error {
+		return utils.EncodeImage(w, processedImage, format)
+	})
+	if err != nil {
 		log.Printf("Error saving processed image: %v", err)
 		return
 	}
 
-	// Send acknowledgment
-	response := []byte("Image processed successfully")
+	// Send acknowledgment with the location of the result
+	response := []byte("Image processed successfully: " + url)
 	conn.WriteToUDP(response, addr)
 }
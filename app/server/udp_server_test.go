@@ -0,0 +1,108 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"color-blind-simulator-1/app/storage"
+	"color-blind-simulator-1/app/utils"
+
+	"github.com/disintegration/imaging"
+)
+
+// udpSource is a translucent gradient, so every result is saved as a lossless PNG
+func udpSource() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 24, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 24; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(10 * x), uint8(15 * y), 128, 200})
+		}
+	}
+	return img
+}
+
+// sendUDP runs one packet through the handler and decodes the image it saved
+func sendUDP(t *testing.T, code uint32, params string, src image.Image) image.Image {
+	t.Helper()
+	dir := t.TempDir()
+	outputs, err := storage.NewOutputStore(dir, "/output", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var packet bytes.Buffer
+	binary.Write(&packet, binary.BigEndian, code)
+	packet.WriteString(params)
+	if err := png.Encode(&packet, src); err != nil {
+		t.Fatal(err)
+	}
+	handleUDPPacket(server, client.LocalAddr().(*net.UDPAddr), packet.Bytes(), outputs)
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply := make([]byte, 1024)
+	n, err := client.Read(reply)
+	if err != nil {
+		t.Fatalf("no reply: %v", err)
+	}
+	url, ok := strings.CutPrefix(string(reply[:n]), "Image processed successfully: /output/")
+	if !ok {
+		t.Fatalf("unexpected reply %q", reply[:n])
+	}
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(url)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	out, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestUDPBareRotateCodeRotatesBy45Degrees(t *testing.T) {
+	src := udpSource()
+	tests := []struct {
+		name   string
+		code   uint32
+		params string
+		want   image.Image
+	}{
+		{"rotate", utils.OpRotate, "", utils.RotateImage(src, 45)},
+		{"rotate_shear with other parameters", utils.OpRotateShear, "{\"antialias\": false}\n",
+			utils.RotateShear(src, 45, utils.ShearRotateOptions{})},
+		{"rotate_shear", utils.OpRotateShear, "",
+			utils.RotateShear(src, 45, utils.ShearRotateOptions{Antialias: true})},
+		{"explicit angle", utils.OpRotate, "{\"angle\": 0}\n", src},
+	}
+	for _, tt := range tests {
+		got := imaging.Clone(sendUDP(t, tt.code, tt.params, src))
+		want := imaging.Clone(tt.want)
+		if got.Rect.Size() != want.Rect.Size() {
+			t.Errorf("%s: %v image, want %v", tt.name, got.Rect.Size(), want.Rect.Size())
+			continue
+		}
+		if !bytes.Equal(got.Pix, want.Pix) {
+			t.Errorf("%s: pixels differ from the expected rotation", tt.name)
+		}
+	}
+}
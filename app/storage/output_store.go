@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// DefaultRetention is how long a job's output is kept after it was last written
+const DefaultRetention = time.Hour
+
+// Length in bytes of the random part of a job ID
+const jobIDBytes = 16
+
+// OutputStore gives each job its own directory under root and expires old ones
+type OutputStore struct {
+	root      string
+	urlPrefix string
+	retention time.Duration
+}
+
+// JobOutput is the output directory of a single job
+type JobOutput struct {
+	ID    string
+	dir   string
+	store *OutputStore
+}
+
+func init() {
+	// Not in Go's built-in table, and the sniffer does not recognise TIFF
+	mime.AddExtensionType(".tiff", "image/tiff")
+}
+
+// NewOutputStore creates the root directory if needed. Files are served under urlPrefix.
+func NewOutputStore(root, urlPrefix string, retention time.Duration) (*OutputStore, error) {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &OutputStore{root: root, urlPrefix: strings.TrimSuffix(urlPrefix, "/"), retention: retention}, nil
+}
+
+// NewJobID returns a random, URL-safe job identifier
+func NewJobID() (string, error) {
+	b := make([]byte, jobIDBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ValidJobID reports whether id has the form produced by NewJobID
+func ValidJobID(id string) bool {
+	if len(id) != 2*jobIDBytes {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// Retention returns how long job output is kept
+func (s *OutputStore) Retention() time.Duration {
+	return s.retention
+}
+
+// Create makes an empty output directory for a new job
+func (s *OutputStore) Create() (*JobOutput, error) {
+	id, err := NewJobID()
+	if err != nil {
+		return nil, err
+	}
+	return s.Open(id)
+}
+
+// Open returns the output directory of a job, creating it if needed
+func (s *OutputStore) Open(id string) (*JobOutput, error) {
+	if !ValidJobID(id) {
+		return nil, fmt.Errorf("invalid job ID %q", id)
+	}
+	dir := filepath.Join(s.root, id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &JobOutput{ID: id, dir: dir, store: s}, nil
+}
+
+// Remove deletes a job's output directory
+func (s *OutputStore) Remove(id string) error {
+	if !ValidJobID(id) {
+		return fmt.Errorf("invalid job ID %q", id)
+	}
+	return os.RemoveAll(filepath.Join(s.root, id))
+}
+
+// Save encodes img under name in the job directory, choosing the format from the
+// extension, and returns the URL it is served at
+func (j *JobOutput) Save(name string, img image.Image) (string, error) {
+	if err := imaging.Save(img, filepath.Join(j.dir, name)); err != nil {
+		return "", err
+	}
+	return j.URL(name), nil
+}
+
+// Write creates name in the job directory, fills it with write and returns the URL
+// it is served at. A failed write leaves no file behind.
+func (j *JobOutput) Write(name string, write func(io.Writer) error) (string, error) {
+	filename := filepath.Join(j.dir, name)
+	f, err := os.Create(filename)
+	if err != nil {
+		return "", err
+	}
+	if err = write(f); err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		os.Remove(filename)
+		return "", err
+	}
+	return j.URL(name), nil
+}
+
+// Path returns the file system path of name in the job directory
+func (j *JobOutput) Path(name string) string {
+	return filepath.Join(j.dir, name)
+}
+
+// URL returns the URL a file of the job is served at
+func (j *JobOutput) URL(name string) string {
+	return path.Join(j.store.urlPrefix, j.ID, name)
+}
+
+// Expire removes job directories not modified within the retention period. Anything
+// in root that is not a job directory is left alone.
+func (s *OutputStore) Expire(now time.Time) (int, error) {
+	entries, err := os.ReadDir(s.root)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if !entry.IsDir() || !ValidJobID(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if now.Sub(info.ModTime()) < s.retention {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(s.root, entry.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// StartExpiry removes expired job directories every interval until stop is closed
+func (s *OutputStore) StartExpiry(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				n, err := s.Expire(now)
+				if err != nil {
+					log.Printf("Error expiring job output: %v", err)
+				}
+				if n > 0 {
+					log.Printf("Expired output of %d jobs", n)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Handler serves files as /{jobID}/{name} without listing directories, so one
+// client cannot discover another's job IDs
+func (s *OutputStore) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, name, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if !ok || !ValidJobID(id) || name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+			http.NotFound(w, r)
+			return
+		}
+		filename := filepath.Join(s.root, id, name)
+		if info, err := os.Stat(filename); err != nil || !info.Mode().IsRegular() {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, filename)
+	})
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newStore(t *testing.T) (*OutputStore, string) {
+	t.Helper()
+	root := t.TempDir()
+	store, err := NewOutputStore(root, "/output/", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, root
+}
+
+func TestValidJobID(t *testing.T) {
+	id, err := NewJobID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{id, true},
+		{strings.Repeat("ab", jobIDBytes), true},
+		{"", false},
+		{strings.Repeat("ab", jobIDBytes-1), false},
+		{strings.Repeat("zz", jobIDBytes), false},
+		{"../" + strings.Repeat("a", 2*jobIDBytes-3), false},
+	}
+	for _, tt := range tests {
+		if got := ValidJobID(tt.id); got != tt.want {
+			t.Errorf("ValidJobID(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+	if other, _ := NewJobID(); other == id {
+		t.Errorf("two job IDs are both %s", id)
+	}
+}
+
+func TestJobOutputsAreSeparate(t *testing.T) {
+	store, root := newStore(t)
+	first, err := store.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, job := range []*JobOutput{first, second} {
+		url, err := job.Write("step_1.png", func(w io.Writer) error {
+			_, err := io.WriteString(w, job.ID)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "/output/" + job.ID + "/step_1.png"; url != want {
+			t.Errorf("URL %q, want %q", url, want)
+		}
+	}
+	for _, job := range []*JobOutput{first, second} {
+		data, err := os.ReadFile(filepath.Join(root, job.ID, "step_1.png"))
+		if err != nil || string(data) != job.ID {
+			t.Errorf("job %s reads back %q, %v", job.ID, data, err)
+		}
+	}
+
+	// A failed write leaves nothing behind
+	if _, err := first.Write("broken.png", func(io.Writer) error { return errors.New("encoder failed") }); err == nil {
+		t.Error("a failed write succeeded")
+	}
+	if _, err := os.Stat(first.Path("broken.png")); !os.IsNotExist(err) {
+		t.Errorf("a failed write left a file behind: %v", err)
+	}
+
+	if err := store.Remove(first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(second.Path("step_1.png")); err != nil {
+		t.Errorf("removing one job touched another: %v", err)
+	}
+	if _, err := store.Open("../escape"); err == nil {
+		t.Error("opened a job outside the store")
+	}
+}
+
+func TestExpire(t *testing.T) {
+	store, root := newStore(t)
+	now := time.Now()
+	old, _ := store.Create()
+	fresh, _ := store.Create()
+	if err := os.Chtimes(filepath.Join(root, old.ID), now, now.Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	// Not job directories, however old
+	for _, name := range []string{"notes", strings.Repeat("zz", jobIDBytes)} {
+		if err := os.Mkdir(filepath.Join(root, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(filepath.Join(root, name), now, now.Add(-2*time.Hour))
+	}
+
+	removed, err := store.Expire(now)
+	if err != nil || removed != 1 {
+		t.Fatalf("Expire removed %d, %v, want 1", removed, err)
+	}
+	for name, want := range map[string]bool{old.ID: false, fresh.ID: true, "notes": true, strings.Repeat("zz", jobIDBytes): true} {
+		if _, err := os.Stat(filepath.Join(root, name)); (err == nil) != want {
+			t.Errorf("%s exists %v, want %v", name, err == nil, want)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	store, root := newStore(t)
+	job, _ := store.Create()
+	if _, err := job.Write("result.png", func(w io.Writer) error {
+		_, err := io.WriteString(w, "image")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(root, job.ID, ".hidden"), []byte("secret"), 0644)
+	os.Mkdir(filepath.Join(root, job.ID, "sub"), 0755)
+	other, _ := NewJobID()
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/" + job.ID + "/result.png", http.StatusOK},
+		{"/" + job.ID + "/", http.StatusNotFound},
+		{"/" + job.ID, http.StatusNotFound},
+		{"/" + job.ID + "/.hidden", http.StatusNotFound},
+		{"/" + job.ID + "/sub", http.StatusNotFound},
+		{"/" + job.ID + "/sub/x", http.StatusNotFound},
+		{"/" + other + "/result.png", http.StatusNotFound},
+		{"/not-a-job/result.png", http.StatusNotFound},
+		{"/", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		store.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("GET %s: status %d, want %d", tt.path, rec.Code, tt.want)
+		}
+		if tt.want == http.StatusOK && rec.Body.String() != "image" {
+			t.Errorf("GET %s: body %q", tt.path, rec.Body)
+		}
+	}
+}
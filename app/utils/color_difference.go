@@ -0,0 +1,81 @@
+package utils
+
+import "math"
+
+// CIEDE2000 returns the CIE ΔE*00 color difference between two colors (Sharma, Wu & Dalal 2005)
+func CIEDE2000(c1, c2 Lab) float64 {
+	const pow25to7 = 6103515625.0 // 25^7
+
+	cab := (math.Hypot(c1.A, c1.B) + math.Hypot(c2.A, c2.B)) / 2
+	cab7 := math.Pow(cab, 7)
+	g := 0.5 * (1 - math.Sqrt(cab7/(cab7+pow25to7)))
+
+	a1, a2 := (1+g)*c1.A, (1+g)*c2.A
+	chroma1, chroma2 := math.Hypot(a1, c1.B), math.Hypot(a2, c2.B)
+	hue1, hue2 := hueAngle(a1, c1.B), hueAngle(a2, c2.B)
+
+	dL := c2.L - c1.L
+	dC := chroma2 - chroma1
+
+	var dh float64
+	if chroma1*chroma2 != 0 {
+		dh = hue2 - hue1
+		if dh > 180 {
+			dh -= 360
+		} else if dh < -180 {
+			dh += 360
+		}
+	}
+	dH := 2 * math.Sqrt(chroma1*chroma2) * math.Sin(radians(dh/2))
+
+	meanL := (c1.L + c2.L) / 2
+	meanC := (chroma1 + chroma2) / 2
+
+	meanH := hue1 + hue2
+	if chroma1*chroma2 != 0 {
+		switch {
+		case math.Abs(hue1-hue2) <= 180:
+			meanH /= 2
+		case hue1+hue2 < 360:
+			meanH = (meanH + 360) / 2
+		default:
+			meanH = (meanH - 360) / 2
+		}
+	}
+
+	t := 1 - 0.17*math.Cos(radians(meanH-30)) +
+		0.24*math.Cos(radians(2*meanH)) +
+		0.32*math.Cos(radians(3*meanH+6)) -
+		0.20*math.Cos(radians(4*meanH-63))
+
+	dTheta := 30 * math.Exp(-math.Pow((meanH-275)/25, 2))
+	meanC7 := math.Pow(meanC, 7)
+	rc := 2 * math.Sqrt(meanC7/(meanC7+pow25to7))
+	l50 := (meanL - 50) * (meanL - 50)
+	sl := 1 + 0.015*l50/math.Sqrt(20+l50)
+	sc := 1 + 0.045*meanC
+	sh := 1 + 0.015*meanC*t
+	rt := -math.Sin(radians(2*dTheta)) * rc
+
+	return math.Sqrt(
+		(dL/sl)*(dL/sl) +
+			(dC/sc)*(dC/sc) +
+			(dH/sh)*(dH/sh) +
+			rt*(dC/sc)*(dH/sh))
+}
+
+// hueAngle returns the hue in degrees in [0,360)
+func hueAngle(a, b float64) float64 {
+	if a == 0 && b == 0 {
+		return 0
+	}
+	h := math.Atan2(b, a) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
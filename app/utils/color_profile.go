@@ -0,0 +1,432 @@
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"math"
+)
+
+// ColorProfile describes the RGB space an image was encoded in as three tone
+// curves and a matrix, which covers sRGB, Display P3 and Adobe RGB
+type ColorProfile struct {
+	Name string
+	// curves decode each channel to linear light
+	curves [3]func(float64) float64
+	// toLinearSRGB maps the decoded channels to linear sRGB
+	toLinearSRGB [3][3]float64
+}
+
+// Largest embedded ICC profile read; real profiles are a few kilobytes
+const maxICCProfileSize = 4 << 20
+
+// CIE xy chromaticities of the primaries and white point of the cICP color spaces
+var (
+	bt709Primaries     = [3][2]float64{{0.640, 0.330}, {0.300, 0.600}, {0.150, 0.060}}
+	displayP3Primaries = [3][2]float64{{0.680, 0.320}, {0.265, 0.690}, {0.150, 0.060}}
+	bt2020Primaries    = [3][2]float64{{0.708, 0.292}, {0.170, 0.797}, {0.131, 0.046}}
+	d65Chromaticity    = [2]float64{0.3127, 0.3290}
+)
+
+// xyzFromPrimaries returns the matrix from linear RGB to XYZ for the given primaries,
+// scaled so that equal channels give the white point at unit luminance
+func xyzFromPrimaries(primaries [3][2]float64, white [2]float64) [3][3]float64 {
+	var m [3][3]float64
+	for c, p := range primaries {
+		m[0][c], m[1][c], m[2][c] = p[0]/p[1], 1, (1-p[0]-p[1])/p[1]
+	}
+	scale := mulVector(invertMatrix(m), [3]float64{white[0] / white[1], 1, (1 - white[0] - white[1]) / white[1]})
+	for r := range m {
+		for c := range m[r] {
+			m[r][c] *= scale[c]
+		}
+	}
+	return m
+}
+
+// newD65Profile builds a profile for a D65 RGB space with the same curve on every channel
+func newD65Profile(name string, primaries [3][2]float64, curve func(float64) float64) *ColorProfile {
+	return &ColorProfile{
+		Name:         name,
+		curves:       [3]func(float64) float64{curve, curve, curve},
+		toLinearSRGB: mulMatrix(linearRGBFromXYZ, xyzFromPrimaries(primaries, d65Chromaticity)),
+	}
+}
+
+// IsSRGB reports whether the profile is sRGB to within 8-bit precision, so images
+// in it can be used without conversion
+func (p *ColorProfile) IsSRGB() bool {
+	for r := range p.toLinearSRGB {
+		for c := range p.toLinearSRGB[r] {
+			if math.Abs(p.toLinearSRGB[r][c]-identityMatrix[r][c]) > 0.005 {
+				return false
+			}
+		}
+	}
+	for _, curve := range p.curves {
+		for i := 0; i <= 16; i++ {
+			v := float64(i) / 16
+			if math.Abs(LinearToSRGB(clampUnit(curve(v)))-v) > 0.5/255 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Convert maps an image encoded in the profile's space to sRGB at the same bit
+// depth. Colors outside the sRGB gamut are clipped channel by channel.
+func (p *ColorProfile) Convert(img image.Image) image.Image {
+	var lut [3][]float32
+	for c := range lut {
+		lut[c] = make([]float32, 0x10000)
+		for i := range lut[c] {
+			lut[c][i] = float32(p.curves[c](float64(i) / 0xffff))
+		}
+	}
+
+	bounds := img.Bounds()
+	out := NewLinearImage(bounds)
+	out.HighBitDepth = IsHighBitDepth(img)
+
+	parallelRows(bounds.Min.Y, bounds.Max.Y, func(y0, y1 int) {
+		row := make([]uint32, 4*bounds.Dx())
+		for y := y0; y < y1; y++ {
+			premultipliedRow(img, y, bounds.Min.X, bounds.Max.X, row)
+			pix := out.Pix[out.PixOffset(bounds.Min.X, y):]
+			for i := 0; i < len(row); i += 4 {
+				r, g, b := unpremultiply16(row[i], row[i+1], row[i+2], row[i+3])
+				rgb := mulVector(p.toLinearSRGB, [3]float64{float64(lut[0][r]), float64(lut[1][g]), float64(lut[2][b])})
+				a := float32(row[i+3]) / 0xffff
+				pix[i+0] = float32(clampUnit(rgb[0])) * a
+				pix[i+1] = float32(clampUnit(rgb[1])) * a
+				pix[i+2] = float32(clampUnit(rgb[2])) * a
+				pix[i+3] = a
+			}
+		}
+	})
+	return out.Image()
+}
+
+// ReadColorProfile finds the color space of an encoded image: the cICP, iCCP or
+// sRGB chunk of a PNG, the ICC profile in the APP2 segments of a JPEG, or the colr
+// property of a HEIF or AVIF file. It returns nil when the file declares no color
+// space, which means sRGB.
+func ReadColorProfile(data []byte) (*ColorProfile, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return readPNGColorProfile(data)
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		return readJPEGColorProfile(data)
+	case len(data) >= 8 && string(data[4:8]) == "ftyp":
+		return readHEIFColorProfile(data)
+	}
+	return nil, nil
+}
+
+// readPNGColorProfile reads the color chunks before the image data, giving cICP
+// precedence over iCCP and iCCP over sRGB as the PNG specification does
+func readPNGColorProfile(data []byte) (*ColorProfile, error) {
+	var cicp, iccp []byte
+	for pos := 8; pos+12 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[pos:]))
+		kind := string(data[pos+4 : pos+8])
+		if n > len(data)-pos-12 || kind == "IDAT" {
+			break
+		}
+		switch chunk := data[pos+8 : pos+8+n]; kind {
+		case "cICP":
+			cicp = chunk
+		case "iCCP":
+			iccp = chunk
+		}
+		pos += n + 12
+	}
+
+	if cicp != nil {
+		if len(cicp) != 4 {
+			return nil, fmt.Errorf("invalid PNG cICP chunk")
+		}
+		return cicpColorProfile(int(cicp[0]), int(cicp[1]))
+	}
+	if iccp != nil {
+		// Profile name, a null separator, the compression method and zlib data
+		name, compressed, ok := bytes.Cut(iccp, []byte{0})
+		if !ok || len(compressed) < 1 || compressed[0] != 0 {
+			return nil, fmt.Errorf("invalid PNG iCCP chunk")
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(compressed[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid PNG iCCP chunk: %v", err)
+		}
+		defer zr.Close()
+		icc, err := io.ReadAll(io.LimitReader(zr, maxICCProfileSize))
+		if err != nil {
+			return nil, fmt.Errorf("invalid PNG iCCP chunk: %v", err)
+		}
+		profile, err := ParseICCProfile(icc)
+		if err == nil && profile.Name == "" {
+			profile.Name = string(name)
+		}
+		return profile, err
+	}
+	return nil, nil
+}
+
+// cicpColorProfile interprets the coding-independent code points of ITU-T H.273,
+// as found in a PNG cICP chunk or a HEIF nclx colr box. Only SDR transfer
+// functions are supported.
+func cicpColorProfile(primaries, transfer int) (*ColorProfile, error) {
+	var curve func(float64) float64
+	switch transfer {
+	case 13: // sRGB
+		curve = SRGBToLinear
+	case 8: // linear
+		curve = func(v float64) float64 { return v }
+	default:
+		return nil, fmt.Errorf("unsupported cICP transfer function %d", transfer)
+	}
+
+	switch primaries {
+	case 1:
+		if transfer == 13 {
+			return nil, nil
+		}
+		return newD65Profile("sRGB (linear)", bt709Primaries, curve), nil
+	case 12:
+		return newD65Profile("Display P3", displayP3Primaries, curve), nil
+	case 9:
+		return newD65Profile("Rec. 2020", bt2020Primaries, curve), nil
+	}
+	return nil, fmt.Errorf("unsupported cICP color primaries %d", primaries)
+}
+
+// readJPEGColorProfile reassembles an ICC profile split over APP2 segments
+func readJPEGColorProfile(data []byte) (*ColorProfile, error) {
+	const iccMarker = "ICC_PROFILE\x00"
+	chunks := map[int][]byte{}
+	total := 0
+
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xff {
+			return nil, fmt.Errorf("invalid JPEG marker at offset %d", pos)
+		}
+		marker := data[pos+1]
+		if marker == 0xff {
+			// Fill byte
+			pos++
+			continue
+		}
+		// The profile must come before the scan data
+		if marker == 0xda || marker == 0xd9 {
+			break
+		}
+		n := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if n < 2 || pos+2+n > len(data) {
+			return nil, fmt.Errorf("truncated JPEG segment at offset %d", pos)
+		}
+		segment := data[pos+4 : pos+2+n]
+		if marker == 0xe2 && len(segment) >= len(iccMarker)+2 && string(segment[:len(iccMarker)]) == iccMarker {
+			// Sequence number from 1, then the number of chunks
+			seq, count := int(segment[len(iccMarker)]), int(segment[len(iccMarker)+1])
+			if seq < 1 || seq > count {
+				return nil, fmt.Errorf("invalid JPEG ICC chunk %d of %d", seq, count)
+			}
+			total = count
+			chunks[seq] = segment[len(iccMarker)+2:]
+		}
+		pos += 2 + n
+	}
+
+	if len(chunks) == 0 {
+		return nil, nil
+	}
+	if len(chunks) != total {
+		return nil, fmt.Errorf("JPEG ICC profile has %d of %d chunks", len(chunks), total)
+	}
+	var icc []byte
+	for seq := 1; seq <= total; seq++ {
+		icc = append(icc, chunks[seq]...)
+	}
+	return ParseICCProfile(icc)
+}
+
+// isoBox is one box of an ISO base media file, without its header
+type isoBox struct {
+	kind string
+	body []byte
+}
+
+// isoBoxes splits ISO base media file format data, the container of HEIF and AVIF,
+// into its boxes
+func isoBoxes(data []byte) ([]isoBox, error) {
+	var boxes []isoBox
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("truncated box")
+		}
+		size, header := uint64(binary.BigEndian.Uint32(data)), uint64(8)
+		kind := string(data[4:8])
+		switch size {
+		case 0:
+			// The last box runs to the end of the data
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, fmt.Errorf("truncated %q box", kind)
+			}
+			size, header = binary.BigEndian.Uint64(data[8:]), 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return nil, fmt.Errorf("invalid size of %q box", kind)
+		}
+		boxes = append(boxes, isoBox{kind, data[header:size]})
+		data = data[size:]
+	}
+	return boxes, nil
+}
+
+// findBox returns the body of the first box of the given kind
+func findBox(boxes []isoBox, kind string) []byte {
+	for _, box := range boxes {
+		if box.kind == kind {
+			return box.body
+		}
+	}
+	return nil
+}
+
+// readHEIFColorProfile reads the colr property of the primary image of a HEIF or
+// AVIF file. An embedded ICC profile takes precedence over nclx code points, since
+// nclx also has to describe the YCbCr matrix of the coded image.
+func readHEIFColorProfile(data []byte) (*ColorProfile, error) {
+	top, err := isoBoxes(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HEIF file: %v", err)
+	}
+	// meta, pitm and ipma are full boxes with four bytes of version and flags
+	meta := findBox(top, "meta")
+	if len(meta) < 4 {
+		return nil, nil
+	}
+	metaBoxes, err := isoBoxes(meta[4:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid HEIF meta box: %v", err)
+	}
+	iprp, err := isoBoxes(findBox(metaBoxes, "iprp"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid HEIF iprp box: %v", err)
+	}
+	properties, err := isoBoxes(findBox(iprp, "ipco"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid HEIF ipco box: %v", err)
+	}
+
+	// Keep the properties of the primary item when the file says which it is
+	if indices, ok := primaryItemProperties(findBox(metaBoxes, "pitm"), findBox(iprp, "ipma")); ok {
+		var primary []isoBox
+		for _, i := range indices {
+			if i >= 1 && i <= len(properties) {
+				primary = append(primary, properties[i-1])
+			}
+		}
+		properties = primary
+	}
+
+	var icc, nclx []byte
+	for _, box := range properties {
+		if box.kind != "colr" || len(box.body) < 4 {
+			continue
+		}
+		switch body := box.body[4:]; string(box.body[:4]) {
+		case "prof", "rICC":
+			if icc == nil {
+				icc = body
+			}
+		case "nclx":
+			if nclx == nil {
+				nclx = body
+			}
+		}
+	}
+
+	switch {
+	case icc != nil:
+		return ParseICCProfile(icc)
+	case nclx != nil:
+		if len(nclx) < 7 {
+			return nil, fmt.Errorf("invalid HEIF nclx color box")
+		}
+		primaries, transfer := int(binary.BigEndian.Uint16(nclx)), int(binary.BigEndian.Uint16(nclx[2:]))
+		// Code point 2 means unspecified, which readers take as sRGB
+		if primaries == 2 {
+			primaries = 1
+		}
+		if transfer == 2 {
+			transfer = 13
+		}
+		return cicpColorProfile(primaries, transfer)
+	}
+	return nil, nil
+}
+
+// primaryItemProperties returns the 1-based ipco indices of the properties
+// associated with the item named by the pitm box
+func primaryItemProperties(pitm, ipma []byte) ([]int, bool) {
+	if len(pitm) < 6 || len(ipma) < 8 {
+		return nil, false
+	}
+	var primary uint32
+	if pitm[0] == 0 {
+		primary = uint32(binary.BigEndian.Uint16(pitm[4:]))
+	} else if len(pitm) >= 8 {
+		primary = binary.BigEndian.Uint32(pitm[4:])
+	}
+
+	version, wideIndex := ipma[0], ipma[3]&1 != 0
+	count := binary.BigEndian.Uint32(ipma[4:])
+	pos := 8
+	for i := uint32(0); i < count; i++ {
+		var item uint32
+		if version < 1 {
+			if pos+3 > len(ipma) {
+				return nil, false
+			}
+			item = uint32(binary.BigEndian.Uint16(ipma[pos:]))
+			pos += 2
+		} else {
+			if pos+5 > len(ipma) {
+				return nil, false
+			}
+			item = binary.BigEndian.Uint32(ipma[pos:])
+			pos += 4
+		}
+		n := int(ipma[pos])
+		pos++
+
+		var indices []int
+		for j := 0; j < n; j++ {
+			// The top bit of each association marks the property as essential
+			if wideIndex {
+				if pos+2 > len(ipma) {
+					return nil, false
+				}
+				indices = append(indices, int(binary.BigEndian.Uint16(ipma[pos:])&0x7fff))
+				pos += 2
+			} else {
+				if pos+1 > len(ipma) {
+					return nil, false
+				}
+				indices = append(indices, int(ipma[pos]&0x7f))
+				pos++
+			}
+		}
+		if item == primary {
+			return indices, true
+		}
+	}
+	return nil, false
+}
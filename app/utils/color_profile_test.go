@@ -0,0 +1,356 @@
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"flag"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+)
+
+var updateFixtures = flag.Bool("update", false, "rewrite the color profile fixtures in testdata")
+
+// Colors written into the fixtures, encoded in each fixture's own color space
+var profileSamples = []color.NRGBA{
+	{180, 120, 90, 255},
+	{60, 140, 200, 255},
+	{255, 0, 0, 255},
+	{128, 128, 128, 255},
+}
+
+// Published linear-light conversions to sRGB, written out independently of the
+// package's own primaries and adaptation matrices
+var (
+	displayP3ToSRGB = [3][3]float64{
+		{1.224940, -0.224940, 0},
+		{-0.042057, 1.042057, 0},
+		{-0.019638, -0.078636, 1.098274},
+	}
+	adobeRGBToSRGB = [3][3]float64{
+		{1.398283, -0.398283, 0},
+		{0, 1, 0},
+		{0, -0.042926, 1.042926},
+	}
+)
+
+// Adobe RGB (1998) encodes with a pure power law of 563/256
+const adobeRGBGamma = 563.0 / 256
+
+type profileFixture struct {
+	file     string
+	profile  string
+	toSRGB   [3][3]float64
+	decode   func(float64) float64
+	lossless bool
+}
+
+var profileFixtures = []profileFixture{
+	{"display-p3-cicp.png", "Display P3", displayP3ToSRGB, SRGBToLinear, true},
+	{"display-p3-iccp.png", "Display P3", displayP3ToSRGB, SRGBToLinear, true},
+	{"adobe-rgb-iccp.png", "Adobe RGB (1998)", adobeRGBToSRGB, func(v float64) float64 { return math.Pow(v, adobeRGBGamma) }, true},
+	{"display-p3-app2.jpg", "Display P3", displayP3ToSRGB, SRGBToLinear, false},
+}
+
+// expectedSRGB converts a fixture color to 8-bit sRGB with the reference matrix
+func (f profileFixture) expectedSRGB(c color.NRGBA) [3]float64 {
+	in := [3]float64{f.decode(float64(c.R) / 255), f.decode(float64(c.G) / 255), f.decode(float64(c.B) / 255)}
+	var out [3]float64
+	for i := range out {
+		v := f.toSRGB[i][0]*in[0] + f.toSRGB[i][1]*in[1] + f.toSRGB[i][2]*in[2]
+		out[i] = 255 * LinearToSRGB(clampUnit(v))
+	}
+	return out
+}
+
+// fixturePixel returns where sample i sits in a fixture. JPEG fixtures use 16×16
+// blocks, sampled at their centres, so chroma subsampling does not mix colors.
+func (f profileFixture) fixturePixel(i int) image.Point {
+	if f.lossless {
+		return image.Pt(i, 0)
+	}
+	return image.Pt(16*i+8, 8)
+}
+
+func TestDecodeColorProfileFixtures(t *testing.T) {
+	if *updateFixtures {
+		writeProfileFixtures(t)
+	}
+	for _, f := range profileFixtures {
+		data, err := os.ReadFile(filepath.Join("testdata", f.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, profile, err := DecodeImageProfile(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", f.file, err)
+		}
+		if profile == nil || profile.Name != f.profile {
+			t.Fatalf("%s: profile %+v, want %q", f.file, profile, f.profile)
+		}
+
+		// One level covers rounding; JPEG quantisation adds a few more
+		tolerance := 1.0
+		if !f.lossless {
+			tolerance = 3
+		}
+		for i, c := range profileSamples {
+			p := f.fixturePixel(i)
+			got := color.NRGBAModel.Convert(img.At(p.X, p.Y)).(color.NRGBA)
+			want := f.expectedSRGB(c)
+			for ch, v := range []uint8{got.R, got.G, got.B} {
+				if math.Abs(float64(v)-want[ch]) > tolerance {
+					t.Errorf("%s: %v decoded as %v, want sRGB %.1f ± %v", f.file, c, got, want, tolerance)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeImageTagsSRGB(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "display-p3-iccp.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, _, err := DecodeImageProfile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// PNG output carries an sRGB chunk, which reads back as no conversion
+	var buf bytes.Buffer
+	if err := EncodeImage(&buf, img, FormatPNG); err != nil {
+		t.Fatal(err)
+	}
+	if profile, err := ReadColorProfile(buf.Bytes()); err != nil || profile != nil {
+		t.Errorf("PNG output reads back as profile %+v, %v, want sRGB", profile, err)
+	}
+	again, _, err := DecodeImageProfile(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range profileSamples {
+		if got, want := again.At(i, 0), img.At(i, 0); color.NRGBAModel.Convert(got) != color.NRGBAModel.Convert(want) {
+			t.Errorf("sample %d changed from %v to %v on a second decode", i, want, got)
+		}
+	}
+
+	// JPEG output starts with an APP2 segment holding the whole sRGB profile
+	buf.Reset()
+	if err := EncodeImage(&buf, img, FormatJPEG); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.Bytes()
+	if !bytes.HasPrefix(out, []byte{0xff, 0xd8, 0xff, 0xe2}) || !bytes.HasPrefix(out[6:], []byte("ICC_PROFILE\x00\x01\x01")) {
+		t.Fatalf("JPEG output starts % x, want SOI then an APP2 ICC profile", out[:min(len(out), 20)])
+	}
+	profile, err := ReadColorProfile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile == nil || profile.Name != "sRGB IEC61966-2.1" || !profile.IsSRGB() {
+		t.Errorf("JPEG output profile %+v, want sRGB", profile)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+		t.Errorf("tagged JPEG does not decode: %v", err)
+	}
+}
+
+// heifFile assembles a minimal HEIF header whose primary item is item 1, with
+// the given colr properties associated to item 1 and an nclx for Rec. 2020
+// associated to item 2
+func heifFile(colr ...[]byte) []byte {
+	box := func(kind string, parts ...[]byte) []byte {
+		body := bytes.Join(parts, nil)
+		return append(binary.BigEndian.AppendUint32(nil, uint32(8+len(body))), append([]byte(kind), body...)...)
+	}
+	fullBox := func(kind string, flags uint32, parts ...[]byte) []byte {
+		return box(kind, append([][]byte{binary.BigEndian.AppendUint32(nil, flags)}, parts...)...)
+	}
+
+	properties := [][]byte{box("ispe", make([]byte, 12))}
+	for _, c := range colr {
+		properties = append(properties, box("colr", c))
+	}
+	properties = append(properties, box("colr", nclx(9, 13)))
+
+	// Item 1 takes every property but the last, item 2 the first and last
+	item1 := []byte{0, 1, byte(len(properties) - 1)}
+	for i := 1; i < len(properties); i++ {
+		item1 = append(item1, byte(i))
+	}
+	item2 := []byte{0, 2, 2, 1, byte(len(properties))}
+	ipma := fullBox("ipma", 0, []byte{0, 0, 0, 2}, item1, item2)
+
+	meta := fullBox("meta", 0,
+		fullBox("hdlr", 0, make([]byte, 4), []byte("pict"), make([]byte, 13)),
+		fullBox("pitm", 0, []byte{0, 1}),
+		box("iprp", box("ipco", properties...), ipma))
+	return append(box("ftyp", []byte("avif"), make([]byte, 4), []byte("mif1avif")), meta...)
+}
+
+// nclx builds the body of an nclx colr box
+func nclx(primaries, transfer uint16) []byte {
+	body := []byte("nclx")
+	body = binary.BigEndian.AppendUint16(body, primaries)
+	body = binary.BigEndian.AppendUint16(body, transfer)
+	body = binary.BigEndian.AppendUint16(body, 1)
+	return append(body, 0x80)
+}
+
+func TestReadHEIFColorProfile(t *testing.T) {
+	adobe := append([]byte("prof"), adobeRGBProfile()...)
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"nclx of the primary item", heifFile(nclx(12, 13)), "Display P3"},
+		{"ICC profile over nclx", heifFile(nclx(12, 13), adobe), "Adobe RGB (1998)"},
+		{"unspecified nclx", heifFile(nclx(2, 2)), ""},
+		{"sRGB nclx", heifFile(nclx(1, 13)), ""},
+		{"no colr for the primary item", heifFile(), ""},
+	}
+	for _, tt := range tests {
+		profile, err := ReadColorProfile(tt.data)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		got := ""
+		if profile != nil {
+			got = profile.Name
+		}
+		if got != tt.want {
+			t.Errorf("%s: profile %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := ReadColorProfile(heifFile(nclx(12, 16))); err == nil {
+		t.Errorf("PQ transfer was accepted, want an unsupported transfer error")
+	}
+	if _, err := ReadColorProfile(heifFile(nclx(12, 13))[:40]); err == nil {
+		t.Errorf("truncated file was accepted")
+	}
+}
+
+// rgbICCProfile builds a matrix/TRC display profile from D65 primaries
+func rgbICCProfile(desc []byte, primaries [3][2]float64, trc []byte) []byte {
+	colorants := mulMatrix(xyzD50FromD65, xyzFromPrimaries(primaries, d65Chromaticity))
+	column := func(c int) [3]float64 { return [3]float64{colorants[0][c], colorants[1][c], colorants[2][c]} }
+	var buf bytes.Buffer
+	writeICCProfile(&buf, "mntr", "RGB ", "XYZ ", []iccTag{
+		{"desc", desc},
+		{"wtpt", iccXYZ(pcsWhiteXYZ)},
+		{"rXYZ", iccXYZ(column(0))},
+		{"gXYZ", iccXYZ(column(1))},
+		{"bXYZ", iccXYZ(column(2))},
+		{"rTRC", trc},
+		{"gTRC", trc},
+		{"bTRC", trc},
+	})
+	return buf.Bytes()
+}
+
+// displayP3Profile is shaped like Apple's: a v2 description and the sRGB curve as
+// a type 3 parametric curve
+func displayP3Profile() []byte {
+	trc := []byte("para\x00\x00\x00\x00\x00\x03\x00\x00")
+	for _, v := range []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045} {
+		trc = binary.BigEndian.AppendUint32(trc, s15Fixed16(v))
+	}
+	return rgbICCProfile(iccDescription("Display P3"), displayP3Primaries, trc)
+}
+
+// adobeRGBProfile has a v4 multi-localized description and a single-gamma curve
+func adobeRGBProfile() []byte {
+	text := utf16.Encode([]rune("Adobe RGB (1998)"))
+	desc := []byte("mluc\x00\x00\x00\x00")
+	desc = binary.BigEndian.AppendUint32(desc, 1)
+	desc = binary.BigEndian.AppendUint32(desc, 12)
+	desc = append(desc, "enUS"...)
+	desc = binary.BigEndian.AppendUint32(desc, uint32(2*len(text)))
+	desc = binary.BigEndian.AppendUint32(desc, 28)
+	for _, u := range text {
+		desc = binary.BigEndian.AppendUint16(desc, u)
+	}
+	trc := binary.BigEndian.AppendUint16([]byte("curv\x00\x00\x00\x00\x00\x00\x00\x01"), 563)
+	primaries := [3][2]float64{{0.64, 0.33}, {0.21, 0.71}, {0.15, 0.06}}
+	return rgbICCProfile(desc, primaries, trc)
+}
+
+// writeProfileFixtures regenerates the files in testdata; run the tests with -update
+func writeProfileFixtures(t *testing.T) {
+	encodePNG := func(chunk []byte) []byte {
+		img := image.NewNRGBA(image.Rect(0, 0, len(profileSamples), 1))
+		for i, c := range profileSamples {
+			img.SetNRGBA(i, 0, c)
+		}
+		var buf bytes.Buffer
+		if err := writeTagged(&buf, 33, chunk, func(w io.Writer) error { return png.Encode(w, img) }); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	iccp := func(name string, icc []byte) []byte {
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		zw.Write(icc)
+		zw.Close()
+		return pngChunk("iCCP", append(append([]byte(name), 0, 0), compressed.Bytes()...))
+	}
+
+	// The JPEG profile is split over three APP2 segments, as writers do for
+	// profiles beyond the 64 KB segment limit
+	jpegWithProfile := func(icc []byte) []byte {
+		img := image.NewNRGBA(image.Rect(0, 0, 16*len(profileSamples), 16))
+		for i, c := range profileSamples {
+			for y := 0; y < 16; y++ {
+				for x := 16 * i; x < 16*(i+1); x++ {
+					img.SetNRGBA(x, y, c)
+				}
+			}
+		}
+		var segments []byte
+		const count = 3
+		size := (len(icc) + count - 1) / count
+		for seq := 1; seq <= count; seq++ {
+			part := icc[(seq-1)*size : min(seq*size, len(icc))]
+			payload := append([]byte{'I', 'C', 'C', '_', 'P', 'R', 'O', 'F', 'I', 'L', 'E', 0, byte(seq), count}, part...)
+			segments = append(segments, 0xff, 0xe2)
+			segments = binary.BigEndian.AppendUint16(segments, uint16(2+len(payload)))
+			segments = append(segments, payload...)
+		}
+		var buf bytes.Buffer
+		if err := writeTagged(&buf, 2, segments, func(w io.Writer) error {
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: 100})
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	files := map[string][]byte{
+		"display-p3-cicp.png": encodePNG(pngChunk("cICP", []byte{12, 13, 0, 1})),
+		"display-p3-iccp.png": encodePNG(iccp("Display P3", displayP3Profile())),
+		"adobe-rgb-iccp.png":  encodePNG(iccp("Adobe RGB (1998)", adobeRGBProfile())),
+		"display-p3-app2.jpg": jpegWithProfile(displayP3Profile()),
+	}
+	if err := os.MkdirAll("testdata", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join("testdata", name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package utils
+
+import "math"
+
+// LMS cone response from linear sRGB (Viénot, Brettel & Mollon 1999)
+var lmsFromLinearRGB = [3][3]float64{
+	{17.8824, 43.5161, 4.11935},
+	{3.45565, 27.1554, 3.86714},
+	{0.0299566, 0.184309, 1.46709},
+}
+
+var linearRGBFromLMS = invertMatrix(lmsFromLinearRGB)
+
+// Linear sRGB (D65) from CIE XYZ
+var linearRGBFromXYZ = [3][3]float64{
+	{3.2404542, -1.5371385, -0.4985314},
+	{-0.9692660, 1.8760108, 0.0415560},
+	{0.0556434, -0.2040259, 1.0572252},
+}
+
+var identityMatrix = [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+
+// SRGBToLinear decodes a gamma-encoded sRGB component in [0,1] to linear light
+func SRGBToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// LinearToSRGB encodes a linear-light component in [0,1] with the sRGB curve
+func LinearToSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+func clampUnit(v float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
+
+func mulVector(m [3][3]float64, v [3]float64) [3]float64 {
+	return [3]float64{
+		m[0][0]*v[0] + m[0][1]*v[1] + m[0][2]*v[2],
+		m[1][0]*v[0] + m[1][1]*v[1] + m[1][2]*v[2],
+		m[2][0]*v[0] + m[2][1]*v[1] + m[2][2]*v[2],
+	}
+}
+
+func mulMatrix(a, b [3][3]float64) [3][3]float64 {
+	var out [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				out[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return out
+}
+
+func invertMatrix(m [3][3]float64) [3][3]float64 {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+
+	return [3][3]float64{
+		{
+			(m[1][1]*m[2][2] - m[1][2]*m[2][1]) / det,
+			(m[0][2]*m[2][1] - m[0][1]*m[2][2]) / det,
+			(m[0][1]*m[1][2] - m[0][2]*m[1][1]) / det,
+		},
+		{
+			(m[1][2]*m[2][0] - m[1][0]*m[2][2]) / det,
+			(m[0][0]*m[2][2] - m[0][2]*m[2][0]) / det,
+			(m[0][2]*m[1][0] - m[0][0]*m[1][2]) / det,
+		},
+		{
+			(m[1][0]*m[2][1] - m[1][1]*m[2][0]) / det,
+			(m[0][1]*m[2][0] - m[0][0]*m[2][1]) / det,
+			(m[0][0]*m[1][1] - m[0][1]*m[1][0]) / det,
+		},
+	}
+}
+
+func cross(a, b [3]float64) [3]float64 {
+	return [3]float64{
+		a[1]*b[2] - a[2]*b[1],
+		a[2]*b[0] - a[0]*b[2],
+		a[0]*b[1] - a[1]*b[0],
+	}
+}
+
+func dot(a, b [3]float64) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+// lmsFromChromaticity returns the LMS response of a stimulus with CIE xy chromaticity and unit luminance
+func lmsFromChromaticity(x, y float64) [3]float64 {
+	xyz := [3]float64{x / y, 1, (1 - x - y) / y}
+	return mulVector(lmsFromLinearRGB, mulVector(linearRGBFromXYZ, xyz))
+}
+
+func lerpMatrix(a, b [3][3]float64, t float64) [3][3]float64 {
+	var out [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			out[i][j] = a[i][j] + (b[i][j]-a[i][j])*t
+		}
+	}
+	return out
+}
+
+var xyzFromLinearRGB = invertMatrix(linearRGBFromXYZ)
+
+// D65 reference white
+var whiteXYZ = [3]float64{0.95047, 1.0, 1.08883}
+
+// Lab is a color in CIE L*a*b* (D65)
+type Lab struct {
+	L, A, B float64
+}
+
+// LabFromSRGB converts a gamma-encoded sRGB color with components in [0,1] to CIE L*a*b*
+func LabFromSRGB(r, g, b float64) Lab {
+	xyz := mulVector(xyzFromLinearRGB, [3]float64{SRGBToLinear(r), SRGBToLinear(g), SRGBToLinear(b)})
+	fx := labF(xyz[0] / whiteXYZ[0])
+	fy := labF(xyz[1] / whiteXYZ[1])
+	fz := labF(xyz[2] / whiteXYZ[2])
+	return Lab{L: 116*fy - 16, A: 500 * (fx - fy), B: 200 * (fy - fz)}
+}
+
+// SRGB converts the color back to gamma-encoded sRGB, clipping out-of-gamut values
+func (c Lab) SRGB() (float64, float64, float64) {
+	fy := (c.L + 16) / 116
+	fx := fy + c.A/500
+	fz := fy - c.B/200
+	xyz := [3]float64{labFInv(fx) * whiteXYZ[0], labFInv(fy) * whiteXYZ[1], labFInv(fz) * whiteXYZ[2]}
+	rgb := mulVector(linearRGBFromXYZ, xyz)
+	return LinearToSRGB(clampUnit(rgb[0])), LinearToSRGB(clampUnit(rgb[1])), LinearToSRGB(clampUnit(rgb[2]))
+}
+
+// DistanceTo returns the CIE76 color difference, the Euclidean distance in L*a*b*
+func (c Lab) DistanceTo(o Lab) float64 {
+	dl, da, db := c.L-o.L, c.A-o.A, c.B-o.B
+	return math.Sqrt(dl*dl + da*da + db*db)
+}
+
+func labF(t float64) float64 {
+	if t > 216.0/24389 {
+		return math.Cbrt(t)
+	}
+	return (24389.0/27*t + 16) / 116
+}
+
+func labFInv(t float64) float64 {
+	if t*t*t > 216.0/24389 {
+		return t * t * t
+	}
+	return (116*t - 16) / (24389.0 / 27)
+}
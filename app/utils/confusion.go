@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+const (
+	// Neighboring regions at least this far apart (ΔE00) count as distinguishable
+	distinguishableDeltaE = 5.0
+	// A distinction has collapsed when the simulated ΔE falls below this share of the original
+	collapseRatio = 0.4
+	// Number of tiles along each axis used to rank the worst regions
+	confusionTiles = 8
+	// Number of worst regions reported in the summary
+	worstRegionCount = 5
+)
+
+// ConfusionRegion is a rectangle of the image where color distinctions are lost
+type ConfusionRegion struct {
+	X               int     `json:"x"`
+	Y               int     `json:"y"`
+	Width           int     `json:"width"`
+	Height          int     `json:"height"`
+	AffectedPercent float64 `json:"affected_percent"`
+	MeanLoss        float64 `json:"mean_delta_e_loss"`
+}
+
+// ConfusionReport summarises where a deficiency makes neighboring colors indistinguishable
+type ConfusionReport struct {
+	Deficiency      string            `json:"deficiency"`
+	Model           string            `json:"model"`
+	AffectedPercent float64           `json:"affected_percent"`
+	WorstRegions    []ConfusionRegion `json:"worst_regions"`
+}
+
+// ConfusionMap compares CIEDE2000 differences between neighboring regions before and
+// after simulating the deficiency. It returns a heatmap that highlights, in red over a
+// dimmed grayscale copy of the image, the places where contrast collapses.
+func ConfusionMap(img image.Image, d Deficiency, model SimulationModel) (image.Image, ConfusionReport, error) {
+	report := ConfusionReport{Deficiency: d.String(), Model: string(model), WorstRegions: []ConfusionRegion{}}
+
+	simulated, err := SimulateDeficiency(img, d, model, 1)
+	if err != nil {
+		return nil, report, err
+	}
+
+	bounds := img.Bounds()
+	cell := min(bounds.Dx(), bounds.Dy()) / 200
+	if cell < 2 {
+		cell = 2
+	}
+	cols := (bounds.Dx() + cell - 1) / cell
+	rows := (bounds.Dy() + cell - 1) / cell
+
+	orig := averageCells(img, cell, cols, rows)
+	sim := averageCells(simulated, cell, cols, rows)
+
+	// Loss per cell is the largest drop in ΔE to its right and lower neighbors
+	loss := make([]float64, cols*rows)
+	collapsed := make([]bool, cols*rows)
+	for cy := 0; cy < rows; cy++ {
+		for cx := 0; cx < cols; cx++ {
+			i := cy*cols + cx
+			var neighbors []int
+			if cx+1 < cols {
+				neighbors = append(neighbors, i+1)
+			}
+			if cy+1 < rows {
+				neighbors = append(neighbors, i+cols)
+			}
+			for _, n := range neighbors {
+				before := CIEDE2000(orig[i], orig[n])
+				after := CIEDE2000(sim[i], sim[n])
+				if before >= distinguishableDeltaE && after < before*collapseRatio {
+					collapsed[i] = true
+					if l := before - after; l > loss[i] {
+						loss[i] = l
+					}
+				}
+			}
+		}
+	}
+
+	out := image.NewNRGBA(bounds)
+	var affectedPixels int
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			i := ((y-bounds.Min.Y)/cell)*cols + (x-bounds.Min.X)/cell
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			gray := (0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)) * 0.5
+
+			heat := 0.0
+			if collapsed[i] {
+				affectedPixels++
+				heat = math.Min(loss[i]/(4*distinguishableDeltaE), 1)*0.7 + 0.3
+			}
+			out.SetNRGBA(x, y, color.NRGBA{
+				uint8(clamp(gray + heat*(255-gray))),
+				uint8(clamp(gray * (1 - heat))),
+				uint8(clamp(gray * (1 - heat))),
+				255,
+			})
+		}
+	}
+
+	if total := bounds.Dx() * bounds.Dy(); total > 0 {
+		report.AffectedPercent = 100 * float64(affectedPixels) / float64(total)
+	}
+	report.WorstRegions = worstRegions(bounds, cell, cols, rows, collapsed, loss)
+	return out, report, nil
+}
+
+// averageCells returns the mean Lab color of each cell×cell block
+func averageCells(img image.Image, cell, cols, rows int) []Lab {
+	bounds := img.Bounds()
+	sums := make([][3]float64, cols*rows)
+	counts := make([]int, cols*rows)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			i := ((y-bounds.Min.Y)/cell)*cols + (x-bounds.Min.X)/cell
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			sums[i][0] += float64(srgb8ToLinear[c.R])
+			sums[i][1] += float64(srgb8ToLinear[c.G])
+			sums[i][2] += float64(srgb8ToLinear[c.B])
+			counts[i]++
+		}
+	}
+
+	labs := make([]Lab, cols*rows)
+	for i := range labs {
+		if counts[i] == 0 {
+			continue
+		}
+		n := float64(counts[i])
+		labs[i] = LabFromSRGB(LinearToSRGB(sums[i][0]/n), LinearToSRGB(sums[i][1]/n), LinearToSRGB(sums[i][2]/n))
+	}
+	return labs
+}
+
+// worstRegions ranks coarse tiles of the image by their mean ΔE loss
+func worstRegions(bounds image.Rectangle, cell, cols, rows int, collapsed []bool, loss []float64) []ConfusionRegion {
+	tileCols := (cols + confusionTiles - 1) / confusionTiles
+	tileRows := (rows + confusionTiles - 1) / confusionTiles
+
+	var regions []ConfusionRegion
+	for ty := 0; ty < rows; ty += tileRows {
+		for tx := 0; tx < cols; tx += tileCols {
+			var cells, hits int
+			var lossSum float64
+			for cy := ty; cy < ty+tileRows && cy < rows; cy++ {
+				for cx := tx; cx < tx+tileCols && cx < cols; cx++ {
+					cells++
+					if collapsed[cy*cols+cx] {
+						hits++
+						lossSum += loss[cy*cols+cx]
+					}
+				}
+			}
+			if hits == 0 {
+				continue
+			}
+
+			rect := image.Rect(tx*cell, ty*cell, (tx+tileCols)*cell, (ty+tileRows)*cell).
+				Add(bounds.Min).Intersect(bounds)
+			regions = append(regions, ConfusionRegion{
+				X:               rect.Min.X,
+				Y:               rect.Min.Y,
+				Width:           rect.Dx(),
+				Height:          rect.Dy(),
+				AffectedPercent: 100 * float64(hits) / float64(cells),
+				MeanLoss:        lossSum / float64(cells),
+			})
+		}
+	}
+
+	sort.SliceStable(regions, func(i, j int) bool { return regions[i].MeanLoss > regions[j].MeanLoss })
+	if len(regions) > worstRegionCount {
+		regions = regions[:worstRegionCount]
+	}
+	if regions == nil {
+		regions = []ConfusionRegion{}
+	}
+	return regions
+}
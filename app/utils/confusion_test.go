@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// halvesImage is a 64×32 image with one color on the left and another on the right
+func halvesImage(left, right color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 64; x++ {
+			c := left
+			if x >= 32 {
+				c = right
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestConfusionMap(t *testing.T) {
+	red, green := color.NRGBA{200, 40, 40, 255}, color.NRGBA{40, 140, 40, 255}
+	black, white := color.NRGBA{0, 0, 0, 255}, color.NRGBA{255, 255, 255, 255}
+	gray := color.NRGBA{90, 90, 90, 255}
+
+	tests := []struct {
+		name        string
+		left, right color.NRGBA
+		deficiency  Deficiency
+		collapses   bool
+	}{
+		{"red and green for protans", red, green, Protan, true},
+		{"red and green for deutans", red, green, Deutan, true},
+		{"red and green for tritans", red, green, Tritan, false},
+		{"black and white for protans", black, white, Protan, false},
+		{"black and white for tritans", black, white, Tritan, false},
+		{"a flat image", gray, gray, Deutan, false},
+	}
+	for _, tt := range tests {
+		img := halvesImage(tt.left, tt.right)
+		heatmap, report, err := ConfusionMap(img, tt.deficiency, ModelMachado)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if heatmap.Bounds() != img.Bounds() {
+			t.Errorf("%s: heatmap bounds %v, want %v", tt.name, heatmap.Bounds(), img.Bounds())
+		}
+		if report.Deficiency != tt.deficiency.String() || report.Model != string(ModelMachado) {
+			t.Errorf("%s: report names %s %s", tt.name, report.Deficiency, report.Model)
+		}
+
+		if !tt.collapses {
+			if report.AffectedPercent != 0 || len(report.WorstRegions) != 0 {
+				t.Errorf("%s: %v%% affected in %v, want none", tt.name, report.AffectedPercent, report.WorstRegions)
+			}
+			continue
+		}
+
+		// Only the cells along the boundary lose their contrast
+		if report.AffectedPercent <= 0 || report.AffectedPercent > 10 {
+			t.Errorf("%s: %v%% affected, want a thin strip", tt.name, report.AffectedPercent)
+		}
+		if len(report.WorstRegions) == 0 {
+			t.Fatalf("%s: no worst regions", tt.name)
+		}
+		for _, r := range report.WorstRegions {
+			if r.X > 31 || r.X+r.Width <= 31 {
+				t.Errorf("%s: worst region %+v misses the boundary", tt.name, r)
+			}
+		}
+		// The boundary is drawn red and the flat areas stay gray
+		if c := color.NRGBAModel.Convert(heatmap.At(31, 16)).(color.NRGBA); c.R <= c.G || c.G != c.B {
+			t.Errorf("%s: boundary drawn as %v, want red", tt.name, c)
+		}
+		if c := color.NRGBAModel.Convert(heatmap.At(5, 16)).(color.NRGBA); c.R != c.G || c.G != c.B {
+			t.Errorf("%s: flat area drawn as %v, want gray", tt.name, c)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+const (
+	// WCAG 2.x minimum contrast for normal text (AA)
+	WCAGMinContrast = 4.5
+	// WCAG 2.x minimum contrast for large text (AA)
+	WCAGMinContrastLarge = 3.0
+
+	// Sobel magnitude above which a pixel counts as an edge
+	auditEdgeThreshold = 48
+	// Side of the square blocks scanned for text-like edge density
+	auditBlockSize = 8
+	// Share of edge pixels a block needs to look like text rather than flat fill or noise
+	auditMinEdgeDensity = 0.08
+	auditMaxEdgeDensity = 0.6
+	// Smallest region, in blocks, worth auditing
+	auditMinBlocks = 2
+	// Most pixels sampled from a region when splitting it into two colors
+	auditMaxSamples = 4096
+)
+
+// AuditRegion is a text-like region with its dominant foreground and background colors
+type AuditRegion struct {
+	X          int                `json:"x"`
+	Y          int                `json:"y"`
+	Width      int                `json:"width"`
+	Height     int                `json:"height"`
+	Foreground string             `json:"foreground"`
+	Background string             `json:"background"`
+	Contrast   map[string]float64 `json:"contrast"`
+	FailsFor   []string           `json:"fails_for"`
+}
+
+// AuditReport lists the text-like regions whose contrast fails WCAG for some vision type
+type AuditReport struct {
+	MinContrast  float64       `json:"min_contrast"`
+	RegionCount  int           `json:"region_count"`
+	FailingCount int           `json:"failing_count"`
+	Failing      []AuditRegion `json:"failing"`
+}
+
+// RelativeLuminance returns the WCAG relative luminance of an sRGB color with components in [0,1]
+func RelativeLuminance(r, g, b float64) float64 {
+	return 0.2126*SRGBToLinear(r) + 0.7152*SRGBToLinear(g) + 0.0722*SRGBToLinear(b)
+}
+
+// ContrastRatio returns the WCAG contrast ratio between two relative luminances, from 1 to 21
+func ContrastRatio(l1, l2 float64) float64 {
+	if l1 < l2 {
+		l1, l2 = l2, l1
+	}
+	return (l1 + 0.05) / (l2 + 0.05)
+}
+
+// AuditContrast finds text-like regions using edge density, splits each into a
+// foreground and background color, and checks their WCAG contrast under normal
+// vision and every simulated vision type. It returns the original image with
+// failing regions outlined in red and passing ones in green.
+func AuditContrast(img image.Image, model SimulationModel, minContrast float64) (image.Image, AuditReport, error) {
+	if minContrast <= 0 {
+		minContrast = WCAGMinContrast
+	}
+	report := AuditReport{MinContrast: minContrast, Failing: []AuditRegion{}}
+
+	visions := append([]string{"normal"}, VisionTypes...)
+	transforms := make([]ColorTransform, len(visions))
+	for i, vision := range visions {
+		t, err := VisionTransform(vision, model)
+		if err != nil {
+			return nil, report, err
+		}
+		transforms[i] = t
+	}
+
+	bounds := img.Bounds()
+	out := image.NewNRGBA(bounds)
+	draw.Draw(out, bounds, img, bounds.Min, draw.Src)
+
+	for _, rect := range textRegions(img) {
+		fg, bg, ok := dominantColorPair(img, rect)
+		if !ok {
+			continue
+		}
+		region := AuditRegion{
+			X:          rect.Min.X,
+			Y:          rect.Min.Y,
+			Width:      rect.Dx(),
+			Height:     rect.Dy(),
+			Foreground: HexColor(fg),
+			Background: HexColor(bg),
+			Contrast:   make(map[string]float64, len(visions)),
+			FailsFor:   []string{},
+		}
+		for i, t := range transforms {
+			fr, fgG, fb := t(fg[0], fg[1], fg[2])
+			br, bgG, bb := t(bg[0], bg[1], bg[2])
+			ratio := ContrastRatio(RelativeLuminance(fr, fgG, fb), RelativeLuminance(br, bgG, bb))
+			region.Contrast[visions[i]] = math.Round(ratio*100) / 100
+			if ratio < minContrast {
+				region.FailsFor = append(region.FailsFor, visions[i])
+			}
+		}
+
+		report.RegionCount++
+		outline := color.NRGBA{0, 200, 0, 255}
+		if len(region.FailsFor) > 0 {
+			report.FailingCount++
+			report.Failing = append(report.Failing, region)
+			outline = color.NRGBA{255, 0, 0, 255}
+		}
+		drawOutline(out, rect, outline, 2)
+	}
+	return out, report, nil
+}
+
+// textRegions groups blocks with a text-like density of Sobel edges into bounding boxes
+func textRegions(img image.Image) []image.Rectangle {
+	bounds := img.Bounds()
+	edges := ApplyEdgeDetection(img)
+	cols := (bounds.Dx() + auditBlockSize - 1) / auditBlockSize
+	rows := (bounds.Dy() + auditBlockSize - 1) / auditBlockSize
+
+	textLike := make([]bool, cols*rows)
+	for by := 0; by < rows; by++ {
+		for bx := 0; bx < cols; bx++ {
+			block := image.Rect(bx*auditBlockSize, by*auditBlockSize, (bx+1)*auditBlockSize, (by+1)*auditBlockSize).
+				Add(bounds.Min).Intersect(bounds)
+			var count, total int
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				for x := block.Min.X; x < block.Max.X; x++ {
+					r, _, _, _ := edges.At(x, y).RGBA()
+					if r>>8 > auditEdgeThreshold {
+						count++
+					}
+					total++
+				}
+			}
+			density := float64(count) / float64(total)
+			textLike[by*cols+bx] = density >= auditMinEdgeDensity && density <= auditMaxEdgeDensity
+		}
+	}
+
+	// Flood-fill connected text-like blocks (8-connected) into regions
+	visited := make([]bool, len(textLike))
+	var regions []image.Rectangle
+	for start := range textLike {
+		if !textLike[start] || visited[start] {
+			continue
+		}
+		minX, minY, maxX, maxY := cols, rows, -1, -1
+		blocks := 0
+		queue := []int{start}
+		visited[start] = true
+		for len(queue) > 0 {
+			i := queue[0]
+			queue = queue[1:]
+			bx, by := i%cols, i/cols
+			blocks++
+			minX, minY = min(minX, bx), min(minY, by)
+			maxX, maxY = max(maxX, bx), max(maxY, by)
+
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx, ny := bx+dx, by+dy
+					if nx < 0 || ny < 0 || nx >= cols || ny >= rows {
+						continue
+					}
+					if n := ny*cols + nx; textLike[n] && !visited[n] {
+						visited[n] = true
+						queue = append(queue, n)
+					}
+				}
+			}
+		}
+		if blocks < auditMinBlocks {
+			continue
+		}
+		regions = append(regions, image.Rect(
+			minX*auditBlockSize, minY*auditBlockSize,
+			(maxX+1)*auditBlockSize, (maxY+1)*auditBlockSize,
+		).Add(bounds.Min).Intersect(bounds))
+	}
+	return regions
+}
+
+// dominantColorPair splits the region's pixels into two clusters in Lab and returns
+// the minority cluster as foreground and the majority as background. Large regions
+// are sampled on an even grid of at most auditMaxSamples pixels. ok is false for an
+// empty region.
+func dominantColorPair(img image.Image, rect image.Rectangle) (fg, bg [3]float64, ok bool) {
+	if rect.Empty() {
+		return fg, bg, false
+	}
+	step := max(1, int(math.Ceil(math.Sqrt(float64(rect.Dx()*rect.Dy())/auditMaxSamples))))
+
+	pixels := make([][3]float64, 0, min(rect.Dx()*rect.Dy(), 2*auditMaxSamples))
+	labs := make([]Lab, 0, cap(pixels))
+	darkest, lightest := 0, 0
+	for y := rect.Min.Y; y < rect.Max.Y; y += step {
+		for x := rect.Min.X; x < rect.Max.X; x += step {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			rgb := [3]float64{float64(c.R) / 255, float64(c.G) / 255, float64(c.B) / 255}
+			lab := LabFromSRGB(rgb[0], rgb[1], rgb[2])
+			if len(labs) > 0 && lab.L < labs[darkest].L {
+				darkest = len(labs)
+			}
+			if len(labs) > 0 && lab.L > labs[lightest].L {
+				lightest = len(labs)
+			}
+			pixels = append(pixels, rgb)
+			labs = append(labs, lab)
+		}
+	}
+
+	centers := [2]Lab{labs[darkest], labs[lightest]}
+	assignment := make([]int, len(labs))
+	var sums [2][3]float64
+	var counts [2]int
+	for iter := 0; iter < kMeansIterations; iter++ {
+		sums, counts = [2][3]float64{}, [2]int{}
+		for i, lab := range labs {
+			k := 0
+			if lab.DistanceTo(centers[1]) < lab.DistanceTo(centers[0]) {
+				k = 1
+			}
+			assignment[i] = k
+			sums[k][0] += lab.L
+			sums[k][1] += lab.A
+			sums[k][2] += lab.B
+			counts[k]++
+		}
+		for k := range centers {
+			if counts[k] > 0 {
+				n := float64(counts[k])
+				centers[k] = Lab{sums[k][0] / n, sums[k][1] / n, sums[k][2] / n}
+			}
+		}
+	}
+
+	// Report the mean sRGB color of each cluster
+	var means [2][3]float64
+	for i, rgb := range pixels {
+		k := assignment[i]
+		for c := 0; c < 3; c++ {
+			means[k][c] += rgb[c]
+		}
+	}
+	for k := range means {
+		for c := 0; c < 3; c++ {
+			if counts[k] > 0 {
+				means[k][c] /= float64(counts[k])
+			}
+		}
+	}
+	if counts[0] < counts[1] {
+		return means[0], means[1], true
+	}
+	return means[1], means[0], true
+}
+
+// drawOutline draws a rectangle border of the given thickness
+func drawOutline(img draw.Image, rect image.Rectangle, c color.Color, thickness int) {
+	src := image.NewUniform(c)
+	for _, edge := range []image.Rectangle{
+		image.Rect(rect.Min.X, rect.Min.Y, rect.Max.X, rect.Min.Y+thickness),
+		image.Rect(rect.Min.X, rect.Max.Y-thickness, rect.Max.X, rect.Max.Y),
+		image.Rect(rect.Min.X, rect.Min.Y, rect.Min.X+thickness, rect.Max.Y),
+		image.Rect(rect.Max.X-thickness, rect.Min.Y, rect.Max.X, rect.Max.Y),
+	} {
+		draw.Draw(img, edge.Intersect(rect), src, image.Point{}, draw.Over)
+	}
+}
@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"reflect"
+	"testing"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+func TestContrastRatio(t *testing.T) {
+	tests := []struct {
+		name   string
+		c1, c2 [3]float64
+		want   float64
+	}{
+		{"black on white", [3]float64{0, 0, 0}, [3]float64{1, 1, 1}, 21},
+		{"white on black", [3]float64{1, 1, 1}, [3]float64{0, 0, 0}, 21},
+		{"equal grays", [3]float64{0.5, 0.5, 0.5}, [3]float64{0.5, 0.5, 0.5}, 1},
+		{"equal colors", [3]float64{0.8, 0.1, 0.3}, [3]float64{0.8, 0.1, 0.3}, 1},
+		// WebAIM's checker gives 4.0 for pure red on white and 5.25 on black
+		{"red on white", [3]float64{1, 0, 0}, [3]float64{1, 1, 1}, 3.998},
+		{"red on black", [3]float64{1, 0, 0}, [3]float64{0, 0, 0}, 5.252},
+	}
+	for _, tt := range tests {
+		l1 := RelativeLuminance(tt.c1[0], tt.c1[1], tt.c1[2])
+		l2 := RelativeLuminance(tt.c2[0], tt.c2[1], tt.c2[2])
+		if got := ContrastRatio(l1, l2); math.Abs(got-tt.want) > 1e-3 {
+			t.Errorf("%s: contrast %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// textImage draws a line of text in fg over bg
+func textImage(fg, bg color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 160, 48))
+	draw.Draw(img, img.Rect, image.NewUniform(bg), image.Point{}, draw.Src)
+	d := font.Drawer{Dst: img, Src: image.NewUniform(fg), Face: basicfont.Face7x13, Dot: fixed.P(12, 28)}
+	d.DrawString("Hello, World")
+	return img
+}
+
+func TestAuditContrast(t *testing.T) {
+	black, white := color.NRGBA{0, 0, 0, 255}, color.NRGBA{255, 255, 255, 255}
+	red := color.NRGBA{255, 0, 0, 255}
+	allVisions := append([]string{"normal"}, VisionTypes...)
+
+	tests := []struct {
+		name        string
+		img         image.Image
+		minContrast float64
+		regions     int
+		failsFor    []string
+	}{
+		{"black on white", textImage(black, white), 0, 1, nil},
+		// Protans lose the brightness of red
+		{"red on black", textImage(red, black), 0, 1, []string{"protanopia", "protanomaly"}},
+		{"red on black at large text contrast", textImage(red, black), WCAGMinContrastLarge, 1, nil},
+		{"gray on gray", textImage(color.NRGBA{120, 120, 120, 255}, color.NRGBA{150, 150, 150, 255}), 0, 1, allVisions},
+		{"blank page", textImage(white, white), 0, 0, nil},
+	}
+	for _, tt := range tests {
+		out, report, err := AuditContrast(tt.img, ModelMachado, tt.minContrast)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.RegionCount != tt.regions {
+			t.Errorf("%s: %d regions, want %d", tt.name, report.RegionCount, tt.regions)
+			continue
+		}
+		if tt.failsFor == nil {
+			if report.FailingCount != 0 {
+				t.Errorf("%s: failing regions %+v, want none", tt.name, report.Failing)
+			}
+			continue
+		}
+		if report.FailingCount != 1 || len(report.Failing) != 1 {
+			t.Fatalf("%s: failing regions %+v, want one", tt.name, report.Failing)
+		}
+		region := report.Failing[0]
+		if !reflect.DeepEqual(region.FailsFor, tt.failsFor) {
+			t.Errorf("%s: fails for %v, want %v", tt.name, region.FailsFor, tt.failsFor)
+		}
+		// The region covers the text, and is outlined in red
+		text := image.Rect(12, 18, 96, 30)
+		if rect := image.Rect(region.X, region.Y, region.X+region.Width, region.Y+region.Height); !text.In(rect) {
+			t.Errorf("%s: region %v does not cover the text at %v", tt.name, rect, text)
+		}
+		if c := color.NRGBAModel.Convert(out.At(region.X, region.Y)); c != (color.NRGBA{255, 0, 0, 255}) {
+			t.Errorf("%s: region corner is %v, want the red outline", tt.name, c)
+		}
+	}
+}
+
+func TestDominantColorPair(t *testing.T) {
+	blue, yellow := color.NRGBA{0, 0, 255, 255}, color.NRGBA{255, 255, 0, 255}
+	// Three quarters light, one quarter dark, in 20×20 tiles
+	mixed := image.NewNRGBA(image.Rect(0, 0, 200, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 200; x++ {
+			c := color.NRGBA{230, 220, 210, 255}
+			if (x/20)%2 == 0 && (y/20)%2 == 0 {
+				c = color.NRGBA{30, 40, 60, 255}
+			}
+			mixed.SetNRGBA(x, y, c)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		img    image.Image
+		rect   image.Rectangle
+		fg, bg string
+		ok     bool
+	}{
+		// The text is the smaller cluster, so it is the foreground
+		{"dark text", textImage(blue, yellow), image.Rect(0, 0, 160, 48), "#0000ff", "#ffff00", true},
+		{"light text", textImage(yellow, blue), image.Rect(0, 0, 160, 48), "#ffff00", "#0000ff", true},
+		// Large enough to be sampled on a grid
+		{"sampled", mixed, mixed.Rect, "#1e283c", "#e6dcd2", true},
+		{"empty", mixed, image.Rectangle{}, "#000000", "#000000", false},
+	}
+	for _, tt := range tests {
+		fg, bg, ok := dominantColorPair(tt.img, tt.rect)
+		if ok != tt.ok || HexColor(fg) != tt.fg || HexColor(bg) != tt.bg {
+			t.Errorf("%s: got %s on %s (%v), want %s on %s (%v)", tt.name, HexColor(fg), HexColor(bg), ok, tt.fg, tt.bg, tt.ok)
+		}
+	}
+}
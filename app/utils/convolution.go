@@ -0,0 +1,332 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Largest kernel side accepted, which bounds the work per pixel
+const MaxKernelSize = 63
+
+// EdgeMode selects how pixels beyond the image border are sampled
+type EdgeMode string
+
+const (
+	EdgeClamp       EdgeMode = "clamp"       // repeat the nearest border pixel
+	EdgeMirror      EdgeMode = "mirror"      // reflect about the border pixel
+	EdgeWrap        EdgeMode = "wrap"        // tile the image
+	EdgeTransparent EdgeMode = "transparent" // treat outside pixels as transparent
+)
+
+// EdgeModes lists the supported edge modes
+var EdgeModes = []EdgeMode{EdgeClamp, EdgeMirror, EdgeWrap, EdgeTransparent}
+
+// ParseEdgeMode validates an edge mode name, defaulting to clamp
+func ParseEdgeMode(name string) (EdgeMode, error) {
+	if name == "" {
+		return EdgeClamp, nil
+	}
+	for _, mode := range EdgeModes {
+		if string(mode) == name {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("unknown edge mode %q", name)
+}
+
+// Kernel is a square convolution kernel with an odd side, stored row by row
+type Kernel struct {
+	Size    int
+	Weights []float64
+}
+
+// NewKernel builds a kernel from its rows
+func NewKernel(rows [][]float64) (Kernel, error) {
+	n := len(rows)
+	if n%2 == 0 || n > MaxKernelSize {
+		return Kernel{}, fmt.Errorf("kernel must have an odd number of rows up to %d, got %d", MaxKernelSize, n)
+	}
+	k := Kernel{Size: n, Weights: make([]float64, 0, n*n)}
+	for i, row := range rows {
+		if len(row) != n {
+			return Kernel{}, fmt.Errorf("kernel must be square: row %d has %d values, expected %d", i+1, len(row), n)
+		}
+		k.Weights = append(k.Weights, row...)
+	}
+	return k, nil
+}
+
+// ParseKernel reads a kernel written as rows separated by ';' with values separated
+// by ',' or spaces, such as "0,-1,0; -1,5,-1; 0,-1,0"
+func ParseKernel(s string) (Kernel, error) {
+	rows, err := parseRows(s)
+	if err != nil {
+		return Kernel{}, fmt.Errorf("invalid kernel: %v", err)
+	}
+	return NewKernel(rows)
+}
+
+// parseRows reads rows of numbers separated by ';', with values separated by ',' or spaces
+func parseRows(s string) ([][]float64, error) {
+	var rows [][]float64
+	for _, line := range strings.Split(s, ";") {
+		var row []float64
+		for _, field := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+			v, err := strconv.ParseFloat(field, 64)
+			if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+				return nil, fmt.Errorf("invalid value %q", field)
+			}
+			row = append(row, v)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// Normalized scales the kernel so its weights sum to one. Kernels summing to zero,
+// such as edge detectors, are returned unchanged.
+func (k Kernel) Normalized() Kernel {
+	var sum float64
+	for _, w := range k.Weights {
+		sum += w
+	}
+	if math.Abs(sum) < 1e-12 {
+		return k
+	}
+	out := Kernel{Size: k.Size, Weights: make([]float64, len(k.Weights))}
+	for i, w := range k.Weights {
+		out.Weights[i] = w / sum
+	}
+	return out
+}
+
+// Separable splits the kernel into a vertical and a horizontal 1D kernel whose
+// outer product reproduces it, when the kernel has rank one
+func (k Kernel) Separable() (vertical, horizontal []float64, ok bool) {
+	// Use the row and column through the largest weight
+	pivot := 0
+	for i, w := range k.Weights {
+		if math.Abs(w) > math.Abs(k.Weights[pivot]) {
+			pivot = i
+		}
+	}
+	p := k.Weights[pivot]
+	if p == 0 {
+		return nil, nil, false
+	}
+	pr, pc := pivot/k.Size, pivot%k.Size
+
+	vertical = make([]float64, k.Size)
+	horizontal = make([]float64, k.Size)
+	for i := 0; i < k.Size; i++ {
+		vertical[i] = k.Weights[i*k.Size+pc] / p
+		horizontal[i] = k.Weights[pr*k.Size+i]
+	}
+	for i := 0; i < k.Size; i++ {
+		for j := 0; j < k.Size; j++ {
+			if math.Abs(vertical[i]*horizontal[j]-k.Weights[i*k.Size+j]) > 1e-9 {
+				return nil, nil, false
+			}
+		}
+	}
+	return vertical, horizontal, true
+}
+
+// BoxKernel1D returns the 1D kernel of a box blur with the given radius
+func BoxKernel1D(radius int) []float64 {
+	k := make([]float64, 2*radius+1)
+	for i := range k {
+		k[i] = 1 / float64(len(k))
+	}
+	return k
+}
+
+// GaussianKernel1D returns a normalized 1D Gaussian. A radius of zero or less
+// picks three standard deviations.
+func GaussianKernel1D(sigma float64, radius int) []float64 {
+	if radius <= 0 {
+		radius = int(math.Ceil(3 * sigma))
+	}
+	k := make([]float64, 2*radius+1)
+	var sum float64
+	for i := range k {
+		x := float64(i - radius)
+		k[i] = math.Exp(-x * x / (2 * sigma * sigma))
+		sum += k[i]
+	}
+	for i := range k {
+		k[i] /= sum
+	}
+	return k
+}
+
+// edgeIndex maps a coordinate that may lie outside [0, n) onto the image, returning
+// -1 when the sample is transparent
+func edgeIndex(i, n int, mode EdgeMode) int {
+	if i >= 0 && i < n {
+		return i
+	}
+	switch mode {
+	case EdgeMirror:
+		if n == 1 {
+			return 0
+		}
+		period := 2 * (n - 1)
+		i = ((i % period) + period) % period
+		if i >= n {
+			i = period - i
+		}
+		return i
+	case EdgeWrap:
+		return ((i % n) + n) % n
+	case EdgeTransparent:
+		return -1
+	}
+	return min(max(i, 0), n-1)
+}
+
+// edgeTable precomputes edgeIndex for offsets -radius .. n+radius-1
+func edgeTable(n, radius int, mode EdgeMode) []int {
+	table := make([]int, n+2*radius)
+	for i := range table {
+		table[i] = edgeIndex(i-radius, n, mode)
+	}
+	return table
+}
+
+// Convolve applies the kernel in linear light with premultiplied alpha, so
+// transparent pixels do not bleed their color and alpha is filtered with the rest.
+// Rank-one kernels are applied as two 1D passes.
+func Convolve(img image.Image, k Kernel, edge EdgeMode) (image.Image, error) {
+	if k.Size%2 == 0 || k.Size > MaxKernelSize || len(k.Weights) != k.Size*k.Size {
+		return nil, fmt.Errorf("invalid %d×%d kernel", k.Size, k.Size)
+	}
+	if vertical, horizontal, ok := k.Separable(); ok {
+		return ConvolveSeparable(img, vertical, horizontal, edge)
+	}
+
+	src := ToLinear(img)
+	bounds := src.Rect
+	w, h := bounds.Dx(), bounds.Dy()
+	r := k.Size / 2
+	xs := edgeTable(w, r, edge)
+	ys := edgeTable(h, r, edge)
+	out := NewLinearImage(bounds)
+	out.HighBitDepth = src.HighBitDepth
+
+	parallelRows(0, h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			o := y * out.Stride
+			for x := 0; x < w; x++ {
+				var acc [4]float32
+				for ky := 0; ky < k.Size; ky++ {
+					sy := ys[y+ky]
+					if sy < 0 {
+						continue
+					}
+					row := src.Pix[sy*src.Stride:]
+					weights := k.Weights[ky*k.Size:]
+					for kx := 0; kx < k.Size; kx++ {
+						sx := xs[x+kx]
+						if sx < 0 {
+							continue
+						}
+						wt := float32(weights[kx])
+						i := 4 * sx
+						acc[0] += row[i] * wt
+						acc[1] += row[i+1] * wt
+						acc[2] += row[i+2] * wt
+						acc[3] += row[i+3] * wt
+					}
+				}
+				copy(out.Pix[o+4*x:o+4*x+4], acc[:])
+			}
+		}
+	})
+	return out.Image(), nil
+}
+
+// ConvolveSeparable applies a horizontal then a vertical 1D kernel, each of odd length
+func ConvolveSeparable(img image.Image, vertical, horizontal []float64, edge EdgeMode) (image.Image, error) {
+	for _, k := range [][]float64{vertical, horizontal} {
+		if len(k)%2 == 0 || len(k) > MaxKernelSize {
+			return nil, fmt.Errorf("1D kernels must have an odd length up to %d, got %d", MaxKernelSize, len(k))
+		}
+	}
+
+	src := ToLinear(img)
+	tmp := NewLinearImage(src.Rect)
+	out := NewLinearImage(src.Rect)
+	out.HighBitDepth = src.HighBitDepth
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+
+	// Horizontal pass
+	xs := edgeTable(w, len(horizontal)/2, edge)
+	parallelRows(0, h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := src.Pix[y*src.Stride:]
+			dst := tmp.Pix[y*tmp.Stride:]
+			for x := 0; x < w; x++ {
+				var acc [4]float32
+				for kx, weight := range horizontal {
+					sx := xs[x+kx]
+					if sx < 0 {
+						continue
+					}
+					wt := float32(weight)
+					i := 4 * sx
+					acc[0] += row[i] * wt
+					acc[1] += row[i+1] * wt
+					acc[2] += row[i+2] * wt
+					acc[3] += row[i+3] * wt
+				}
+				copy(dst[4*x:4*x+4], acc[:])
+			}
+		}
+	})
+
+	// Vertical pass, accumulating whole rows for cache-friendly access
+	ys := edgeTable(h, len(vertical)/2, edge)
+	parallelRows(0, h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			dst := out.Pix[y*out.Stride : (y+1)*out.Stride]
+			for ky, weight := range vertical {
+				sy := ys[y+ky]
+				if sy < 0 {
+					continue
+				}
+				wt := float32(weight)
+				row := tmp.Pix[sy*tmp.Stride : (sy+1)*tmp.Stride]
+				for i := range dst {
+					dst[i] += row[i] * wt
+				}
+			}
+		}
+	})
+	return out.Image(), nil
+}
+
+// BoxBlur averages each pixel with its neighbors within the radius
+func BoxBlur(img image.Image, radius int, edge EdgeMode) (image.Image, error) {
+	if radius < 1 || 2*radius+1 > MaxKernelSize {
+		return nil, fmt.Errorf("box blur radius must be between 1 and %d, got %d", MaxKernelSize/2, radius)
+	}
+	k := BoxKernel1D(radius)
+	return ConvolveSeparable(img, k, k, edge)
+}
+
+// GaussianBlur blurs with a Gaussian of the given standard deviation in pixels.
+// A radius of zero or less picks three standard deviations.
+func GaussianBlur(img image.Image, sigma float64, radius int, edge EdgeMode) (image.Image, error) {
+	if sigma <= 0 || math.IsNaN(sigma) {
+		return nil, fmt.Errorf("gaussian sigma must be positive, got %v", sigma)
+	}
+	k := GaussianKernel1D(sigma, radius)
+	if len(k) > MaxKernelSize {
+		return nil, fmt.Errorf("gaussian kernel of %d taps exceeds the maximum of %d; lower sigma or radius", len(k), MaxKernelSize)
+	}
+	return ConvolveSeparable(img, k, k, edge)
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestConvolutionKeepsAlpha(t *testing.T) {
+	// Opaque red on the left, fully transparent green on the right: premultiplied
+	// filtering must not let the hidden green bleed into the edge
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 32; x++ {
+			if x < 16 {
+				img.SetNRGBA(x, y, color.NRGBA{255, 0, 0, 255})
+			} else {
+				img.SetNRGBA(x, y, color.NRGBA{0, 255, 0, 0})
+			}
+		}
+	}
+
+	for _, edge := range []EdgeMode{EdgeClamp, EdgeTransparent} {
+		out, err := BoxBlur(img, 2, edge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nrgba, ok := out.(*image.NRGBA)
+		if !ok {
+			t.Fatalf("BoxBlur returned %T, want *image.NRGBA", out)
+		}
+		y := 4
+		for x := 0; x < 32; x++ {
+			c := nrgba.NRGBAAt(x, y)
+			// Count the opaque columns among x-2 .. x+2; beyond the left edge clamp
+			// repeats column 0 and transparent adds nothing
+			covered := min(max(0, 18-x), 5)
+			if edge == EdgeTransparent && x < 2 {
+				covered = 3 + x
+			}
+			if want := uint8(float64(covered)/5*255 + 0.5); c.A != want {
+				t.Errorf("edge %s: alpha at x=%d is %d, want %d", edge, x, c.A, want)
+			}
+			if c.A > 0 && (c.R != 255 || c.G != 0 || c.B != 0) {
+				t.Errorf("edge %s: color at x=%d is %v, want pure red", edge, x, c)
+			}
+		}
+	}
+}
+
+func TestConvolutionKeepsHighBitDepthAlpha(t *testing.T) {
+	img := image.NewNRGBA64(image.Rect(0, 0, 64, 64))
+	for k := 0; k < 64*64; k++ {
+		img.SetNRGBA64(k%64, k/64, color.NRGBA64{0x8000, 0x4000, 0x2000, uint16(k * 16)})
+	}
+	identity, err := NewKernel([][]float64{{0, 0, 0}, {0, 1, 0}, {0, 0, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := Convolve(img, identity, EdgeClamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deep, ok := out.(*image.NRGBA64)
+	if !ok {
+		t.Fatalf("Convolve returned %T, want *image.NRGBA64", out)
+	}
+	for k := 0; k < 64*64; k++ {
+		if got, want := deep.NRGBA64At(k%64, k/64).A, img.NRGBA64At(k%64, k/64).A; got != want {
+			t.Fatalf("alpha %d became %d", want, got)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+)
+
+// Deficiency identifies the cone class affected by a color vision deficiency
+type Deficiency int
+
+const (
+	Protan Deficiency = iota // missing or anomalous L cones
+	Deutan                   // missing or anomalous M cones
+	Tritan                   // missing or anomalous S cones
+)
+
+// SimulationModel selects the algorithm used to simulate a deficiency
+type SimulationModel string
+
+const (
+	// ModelLegacy multiplies gamma-encoded sRGB by the fixed matrices above for
+	// dichromacy; anomalous trichromacy follows Machado's severity tables
+	ModelLegacy SimulationModel = "legacy"
+	// ModelBrettel projects onto two LMS half-planes (Brettel, Viénot & Mollon 1997)
+	ModelBrettel SimulationModel = "brettel"
+	// ModelVienot projects onto a single LMS plane (Viénot, Brettel & Mollon 1999)
+	ModelVienot SimulationModel = "vienot"
+	// ModelMachado uses the physiologically based matrices of Machado et al. 2009
+	ModelMachado SimulationModel = "machado"
+)
+
+// DefaultAnomalySeverity is used for anomalous trichromacy when no severity is given
+const DefaultAnomalySeverity = 0.6
+
+// ColorTransform maps a gamma-encoded sRGB color with components in [0,1] to another
+type ColorTransform func(r, g, b float64) (float64, float64, float64)
+
+// Chromaticities of the monochromatic anchor stimuli used by Brettel and Viénot
+var (
+	anchor475 = lmsFromChromaticity(0.1096, 0.0868)
+	anchor485 = lmsFromChromaticity(0.0687, 0.2007)
+	anchor575 = lmsFromChromaticity(0.4788, 0.5202)
+	anchor660 = lmsFromChromaticity(0.7300, 0.2700)
+	whiteLMS  = mulVector(lmsFromLinearRGB, [3]float64{1, 1, 1})
+)
+
+// Machado et al. 2009 matrices for severities 0.0, 0.1, ... 1.0, applied to linear sRGB
+var machadoMatrices = map[Deficiency][11][3][3]float64{
+	Protan: {
+		identityMatrix,
+		{{0.856167, 0.182038, -0.038205}, {0.029342, 0.955115, 0.015544}, {-0.002880, -0.001563, 1.004443}},
+		{{0.734766, 0.334872, -0.069637}, {0.051840, 0.919198, 0.028963}, {-0.004928, -0.004209, 1.009137}},
+		{{0.630323, 0.465641, -0.095964}, {0.069181, 0.890046, 0.040773}, {-0.006308, -0.007724, 1.014032}},
+		{{0.539009, 0.579343, -0.118352}, {0.082546, 0.866121, 0.051332}, {-0.007136, -0.011959, 1.019095}},
+		{{0.458064, 0.679578, -0.137642}, {0.092785, 0.846313, 0.060902}, {-0.007494, -0.016807, 1.024301}},
+		{{0.385450, 0.769005, -0.154455}, {0.100526, 0.829802, 0.069673}, {-0.007442, -0.022190, 1.029632}},
+		{{0.319627, 0.849633, -0.169261}, {0.106241, 0.815969, 0.077790}, {-0.007025, -0.028051, 1.035076}},
+		{{0.259411, 0.923008, -0.182420}, {0.110296, 0.804340, 0.085364}, {-0.006276, -0.034346, 1.040622}},
+		{{0.203876, 0.990338, -0.194214}, {0.112975, 0.794542, 0.092483}, {-0.005222, -0.041043, 1.046265}},
+		{{0.152286, 1.052583, -0.204868}, {0.114503, 0.786281, 0.099216}, {-0.003882, -0.048116, 1.051998}},
+	},
+	Deutan: {
+		identityMatrix,
+		{{0.866435, 0.177704, -0.044139}, {0.049567, 0.939063, 0.011370}, {-0.003453, 0.007233, 0.996220}},
+		{{0.760729, 0.319078, -0.079807}, {0.090568, 0.889315, 0.020117}, {-0.006027, 0.013325, 0.992702}},
+		{{0.675425, 0.433850, -0.109275}, {0.125303, 0.847755, 0.026942}, {-0.007950, 0.018572, 0.989378}},
+		{{0.605511, 0.528560, -0.134071}, {0.155318, 0.812366, 0.032316}, {-0.009376, 0.023176, 0.986200}},
+		{{0.547494, 0.607765, -0.155259}, {0.181692, 0.781742, 0.036566}, {-0.010410, 0.027275, 0.983136}},
+		{{0.498864, 0.674741, -0.173604}, {0.205199, 0.754872, 0.039929}, {-0.011131, 0.030969, 0.980162}},
+		{{0.457771, 0.731899, -0.189670}, {0.226409, 0.731012, 0.042579}, {-0.011595, 0.034333, 0.977261}},
+		{{0.422823, 0.781057, -0.203881}, {0.245752, 0.709602, 0.044646}, {-0.011843, 0.037423, 0.974421}},
+		{{0.392952, 0.823610, -0.216562}, {0.263559, 0.690210, 0.046232}, {-0.011910, 0.040281, 0.971630}},
+		{{0.367322, 0.860646, -0.227968}, {0.280085, 0.672501, 0.047413}, {-0.011820, 0.042940, 0.968881}},
+	},
+	Tritan: {
+		identityMatrix,
+		{{0.926670, 0.092514, -0.019184}, {0.021191, 0.964503, 0.014306}, {0.008437, 0.054813, 0.936750}},
+		{{0.895720, 0.133330, -0.029050}, {0.029997, 0.945400, 0.024603}, {0.013027, 0.104707, 0.882266}},
+		{{0.905871, 0.127791, -0.033662}, {0.026856, 0.941251, 0.031893}, {0.013410, 0.148296, 0.838294}},
+		{{0.948035, 0.089490, -0.037526}, {0.014364, 0.946792, 0.038844}, {0.010853, 0.193991, 0.795156}},
+		{{1.017277, 0.027029, -0.044306}, {-0.006113, 0.958479, 0.047634}, {0.006379, 0.248708, 0.744913}},
+		{{1.104996, -0.046633, -0.058363}, {-0.032137, 0.971635, 0.060503}, {0.001336, 0.317922, 0.680742}},
+		{{1.193214, -0.109812, -0.083402}, {-0.058496, 0.979410, 0.079086}, {-0.002346, 0.403492, 0.598854}},
+		{{1.257728, -0.139648, -0.118081}, {-0.078003, 0.975409, 0.102594}, {-0.003316, 0.501214, 0.502102}},
+		{{1.278864, -0.125333, -0.153531}, {-0.084748, 0.957674, 0.127074}, {-0.000989, 0.601151, 0.399838}},
+		{{1.255528, -0.076749, -0.178779}, {-0.078411, 0.930809, 0.147602}, {0.004733, 0.691367, 0.303900}},
+	},
+}
+
+var legacyMatrices = map[Deficiency][3][3]float64{
+	Protan: ProtanopiaMatrix,
+	Deutan: DeuteranopiaMatrix,
+	Tritan: TritanopiaMatrix,
+}
+
+// String returns the short name of the deficiency
+func (d Deficiency) String() string {
+	switch d {
+	case Protan:
+		return "protan"
+	case Deutan:
+		return "deutan"
+	case Tritan:
+		return "tritan"
+	}
+	return fmt.Sprintf("Deficiency(%d)", int(d))
+}
+
+// ParseDeficiency accepts names such as "protan", "protanopia" or "protanomaly"
+func ParseDeficiency(name string) (Deficiency, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch {
+	case strings.HasPrefix(name, "protan"):
+		return Protan, nil
+	case strings.HasPrefix(name, "deutan"), strings.HasPrefix(name, "deuteran"):
+		return Deutan, nil
+	case strings.HasPrefix(name, "tritan"):
+		return Tritan, nil
+	}
+	return 0, fmt.Errorf("unknown deficiency %q", name)
+}
+
+// ParseSimulationModel validates a model name, defaulting to the legacy model when empty
+func ParseSimulationModel(name string) (SimulationModel, error) {
+	switch model := SimulationModel(strings.ToLower(strings.TrimSpace(name))); model {
+	case "":
+		return ModelLegacy, nil
+	case ModelLegacy, ModelBrettel, ModelVienot, ModelMachado:
+		return model, nil
+	}
+	return "", fmt.Errorf("unknown simulation model %q", name)
+}
+
+// NewSimulation returns the color transform simulating a deficiency with the given model.
+// Severity runs from 0 (normal vision) to 1 (full dichromacy); values in between
+// simulate anomalous trichromacy.
+func NewSimulation(d Deficiency, model SimulationModel, severity float64) (ColorTransform, error) {
+	if d < Protan || d > Tritan {
+		return nil, fmt.Errorf("unknown deficiency %d", int(d))
+	}
+	if severity < 0 || severity > 1 || math.IsNaN(severity) {
+		return nil, fmt.Errorf("severity %v out of range [0,1]", severity)
+	}
+
+	switch model {
+	case ModelLegacy:
+		return matrixTransform(legacyMatrix(d, severity)), nil
+	case ModelMachado:
+		return linearMatrixTransform(machadoMatrix(d, severity)), nil
+	case ModelVienot:
+		return withSeverity(vienotTransform(d), severity), nil
+	case ModelBrettel:
+		return withSeverity(brettelTransform(d), severity), nil
+	}
+	return nil, fmt.Errorf("unknown simulation model %q", model)
+}
+
+// SimulateDeficiency simulates how a person with the given deficiency and severity sees the image
+func SimulateDeficiency(img image.Image, d Deficiency, model SimulationModel, severity float64) (image.Image, error) {
+	transform, err := NewSimulation(d, model, severity)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep legacy output byte-for-byte identical to SimulateColorBlindness
+	if model == ModelLegacy {
+		return SimulateColorBlindness(img, legacyMatrix(d, severity)), nil
+	}
+	return ApplyColorTransform(img, transform), nil
+}
+
+// legacyMatrix blends the legacy dichromat matrix with the identity in gamma-encoded
+// sRGB, as the legacy filter applies it, so weaker severities fade out continuously
+func legacyMatrix(d Deficiency, severity float64) [3][3]float64 {
+	if severity == 1 {
+		return legacyMatrices[d]
+	}
+	return lerpMatrix(identityMatrix, legacyMatrices[d], severity)
+}
+
+// machadoMatrix interpolates Machado's severity table between the two nearest entries
+func machadoMatrix(d Deficiency, severity float64) [3][3]float64 {
+	table := machadoMatrices[d]
+	pos := severity * 10
+	lower := int(math.Floor(pos))
+	if lower >= 10 {
+		return table[10]
+	}
+	return lerpMatrix(table[lower], table[lower+1], pos-float64(lower))
+}
+
+// withSeverity blends a dichromat transform with normal vision in linear light
+func withSeverity(transform ColorTransform, severity float64) ColorTransform {
+	if severity == 1 {
+		return transform
+	}
+	return func(r, g, b float64) (float64, float64, float64) {
+		sr, sg, sb := transform(r, g, b)
+		blend := func(orig, sim float64) float64 {
+			lo, ls := SRGBToLinear(orig), SRGBToLinear(sim)
+			return LinearToSRGB(lo + (ls-lo)*severity)
+		}
+		return blend(r, sr), blend(g, sg), blend(b, sb)
+	}
+}
+
+// ApplyColorTransform maps every pixel of the image through the transform, preserving alpha
+func ApplyColorTransform(img image.Image, transform ColorTransform) image.Image {
+	bounds := img.Bounds()
+	if IsHighBitDepth(img) {
+		return applyColorTransform16(img, transform)
+	}
+	out := image.NewNRGBA(bounds)
+
+	parallelRows(bounds.Min.Y, bounds.Max.Y, func(y0, y1 int) {
+		scratch := make([]uint32, 4*bounds.Dx())
+		row := make([]uint8, 4*bounds.Dx())
+		for y := y0; y < y1; y++ {
+			nrgbaRow(img, y, bounds.Min.X, bounds.Max.X, scratch, row)
+			pix := out.Pix[out.PixOffset(bounds.Min.X, y):]
+			for i := 0; i < len(row); i += 4 {
+				r, g, b := transform(float64(row[i])/255, float64(row[i+1])/255, float64(row[i+2])/255)
+				pix[i+0], pix[i+1], pix[i+2], pix[i+3] = toByte(r), toByte(g), toByte(b), row[i+3]
+			}
+		}
+	})
+	return out
+}
+
+// applyColorTransform16 is ApplyColorTransform for images with 16 bits per channel
+func applyColorTransform16(img image.Image, transform ColorTransform) image.Image {
+	bounds := img.Bounds()
+	out := image.NewNRGBA64(bounds)
+
+	parallelRows(bounds.Min.Y, bounds.Max.Y, func(y0, y1 int) {
+		row := make([]uint32, 4*bounds.Dx())
+		for y := y0; y < y1; y++ {
+			premultipliedRow(img, y, bounds.Min.X, bounds.Max.X, row)
+			pix := out.Pix[out.PixOffset(bounds.Min.X, y):]
+			for i := 0; i < len(row); i += 4 {
+				r, g, b := unpremultiply16(row[i], row[i+1], row[i+2], row[i+3])
+				outR, outG, outB := transform(float64(r)/0xffff, float64(g)/0xffff, float64(b)/0xffff)
+				putNRGBA64(pix[2*i:], toUint16(outR), toUint16(outG), toUint16(outB), uint16(row[i+3]))
+			}
+		}
+	})
+	return out
+}
+
+// putNRGBA64 stores one pixel in the big-endian layout of image.NRGBA64 and image.RGBA64
+func putNRGBA64(pix []uint8, r, g, b, a uint16) {
+	pix[0], pix[1] = uint8(r>>8), uint8(r)
+	pix[2], pix[3] = uint8(g>>8), uint8(g)
+	pix[4], pix[5] = uint8(b>>8), uint8(b)
+	pix[6], pix[7] = uint8(a>>8), uint8(a)
+}
+
+func toUint16(v float64) uint16 {
+	return uint16(clampUnit(v)*0xffff + 0.5)
+}
+
+func toByte(v float64) uint8 {
+	return uint8(clampUnit(v)*255 + 0.5)
+}
+
+// matrixTransform applies a matrix directly to gamma-encoded values
+func matrixTransform(m [3][3]float64) ColorTransform {
+	return func(r, g, b float64) (float64, float64, float64) {
+		out := mulVector(m, [3]float64{r, g, b})
+		return clampUnit(out[0]), clampUnit(out[1]), clampUnit(out[2])
+	}
+}
+
+// linearMatrixTransform applies a matrix in linear light
+func linearMatrixTransform(m [3][3]float64) ColorTransform {
+	return func(r, g, b float64) (float64, float64, float64) {
+		out := mulVector(m, [3]float64{SRGBToLinear(r), SRGBToLinear(g), SRGBToLinear(b)})
+		return LinearToSRGB(clampUnit(out[0])), LinearToSRGB(clampUnit(out[1])), LinearToSRGB(clampUnit(out[2]))
+	}
+}
+
+// lmsTransform wraps an LMS-space projection into an sRGB color transform
+func lmsTransform(project func(lms [3]float64) [3]float64) ColorTransform {
+	return func(r, g, b float64) (float64, float64, float64) {
+		lms := mulVector(lmsFromLinearRGB, [3]float64{SRGBToLinear(r), SRGBToLinear(g), SRGBToLinear(b)})
+		out := mulVector(linearRGBFromLMS, project(lms))
+		return LinearToSRGB(clampUnit(out[0])), LinearToSRGB(clampUnit(out[1])), LinearToSRGB(clampUnit(out[2]))
+	}
+}
+
+// projectOntoPlane replaces the missing cone response so the color lies on the plane with the given normal
+func projectOntoPlane(lms, normal [3]float64, missing int) [3]float64 {
+	var sum float64
+	for i := 0; i < 3; i++ {
+		if i != missing {
+			sum += normal[i] * lms[i]
+		}
+	}
+	lms[missing] = -sum / normal[missing]
+	return lms
+}
+
+// vienotNormal returns the normal of the LMS plane a dichromat's colors are projected onto
+func vienotNormal(d Deficiency) [3]float64 {
+	anchor := anchor575
+	if d == Tritan {
+		anchor = anchor660
+	}
+	return cross(whiteLMS, anchor)
+}
+
+func vienotTransform(d Deficiency) ColorTransform {
+	missing := int(d)
+	normal := vienotNormal(d)
+
+	return lmsTransform(func(lms [3]float64) [3]float64 {
+		return projectOntoPlane(lms, normal, missing)
+	})
+}
+
+// vienotProjection returns the Viénot projection as an LMS matrix
+func vienotProjection(d Deficiency) [3][3]float64 {
+	missing := int(d)
+	normal := vienotNormal(d)
+	m := identityMatrix
+	for i := 0; i < 3; i++ {
+		m[missing][i] = -normal[i] / normal[missing]
+	}
+	m[missing][missing] = 0
+	return m
+}
+
+func brettelTransform(d Deficiency) ColorTransform {
+	missing := int(d)
+	anchors := [2][3]float64{anchor475, anchor575}
+	if d == Tritan {
+		anchors = [2][3]float64{anchor485, anchor660}
+	}
+
+	var axis [3]float64
+	axis[missing] = 1
+	// Colors are split by the plane through the neutral axis and the missing cone axis
+	separation := cross(whiteLMS, axis)
+	firstSide := dot(anchors[0], separation) >= 0
+	normals := [2][3]float64{cross(whiteLMS, anchors[0]), cross(whiteLMS, anchors[1])}
+
+	return lmsTransform(func(lms [3]float64) [3]float64 {
+		if (dot(lms, separation) >= 0) == firstSide {
+			return projectOntoPlane(lms, normals[0], missing)
+		}
+		return projectOntoPlane(lms, normals[1], missing)
+	})
+}
@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// Matrices published by Machado, Oliveira & Fernandes (2009) for severities 0.5 and 1.0
+var machadoReference = map[Deficiency]map[float64][3][3]float64{
+	Protan: {
+		0.5: {{0.458064, 0.679578, -0.137642}, {0.092785, 0.846313, 0.060902}, {-0.007494, -0.016807, 1.024301}},
+		1.0: {{0.152286, 1.052583, -0.204868}, {0.114503, 0.786281, 0.099216}, {-0.003882, -0.048116, 1.051998}},
+	},
+	Deutan: {
+		0.5: {{0.547494, 0.607765, -0.155259}, {0.181692, 0.781742, 0.036566}, {-0.010410, 0.027275, 0.983136}},
+		1.0: {{0.367322, 0.860646, -0.227968}, {0.280085, 0.672501, 0.047413}, {-0.011820, 0.042940, 0.968881}},
+	},
+	Tritan: {
+		0.5: {{1.017277, 0.027029, -0.044306}, {-0.006113, 0.958479, 0.047634}, {0.006379, 0.248708, 0.744913}},
+		1.0: {{1.255528, -0.076749, -0.178779}, {-0.078411, 0.930809, 0.147602}, {0.004733, 0.691367, 0.303900}},
+	},
+}
+
+// referenceSimulation applies a linear-light matrix with the sRGB curve written out
+// independently of the package helpers
+func referenceSimulation(m [3][3]float64, c color.NRGBA) [3]float64 {
+	decode := func(v uint8) float64 {
+		x := float64(v) / 255
+		if x <= 0.04045 {
+			return x / 12.92
+		}
+		return math.Pow((x+0.055)/1.055, 2.4)
+	}
+	encode := func(v float64) float64 {
+		v = math.Max(0, math.Min(1, v))
+		if v <= 0.0031308 {
+			return 255 * 12.92 * v
+		}
+		return 255 * (1.055*math.Pow(v, 1/2.4) - 0.055)
+	}
+	lin := [3]float64{decode(c.R), decode(c.G), decode(c.B)}
+	var out [3]float64
+	for i := range out {
+		out[i] = encode(m[i][0]*lin[0] + m[i][1]*lin[1] + m[i][2]*lin[2])
+	}
+	return out
+}
+
+var simulationSamples = []color.NRGBA{
+	{255, 0, 0, 255},
+	{0, 255, 0, 255},
+	{0, 0, 255, 255},
+	{255, 128, 0, 255},
+	{40, 160, 90, 255},
+	{128, 64, 200, 255},
+	{200, 200, 200, 255},
+}
+
+func TestMachadoMatchesPublishedTables(t *testing.T) {
+	for d, bySeverity := range machadoReference {
+		for severity, want := range bySeverity {
+			got := machadoMatrix(d, severity)
+			for i := range got {
+				for j := range got[i] {
+					if math.Abs(got[i][j]-want[i][j]) > 1e-6 {
+						t.Errorf("%v severity %v: element [%d][%d] = %v, want %v", d, severity, i, j, got[i][j], want[i][j])
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSimulateDeficiencyMatchesMachado(t *testing.T) {
+	// One 8-bit level covers the rounding of the encoded output
+	const tolerance = 1.0
+	img := image.NewNRGBA(image.Rect(0, 0, len(simulationSamples), 1))
+	for i, c := range simulationSamples {
+		img.SetNRGBA(i, 0, c)
+	}
+
+	for d, bySeverity := range machadoReference {
+		for severity, m := range bySeverity {
+			out, err := SimulateDeficiency(img, d, ModelMachado, severity)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, c := range simulationSamples {
+				want := referenceSimulation(m, c)
+				r, g, b, _ := out.At(i, 0).RGBA()
+				got := [3]float64{float64(r >> 8), float64(g >> 8), float64(b >> 8)}
+				for ch := range got {
+					if math.Abs(got[ch]-want[ch]) > tolerance {
+						t.Errorf("%v severity %v on %v: got %v, want %.2f ± %v", d, severity, c, got, want, tolerance)
+						break
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestLegacySeverityIsContinuous(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, len(simulationSamples), 1))
+	for i, c := range simulationSamples {
+		img.SetNRGBA(i, 0, c)
+	}
+	simulate := func(d Deficiency, severity float64) []byte {
+		out, err := SimulateDeficiency(img, d, ModelLegacy, severity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pixBytes(t, out)
+	}
+
+	for _, d := range []Deficiency{Protan, Deutan, Tritan} {
+		// Severity 0 is normal vision and 1 the legacy dichromat filter
+		if got := simulate(d, 0); !bytes.Equal(got, img.Pix) {
+			t.Errorf("%v at severity 0 changes the image", d)
+		}
+		if got, want := simulate(d, 1), pixBytes(t, SimulateColorBlindness(img, legacyMatrices[d])); !bytes.Equal(got, want) {
+			t.Errorf("%v at severity 1 differs from the legacy filter", d)
+		}
+
+		// No step of 0.05 in severity moves a channel by more than the 8-bit
+		// rounding plus 5% of the full range
+		previous := simulate(d, 0)
+		for step := 1; step <= 20; step++ {
+			current := simulate(d, float64(step)/20)
+			for i := range current {
+				if diff := math.Abs(float64(current[i]) - float64(previous[i])); diff > 1+0.05*255 {
+					t.Errorf("%v severity %v: byte %d jumps by %v", d, float64(step)/20, i, diff)
+				}
+			}
+			previous = current
+		}
+	}
+}
+
+func TestDichromatModelsKeepNeutrals(t *testing.T) {
+	// Dichromats see the achromatic axis unchanged; allow half an 8-bit level
+	const tolerance = 0.5 / 255
+	for _, model := range []SimulationModel{ModelBrettel, ModelVienot, ModelMachado} {
+		for _, d := range []Deficiency{Protan, Deutan, Tritan} {
+			transform, err := NewSimulation(d, model, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range []float64{0, 0.2, 0.5, 0.8, 1} {
+				r, g, b := transform(v, v, v)
+				if math.Abs(r-v) > tolerance || math.Abs(g-v) > tolerance || math.Abs(b-v) > tolerance {
+					t.Errorf("%s %v maps gray %v to (%v, %v, %v)", model, d, v, r, g, b)
+				}
+			}
+		}
+	}
+}
+
+func TestDichromatModelsCollapseConfusionLines(t *testing.T) {
+	// Colors that differ only in the missing cone's response must look the same
+	const tolerance = 1e-6
+	base := mulVector(lmsFromLinearRGB, [3]float64{0.2, 0.3, 0.25})
+	for _, model := range []SimulationModel{ModelBrettel, ModelVienot} {
+		for _, d := range []Deficiency{Protan, Deutan, Tritan} {
+			transform, err := NewSimulation(d, model, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var outputs [][3]float64
+			for _, shift := range []float64{-0.05, 0, 0.05} {
+				lms := base
+				lms[d] *= 1 + shift
+				rgb := mulVector(linearRGBFromLMS, lms)
+				r, g, b := transform(LinearToSRGB(rgb[0]), LinearToSRGB(rgb[1]), LinearToSRGB(rgb[2]))
+				outputs = append(outputs, [3]float64{r, g, b})
+			}
+			for _, out := range outputs[1:] {
+				for ch := range out {
+					if math.Abs(out[ch]-outputs[0][ch]) > tolerance {
+						t.Errorf("%s %v: confusion line maps to %v and %v", model, d, outputs[0], out)
+						break
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// DefaultDaltonizeStrength is how much of the lost contrast is shifted back when no strength is given
+const DefaultDaltonizeStrength = 0.6
+
+// Error redistribution matrices: the contrast a viewer cannot see is moved
+// into the channels they still perceive (Fidaner, Lin & Ozguven 2005)
+var daltonizeErrorMatrices = map[Deficiency][3][3]float64{
+	Protan: {{0, 0, 0}, {0.7, 1, 0}, {0.7, 0, 1}},
+	Deutan: {{1, 0.7, 0}, {0, 0, 0}, {0, 0.7, 1}},
+	Tritan: {{1, 0, 0.7}, {0, 1, 0.7}, {0, 0, 0}},
+}
+
+// NewDaltonization returns the color transform correcting colors for the given deficiency.
+// Strength runs from 0 (no correction) to 1 (full redistribution of the error).
+func NewDaltonization(d Deficiency, strength float64) (ColorTransform, error) {
+	if strength < 0 || strength > 1 || math.IsNaN(strength) {
+		return nil, fmt.Errorf("strength %v out of range [0,1]", strength)
+	}
+	if _, ok := daltonizeErrorMatrices[d]; !ok {
+		return nil, fmt.Errorf("unknown deficiency %d", int(d))
+	}
+
+	simulation := machadoMatrices[d][10]
+	redistribution := daltonizeErrorMatrices[d]
+
+	return func(r, g, b float64) (float64, float64, float64) {
+		orig := [3]float64{SRGBToLinear(r), SRGBToLinear(g), SRGBToLinear(b)}
+		sim := mulVector(simulation, orig)
+		shift := mulVector(redistribution, [3]float64{orig[0] - sim[0], orig[1] - sim[1], orig[2] - sim[2]})
+
+		return LinearToSRGB(clampUnit(orig[0] + shift[0]*strength)),
+			LinearToSRGB(clampUnit(orig[1] + shift[1]*strength)),
+			LinearToSRGB(clampUnit(orig[2] + shift[2]*strength))
+	}, nil
+}
+
+// DaltonizeDeficiency recolors the image so a viewer with the given deficiency can tell more colors apart
+func DaltonizeDeficiency(img image.Image, d Deficiency, strength float64) (image.Image, error) {
+	transform, err := NewDaltonization(d, strength)
+	if err != nil {
+		return nil, err
+	}
+	return ApplyColorTransform(img, transform), nil
+}
@@ -0,0 +1,359 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// EdgeOperator selects the edge detection algorithm
+type EdgeOperator string
+
+const (
+	OperatorSobel   EdgeOperator = "sobel"   // 3×3 gradient magnitude
+	OperatorScharr  EdgeOperator = "scharr"  // 3×3 gradient with better rotational symmetry
+	OperatorPrewitt EdgeOperator = "prewitt" // 3×3 gradient with uniform smoothing
+	OperatorLoG     EdgeOperator = "log"     // zero crossings of the Laplacian of Gaussian
+	OperatorCanny   EdgeOperator = "canny"   // thin edges with hysteresis thresholds
+)
+
+// EdgeOperators lists the supported edge operators
+var EdgeOperators = []EdgeOperator{OperatorSobel, OperatorScharr, OperatorPrewitt, OperatorLoG, OperatorCanny}
+
+// ParseEdgeOperator validates an edge operator name, defaulting to sobel
+func ParseEdgeOperator(name string) (EdgeOperator, error) {
+	if name == "" {
+		return OperatorSobel, nil
+	}
+	for _, op := range EdgeOperators {
+		if string(op) == name {
+			return op, nil
+		}
+	}
+	return "", fmt.Errorf("unknown edge operator %q", name)
+}
+
+// Smoothing kernels of the gradient operators, scaled to the Sobel weight of 4 so
+// magnitudes are comparable between operators
+var gradientSmoothing = map[EdgeOperator][]float64{
+	OperatorSobel:   {1, 2, 1},
+	OperatorScharr:  {0.75, 2.5, 0.75},
+	OperatorPrewitt: {4.0 / 3, 4.0 / 3, 4.0 / 3},
+}
+
+// Central difference shared by the gradient operators
+var derivativeKernel = []float64{-1, 0, 1}
+
+// EdgeOptions configures DetectEdges
+type EdgeOptions struct {
+	Operator EdgeOperator
+	// Sigma is the Gaussian smoothing in pixels applied before the LoG and Canny operators
+	Sigma float64
+	// Low and High are the hysteresis thresholds of Canny as fractions of the strongest
+	// gradient. LoG uses Low as the smallest zero-crossing slope kept.
+	Low, High float64
+	// Edge selects how pixels beyond the border are sampled
+	Edge EdgeMode
+}
+
+// DefaultEdgeOptions returns Sobel edges with the usual Canny settings
+func DefaultEdgeOptions() EdgeOptions {
+	return EdgeOptions{Operator: OperatorSobel, Sigma: 1.4, Low: 0.1, High: 0.3, Edge: EdgeClamp}
+}
+
+// plane is a single-channel float image with its origin at (0, 0)
+type plane struct {
+	w, h int
+	pix  []float32
+}
+
+func newPlane(w, h int) *plane {
+	return &plane{w: w, h: h, pix: make([]float32, w*h)}
+}
+
+// lumaPlane returns the gamma-encoded luminance of the image on a 0..255 scale,
+// with transparent areas composited over black
+func lumaPlane(img image.Image) *plane {
+	lin := ToLinear(img)
+	p := newPlane(lin.Rect.Dx(), lin.Rect.Dy())
+	parallelRows(0, p.h, func(y0, y1 int) {
+		for i := y0 * p.w; i < y1*p.w; i++ {
+			y := 0.2126*lin.Pix[4*i] + 0.7152*lin.Pix[4*i+1] + 0.0722*lin.Pix[4*i+2]
+			p.pix[i] = float32(EncodeSRGB8(y))
+		}
+	})
+	return p
+}
+
+// convolve applies a horizontal then a vertical 1D kernel to the plane
+func (p *plane) convolve(vertical, horizontal []float64, edge EdgeMode) *plane {
+	tmp := newPlane(p.w, p.h)
+	xs := edgeTable(p.w, len(horizontal)/2, edge)
+	parallelRows(0, p.h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := p.pix[y*p.w : (y+1)*p.w]
+			for x := 0; x < p.w; x++ {
+				var acc float32
+				for kx, weight := range horizontal {
+					if sx := xs[x+kx]; sx >= 0 {
+						acc += row[sx] * float32(weight)
+					}
+				}
+				tmp.pix[y*p.w+x] = acc
+			}
+		}
+	})
+
+	out := newPlane(p.w, p.h)
+	ys := edgeTable(p.h, len(vertical)/2, edge)
+	parallelRows(0, p.h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			dst := out.pix[y*p.w : (y+1)*p.w]
+			for ky, weight := range vertical {
+				sy := ys[y+ky]
+				if sy < 0 {
+					continue
+				}
+				src := tmp.pix[sy*p.w : (sy+1)*p.w]
+				for x := range dst {
+					dst[x] += src[x] * float32(weight)
+				}
+			}
+		}
+	})
+	return out
+}
+
+// gradients returns the horizontal and vertical derivatives of the plane
+func (p *plane) gradients(smoothing []float64, edge EdgeMode) (gx, gy *plane) {
+	return p.convolve(smoothing, derivativeKernel, edge), p.convolve(derivativeKernel, smoothing, edge)
+}
+
+// at returns the value at (x, y), or 0 outside the plane
+func (p *plane) at(x, y int) float32 {
+	if x < 0 || y < 0 || x >= p.w || y >= p.h {
+		return 0
+	}
+	return p.pix[y*p.w+x]
+}
+
+// smooth blurs the plane with a Gaussian of the given standard deviation
+func (p *plane) smooth(sigma float64, edge EdgeMode) (*plane, error) {
+	if sigma <= 0 || math.IsNaN(sigma) {
+		return nil, fmt.Errorf("edge sigma must be positive, got %v", sigma)
+	}
+	k := GaussianKernel1D(sigma, 0)
+	if len(k) > MaxKernelSize {
+		return nil, fmt.Errorf("edge sigma %v needs a kernel wider than %d pixels", sigma, MaxKernelSize)
+	}
+	return p.convolve(k, k, edge), nil
+}
+
+// DetectEdges returns an edge map of the image. The gradient operators give the
+// gradient magnitude clipped to 255; LoG and Canny give 255 on edges and 0 elsewhere.
+func DetectEdges(img image.Image, opts EdgeOptions) (*image.Gray, error) {
+	luma := lumaPlane(img)
+
+	var edges *plane
+	switch opts.Operator {
+	case OperatorSobel, OperatorScharr, OperatorPrewitt:
+		gx, gy := luma.gradients(gradientSmoothing[opts.Operator], opts.Edge)
+		edges = magnitude(gx, gy)
+	case OperatorLoG:
+		smoothed, err := luma.smooth(opts.Sigma, opts.Edge)
+		if err != nil {
+			return nil, err
+		}
+		edges = zeroCrossings(laplacian(smoothed, opts.Edge), opts.Low)
+	case OperatorCanny:
+		if opts.Low < 0 || opts.High > 1 || opts.Low > opts.High {
+			return nil, fmt.Errorf("canny thresholds must satisfy 0 <= low <= high <= 1, got %v and %v", opts.Low, opts.High)
+		}
+		smoothed, err := luma.smooth(opts.Sigma, opts.Edge)
+		if err != nil {
+			return nil, err
+		}
+		gx, gy := smoothed.gradients(gradientSmoothing[OperatorSobel], opts.Edge)
+		edges = hysteresis(suppressNonMaxima(gx, gy), opts.Low, opts.High)
+	default:
+		return nil, fmt.Errorf("unknown edge operator %q", opts.Operator)
+	}
+
+	out := image.NewGray(img.Bounds())
+	for i, v := range edges.pix {
+		out.Pix[i] = uint8(clamp(float64(v)))
+	}
+	return out, nil
+}
+
+// magnitude returns the gradient magnitude at each pixel
+func magnitude(gx, gy *plane) *plane {
+	out := newPlane(gx.w, gx.h)
+	for i := range out.pix {
+		out.pix[i] = float32(math.Hypot(float64(gx.pix[i]), float64(gy.pix[i])))
+	}
+	return out
+}
+
+// laplacian applies the 4-neighbor Laplacian to the plane
+func laplacian(p *plane, edge EdgeMode) *plane {
+	xs := edgeTable(p.w, 1, edge)
+	ys := edgeTable(p.h, 1, edge)
+	sample := func(x, y int) float32 {
+		if x < 0 || y < 0 {
+			return 0
+		}
+		return p.pix[y*p.w+x]
+	}
+
+	out := newPlane(p.w, p.h)
+	parallelRows(0, p.h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < p.w; x++ {
+				out.pix[y*p.w+x] = sample(xs[x], y) + sample(xs[x+2], y) + sample(x, ys[y]) + sample(x, ys[y+2]) - 4*p.pix[y*p.w+x]
+			}
+		}
+	})
+	return out
+}
+
+// zeroCrossings marks pixels where the Laplacian changes sign towards a right or
+// lower neighbor with a slope of at least threshold times the steepest crossing
+func zeroCrossings(lap *plane, threshold float64) *plane {
+	slopes := newPlane(lap.w, lap.h)
+	var steepest float32
+	for y := 0; y < lap.h; y++ {
+		for x := 0; x < lap.w; x++ {
+			v := lap.pix[y*lap.w+x]
+			for _, n := range [2][2]int{{x + 1, y}, {x, y + 1}} {
+				if n[0] >= lap.w || n[1] >= lap.h {
+					continue
+				}
+				u := lap.pix[n[1]*lap.w+n[0]]
+				if (v < 0) != (u < 0) {
+					slope := float32(math.Abs(float64(v - u)))
+					slopes.pix[y*lap.w+x] = max(slopes.pix[y*lap.w+x], slope)
+					steepest = max(steepest, slope)
+				}
+			}
+		}
+	}
+
+	limit := float32(threshold) * steepest
+	for i, s := range slopes.pix {
+		if s > 0 && s >= limit {
+			slopes.pix[i] = 255
+		} else {
+			slopes.pix[i] = 0
+		}
+	}
+	return slopes
+}
+
+// suppressNonMaxima keeps the gradient magnitude only where it peaks across the edge
+func suppressNonMaxima(gx, gy *plane) *plane {
+	mag := magnitude(gx, gy)
+	out := newPlane(mag.w, mag.h)
+	parallelRows(0, mag.h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < mag.w; x++ {
+				i := y*mag.w + x
+				m := mag.pix[i]
+				if m == 0 {
+					continue
+				}
+
+				// Quantize the gradient direction to one of four neighbor pairs
+				angle := math.Atan2(float64(gy.pix[i]), float64(gx.pix[i])) * 180 / math.Pi
+				if angle < 0 {
+					angle += 180
+				}
+				var dx, dy int
+				switch {
+				case angle < 22.5 || angle >= 157.5:
+					dx, dy = 1, 0
+				case angle < 67.5:
+					dx, dy = 1, 1
+				case angle < 112.5:
+					dx, dy = 0, 1
+				default:
+					dx, dy = -1, 1
+				}
+				if m >= mag.at(x+dx, y+dy) && m >= mag.at(x-dx, y-dy) {
+					out.pix[i] = m
+				}
+			}
+		}
+	})
+	return out
+}
+
+// hysteresis keeps suppressed gradients above high, and those above low that are
+// 8-connected to one, with both thresholds relative to the strongest gradient
+func hysteresis(nms *plane, low, high float64) *plane {
+	var strongest float32
+	for _, v := range nms.pix {
+		strongest = max(strongest, v)
+	}
+	out := newPlane(nms.w, nms.h)
+	if strongest == 0 {
+		return out
+	}
+	lo, hi := float32(low)*strongest, float32(high)*strongest
+
+	var stack []int
+	for i, v := range nms.pix {
+		if v > 0 && v >= hi {
+			out.pix[i] = 255
+			stack = append(stack, i)
+		}
+	}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		x, y := i%nms.w, i/nms.w
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				nx, ny := x+dx, y+dy
+				if nx < 0 || ny < 0 || nx >= nms.w || ny >= nms.h {
+					continue
+				}
+				j := ny*nms.w + nx
+				if out.pix[j] == 0 && nms.pix[j] > 0 && nms.pix[j] >= lo {
+					out.pix[j] = 255
+					stack = append(stack, j)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// OverlayEdges paints the edge map over the image in the given sRGB color, using
+// the edge strength as opacity
+func OverlayEdges(img image.Image, edges *image.Gray, c [3]float64) image.Image {
+	bounds := img.Bounds()
+	out := image.NewNRGBA(bounds)
+	draw.Draw(out, bounds, img, bounds.Min, draw.Src)
+	line := [3]float64{c[0] * 255, c[1] * 255, c[2] * 255}
+
+	parallelRows(bounds.Min.Y, bounds.Max.Y, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			pix := out.Pix[out.PixOffset(bounds.Min.X, y):]
+			strength := edges.Pix[edges.PixOffset(bounds.Min.X, y):]
+			for x := 0; x < bounds.Dx(); x++ {
+				t := float64(strength[x]) / 255
+				if t == 0 {
+					continue
+				}
+				p := pix[4*x : 4*x+4]
+				for k := 0; k < 3; k++ {
+					p[k] = uint8(float64(p[k])*(1-t) + line[k]*t + 0.5)
+				}
+				p[3] = max(p[3], strength[x])
+			}
+		}
+	})
+	return out
+}
@@ -0,0 +1,237 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// ExportFormat is a file type a simulation can be exported as
+type ExportFormat string
+
+const (
+	ExportCube ExportFormat = "cube" // Adobe/Resolve 3D LUT
+	ExportICC  ExportFormat = "icc"  // ICC abstract profile for soft proofing
+	ExportSVG  ExportFormat = "svg"  // SVG filter with an feColorMatrix
+	ExportCSS  ExportFormat = "css"  // CSS class applying the SVG filter
+)
+
+// ExportFormats lists the supported export formats
+var ExportFormats = []ExportFormat{ExportCube, ExportICC, ExportSVG, ExportCSS}
+
+// ParseExportFormat validates an export format name
+func ParseExportFormat(name string) (ExportFormat, error) {
+	for _, f := range ExportFormats {
+		if string(f) == name {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown export format %q", name)
+}
+
+// ContentType returns the MIME type of the exported file
+func (f ExportFormat) ContentType() string {
+	switch f {
+	case ExportICC:
+		return "application/vnd.iccprofile"
+	case ExportSVG:
+		return "image/svg+xml"
+	case ExportCSS:
+		return "text/css; charset=utf-8"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// ColorMatrix is a simulation expressed as a single 3×3 matrix, applied either to
+// linear-light or to gamma-encoded RGB
+type ColorMatrix struct {
+	Matrix [3][3]float64
+	Linear bool
+}
+
+// Export selects the simulation to export
+type Export struct {
+	// Vision is a vision type name such as "deuteranopia", "tritanomaly" or "achromatopsia"
+	Vision string
+	Model  SimulationModel
+	// Severity overrides the default of 1 for dichromacy and DefaultAnomalySeverity for
+	// anomalies. Without it the legacy model exports its fixed anomaly matrices.
+	Severity *float64
+	// LUTSize is the grid size used by the .cube and ICC exports
+	LUTSize int
+}
+
+// Name returns a file and element name for the export, such as "deuteranomaly-machado-60"
+func (e Export) Name() string {
+	switch {
+	case e.Vision == "achromatopsia", e.Vision == "monochromacy":
+		return e.Vision
+	case e.legacyAnomaly():
+		return e.Vision + "-legacy"
+	}
+	return fmt.Sprintf("%s-%s-%d", e.Vision, e.Model, int(e.severity()*100+0.5))
+}
+
+// severity returns the severity to simulate, falling back to the vision type's default
+func (e Export) severity() float64 {
+	if e.Severity != nil {
+		return *e.Severity
+	}
+	if strings.HasSuffix(e.Vision, "anomaly") {
+		return DefaultAnomalySeverity
+	}
+	return 1
+}
+
+// legacyAnomaly reports whether the export is one of the fixed legacy anomaly
+// matrices, which the anomaly operations use when no severity is given
+func (e Export) legacyAnomaly() bool {
+	return e.Model == ModelLegacy && e.Severity == nil && strings.HasSuffix(e.Vision, "anomaly")
+}
+
+// fixedMatrix returns the gamma-space matrix the operations apply for vision types
+// that do not depend on the model or a severity
+func (e Export) fixedMatrix() ([3][3]float64, bool) {
+	switch e.Vision {
+	case "achromatopsia":
+		return AchromatopsiaMatrix, true
+	case "monochromacy":
+		return MonochromacyMatrix, true
+	case "protanomaly":
+		return ProtanomalyMatrix, e.legacyAnomaly()
+	case "deuteranomaly":
+		return DeuteranomalyMatrix, e.legacyAnomaly()
+	case "tritanomaly":
+		return TritanomalyMatrix, e.legacyAnomaly()
+	}
+	return [3][3]float64{}, false
+}
+
+// deficiency resolves the vision type. Only the names in VisionTypes are accepted,
+// since the name also ends up in file names and SVG ids.
+func (e Export) deficiency() (Deficiency, error) {
+	if !slices.Contains(VisionTypes, e.Vision) {
+		return 0, fmt.Errorf("unknown vision type %q", e.Vision)
+	}
+	return ParseDeficiency(e.Vision)
+}
+
+// Transform returns the exported simulation as a color transform
+func (e Export) Transform() (ColorTransform, error) {
+	if m, ok := e.fixedMatrix(); ok {
+		return matrixTransform(m), nil
+	}
+	d, err := e.deficiency()
+	if err != nil {
+		return nil, err
+	}
+	return NewSimulation(d, e.Model, e.severity())
+}
+
+// Matrix returns the exported simulation as a single matrix. The Brettel model
+// switches between two projections and Viénot anomalies blend with a clipped
+// color, so neither has an exact matrix form.
+func (e Export) Matrix() (ColorMatrix, error) {
+	if m, ok := e.fixedMatrix(); ok {
+		return ColorMatrix{Matrix: m}, nil
+	}
+	d, err := e.deficiency()
+	if err != nil {
+		return ColorMatrix{}, err
+	}
+	severity := e.severity()
+	if severity < 0 || severity > 1 || math.IsNaN(severity) {
+		return ColorMatrix{}, fmt.Errorf("severity %v out of range [0,1]", severity)
+	}
+
+	switch e.Model {
+	case ModelLegacy:
+		return ColorMatrix{Matrix: legacyMatrix(d, severity)}, nil
+	case ModelMachado:
+		return ColorMatrix{Matrix: machadoMatrix(d, severity), Linear: true}, nil
+	case ModelVienot:
+		if severity < 1 {
+			// withSeverity blends with the clipped dichromat color, which no single matrix reproduces
+			return ColorMatrix{}, fmt.Errorf("vienot anomalies have no exact color matrix; export them as a LUT or ICC profile, or use machado")
+		}
+		m := mulMatrix(linearRGBFromLMS, mulMatrix(vienotProjection(d), lmsFromLinearRGB))
+		return ColorMatrix{Matrix: m, Linear: true}, nil
+	case ModelBrettel:
+		return ColorMatrix{}, fmt.Errorf("the brettel model has no single color matrix; export it as a LUT or ICC profile, or use vienot or machado")
+	}
+	return ColorMatrix{}, fmt.Errorf("unknown simulation model %q", e.Model)
+}
+
+// Write encodes the simulation in the given format
+func (e Export) Write(w io.Writer, format ExportFormat) error {
+	switch format {
+	case ExportCube:
+		transform, err := e.Transform()
+		if err != nil {
+			return err
+		}
+		lut, err := BakeLUT(transform, e.lutSize())
+		if err != nil {
+			return err
+		}
+		lut.Title = e.Name()
+		return lut.WriteCube(w)
+	case ExportICC:
+		transform, err := e.Transform()
+		if err != nil {
+			return err
+		}
+		return WriteAbstractProfile(w, e.Name()+" simulation", transform, e.lutSize())
+	case ExportSVG:
+		svg, err := e.svgFilter()
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, svg)
+		return err
+	case ExportCSS:
+		svg, err := e.svgFilter()
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "/* %s simulation. Add the class to any element, or reference %s.svg#%s */\n.%s {\n  filter: url(\"data:image/svg+xml,%s#%s\");\n}\n",
+			e.Name(), e.Name(), e.Name(), e.Name(), url.PathEscape(svg), e.Name())
+		return err
+	}
+	return fmt.Errorf("unknown export format %q", format)
+}
+
+// lutSize returns the grid size for table-based exports
+func (e Export) lutSize() int {
+	if e.LUTSize == 0 {
+		return DefaultLUTSize
+	}
+	return e.LUTSize
+}
+
+// svgFilter returns an SVG document holding the simulation as an feColorMatrix filter
+func (e Export) svgFilter() (string, error) {
+	cm, err := e.Matrix()
+	if err != nil {
+		return "", err
+	}
+
+	// SVG filters work in linear light unless told otherwise
+	space := "sRGB"
+	if cm.Linear {
+		space = "linearRGB"
+	}
+	var values []string
+	for _, row := range cm.Matrix {
+		values = append(values, fmt.Sprintf("%.6f %.6f %.6f 0 0", row[0], row[1], row[2]))
+	}
+	values = append(values, "0 0 0 1 0")
+
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="0" height="0">`+
+		`<filter id="%s" color-interpolation-filters="%s">`+
+		`<feColorMatrix type="matrix" values="%s"/>`+
+		`</filter></svg>`, e.Name(), space, strings.Join(values, " ")), nil
+}
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestExportFixedMatrices(t *testing.T) {
+	tests := []struct {
+		vision string
+		want   [3][3]float64
+	}{
+		{"protanomaly", ProtanomalyMatrix},
+		{"deuteranomaly", DeuteranomalyMatrix},
+		{"tritanomaly", TritanomalyMatrix},
+		{"achromatopsia", AchromatopsiaMatrix},
+		{"monochromacy", MonochromacyMatrix},
+	}
+	for _, tt := range tests {
+		e := Export{Vision: tt.vision, Model: ModelLegacy}
+		cm, err := e.Matrix()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cm.Matrix != tt.want || cm.Linear {
+			t.Errorf("%s: exported %+v, want the gamma-space operation matrix %v", tt.vision, cm, tt.want)
+		}
+
+		// The LUT exports must apply the same matrix
+		transform, err := e.Transform()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, g, b := transform(0.8, 0.4, 0.2)
+		want := [3]float64{}
+		for i := range want {
+			want[i] = tt.want[i][0]*0.8 + tt.want[i][1]*0.4 + tt.want[i][2]*0.2
+		}
+		if math.Abs(r-want[0]) > 1e-9 || math.Abs(g-want[1]) > 1e-9 || math.Abs(b-want[2]) > 1e-9 {
+			t.Errorf("%s: transform gives (%v, %v, %v), want %v", tt.vision, r, g, b, want)
+		}
+	}
+}
+
+func TestExportLegacySeverityMatchesSimulation(t *testing.T) {
+	visions := map[Deficiency]string{Protan: "protanomaly", Deutan: "deuteranomaly", Tritan: "tritanomaly"}
+	for d, vision := range visions {
+		for _, severity := range []float64{0, 0.25, 0.6, 0.9, 1} {
+			e := Export{Vision: vision, Model: ModelLegacy, Severity: &severity}
+			cm, err := e.Matrix()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cm.Linear {
+				t.Errorf("%s severity %v: legacy matrix is linear-light, want gamma-encoded", vision, severity)
+			}
+			// The legacy dichromat matrix faded into the identity
+			for i := range cm.Matrix {
+				for j := range cm.Matrix[i] {
+					want := identityMatrix[i][j] + (legacyMatrices[d][i][j]-identityMatrix[i][j])*severity
+					if math.Abs(cm.Matrix[i][j]-want) > 1e-12 {
+						t.Errorf("%s severity %v: element [%d][%d] = %v, want %v", vision, severity, i, j, cm.Matrix[i][j], want)
+					}
+				}
+			}
+			if severity == 1 && cm.Matrix != legacyMatrices[d] {
+				t.Errorf("%s at full severity exported %v, want the legacy dichromat matrix", vision, cm.Matrix)
+			}
+		}
+	}
+}
+
+func TestExportZeroSeverity(t *testing.T) {
+	zero := 0.0
+	for _, model := range []SimulationModel{ModelLegacy, ModelMachado, ModelVienot} {
+		e := Export{Vision: "deuteranomaly", Model: model, Severity: &zero}
+		if name := e.Name(); !strings.HasSuffix(name, "-0") {
+			t.Errorf("%s: name %q does not record severity 0", model, name)
+		}
+		transform, err := e.Transform()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r, g, b := transform(0.9, 0.3, 0.1); math.Abs(r-0.9) > 1e-6 || math.Abs(g-0.3) > 1e-6 || math.Abs(b-0.1) > 1e-6 {
+			t.Errorf("%s: severity 0 maps (0.9, 0.3, 0.1) to (%v, %v, %v), want it unchanged", model, r, g, b)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// Largest width or height produced by a resize, warp or crop
+const MaxOutputDimension = 16384
+
+// Largest number of pixels produced by a resize, warp or crop. The linear-light
+// working copy of an image this size takes 1 GiB.
+const MaxOutputPixels = 1 << 26
+
+// Resampling filters accepted by Resize, by name
+var resampleFilters = map[string]imaging.ResampleFilter{
+	"nearest":     imaging.NearestNeighbor,
+	"box":         imaging.Box,
+	"linear":      imaging.Linear,
+	"hermite":     imaging.Hermite,
+	"mitchell":    imaging.MitchellNetravali,
+	"catmull_rom": imaging.CatmullRom,
+	"bspline":     imaging.BSpline,
+	"gaussian":    imaging.Gaussian,
+	"lanczos":     imaging.Lanczos,
+}
+
+// ResampleFilterNames lists the resampling filters in alphabetical order
+func ResampleFilterNames() []string {
+	names := make([]string, 0, len(resampleFilters))
+	for name := range resampleFilters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseResampleFilter looks up a resampling filter by name
+func ParseResampleFilter(name string) (imaging.ResampleFilter, error) {
+	filter, ok := resampleFilters[name]
+	if !ok {
+		return imaging.ResampleFilter{}, fmt.Errorf("unknown resampling filter %q", name)
+	}
+	return filter, nil
+}
+
+// FlipHorizontal mirrors the image left to right
+func FlipHorizontal(img image.Image) image.Image {
+	if !IsHighBitDepth(img) {
+		return imaging.FlipH(img)
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	return remapPixels(img, image.Pt(w, h), func(x, y int) (int, int) { return w - 1 - x, y })
+}
+
+// FlipVertical mirrors the image top to bottom
+func FlipVertical(img image.Image) image.Image {
+	if !IsHighBitDepth(img) {
+		return imaging.FlipV(img)
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	return remapPixels(img, image.Pt(w, h), func(x, y int) (int, int) { return x, h - 1 - y })
+}
+
+// Transpose mirrors the image about its top-left to bottom-right diagonal
+func Transpose(img image.Image) image.Image {
+	if !IsHighBitDepth(img) {
+		return imaging.Transpose(img)
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	return remapPixels(img, image.Pt(h, w), func(x, y int) (int, int) { return y, x })
+}
+
+// QuarterTurn rotates the image counter-clockwise by the given number of right angles
+func QuarterTurn(img image.Image, turns int) image.Image {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	deep := IsHighBitDepth(img)
+	switch (turns%4 + 4) % 4 {
+	case 1:
+		if !deep {
+			return imaging.Rotate90(img)
+		}
+		return remapPixels(img, image.Pt(h, w), func(x, y int) (int, int) { return w - 1 - y, x })
+	case 2:
+		if !deep {
+			return imaging.Rotate180(img)
+		}
+		return remapPixels(img, image.Pt(w, h), func(x, y int) (int, int) { return w - 1 - x, h - 1 - y })
+	case 3:
+		if !deep {
+			return imaging.Rotate270(img)
+		}
+		return remapPixels(img, image.Pt(h, w), func(x, y int) (int, int) { return y, h - 1 - x })
+	}
+	return img
+}
+
+// checkOutputSize reports an error when a width×height output is larger than
+// MaxOutputDimension on a side or MaxOutputPixels in all
+func checkOutputSize(what string, width, height int) error {
+	if width > MaxOutputDimension || height > MaxOutputDimension {
+		return fmt.Errorf("%s of %dx%d exceeds the maximum of %d pixels per side", what, width, height, MaxOutputDimension)
+	}
+	if int64(width)*int64(height) > MaxOutputPixels {
+		return fmt.Errorf("%s of %dx%d exceeds the maximum of %d pixels", what, width, height, MaxOutputPixels)
+	}
+	return nil
+}
+
+// Crop cuts out a rectangle given relative to the top-left corner of the image.
+// The rectangle is clipped to the image.
+func Crop(img image.Image, rect image.Rectangle) (image.Image, error) {
+	bounds := img.Bounds()
+	rect = rect.Add(bounds.Min).Intersect(bounds)
+	if rect.Empty() {
+		return nil, fmt.Errorf("crop rectangle lies outside the %dx%d image", bounds.Dx(), bounds.Dy())
+	}
+	if err := checkOutputSize("cropped image", rect.Dx(), rect.Dy()); err != nil {
+		return nil, err
+	}
+	if !IsHighBitDepth(img) {
+		return imaging.Crop(img, rect), nil
+	}
+	offset := rect.Min.Sub(bounds.Min)
+	return remapPixels(img, rect.Size(), func(x, y int) (int, int) { return x + offset.X, y + offset.Y }), nil
+}
+
+// remapPixels builds an image of the given size whose pixel (x, y) is the source
+// pixel at(x, y), both relative to the top-left corner, keeping 16 bits per channel
+func remapPixels(img image.Image, size image.Point, at func(x, y int) (int, int)) image.Image {
+	bounds := img.Bounds()
+	out := image.NewNRGBA64(image.Rectangle{Max: size})
+	parallelRows(0, size.Y, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < size.X; x++ {
+				sx, sy := at(x, y)
+				out.SetNRGBA64(x, y, color.NRGBA64Model.Convert(img.At(bounds.Min.X+sx, bounds.Min.Y+sy)).(color.NRGBA64))
+			}
+		}
+	})
+	return out
+}
+
+// Resize scales the image to width×height. A zero width or height keeps the aspect ratio.
+func Resize(img image.Image, width, height int, filter imaging.ResampleFilter) (image.Image, error) {
+	if width < 0 || height < 0 || (width == 0 && height == 0) {
+		return nil, fmt.Errorf("resize needs a positive width or height, got %dx%d", width, height)
+	}
+	if width > MaxOutputDimension || height > MaxOutputDimension {
+		return nil, fmt.Errorf("resize to %dx%d exceeds the maximum of %d pixels per side", width, height, MaxOutputDimension)
+	}
+	bounds := img.Bounds()
+	if width == 0 {
+		width = max(1, int(math.Round(float64(bounds.Dx())*float64(height)/float64(bounds.Dy()))))
+	}
+	if height == 0 {
+		height = max(1, int(math.Round(float64(bounds.Dy())*float64(width)/float64(bounds.Dx()))))
+	}
+	if err := checkOutputSize("resized image", width, height); err != nil {
+		return nil, err
+	}
+	if !IsHighBitDepth(img) {
+		return imaging.Resize(img, width, height, filter), nil
+	}
+
+	// imaging works at 8 bits, so 16-bit images are resampled here in linear light
+	src := ToLinear(img)
+	tmp := NewLinearImage(image.Rect(0, 0, width, bounds.Dy()))
+	xw := resampleWeights(bounds.Dx(), width, filter)
+	parallelRows(0, bounds.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x, taps := range xw {
+				dst := tmp.Pix[y*tmp.Stride+4*x : y*tmp.Stride+4*x+4]
+				for _, t := range taps {
+					p := src.Pix[y*src.Stride+4*t.index:]
+					for c := 0; c < 4; c++ {
+						dst[c] += p[c] * t.weight
+					}
+				}
+			}
+		}
+	})
+
+	out := NewLinearImage(image.Rect(0, 0, width, height))
+	out.HighBitDepth = true
+	yw := resampleWeights(bounds.Dy(), height, filter)
+	parallelRows(0, height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			dst := out.Pix[y*out.Stride : (y+1)*out.Stride]
+			for _, t := range yw[y] {
+				row := tmp.Pix[t.index*tmp.Stride : (t.index+1)*tmp.Stride]
+				for i := range dst {
+					dst[i] += row[i] * t.weight
+				}
+			}
+		}
+	})
+	return out.Image(), nil
+}
+
+// resampleTap is one source pixel contributing to a resized pixel
+type resampleTap struct {
+	index  int
+	weight float32
+}
+
+// resampleWeights returns, for each of the dst pixels along an axis, the source
+// pixels and normalized filter weights that make it up
+func resampleWeights(src, dst int, filter imaging.ResampleFilter) [][]resampleTap {
+	scale := float64(src) / float64(dst)
+	widen := math.Max(scale, 1) // stretch the filter when shrinking to avoid aliasing
+	taps := make([][]resampleTap, dst)
+	for i := range taps {
+		centre := (float64(i)+0.5)*scale - 0.5
+		if filter.Support == 0 {
+			taps[i] = []resampleTap{{min(max(int(math.Round(centre)), 0), src-1), 1}}
+			continue
+		}
+		support := filter.Support * widen
+		var sum float64
+		var weights []float64
+		var indexes []int
+		for j := int(math.Ceil(centre - support)); j <= int(math.Floor(centre+support)); j++ {
+			w := filter.Kernel((float64(j) - centre) / widen)
+			if w == 0 {
+				continue
+			}
+			weights = append(weights, w)
+			indexes = append(indexes, min(max(j, 0), src-1))
+			sum += w
+		}
+		if sum == 0 {
+			taps[i] = []resampleTap{{min(max(int(math.Round(centre)), 0), src-1), 1}}
+			continue
+		}
+		for k, w := range weights {
+			taps[i] = append(taps[i], resampleTap{indexes[k], float32(w / sum)})
+		}
+	}
+	return taps
+}
+
+// WarpOptions configures AffineTransform and PerspectiveTransform
+type WarpOptions struct {
+	// Fit grows or shrinks the canvas to the transformed image instead of keeping the input size
+	Fit bool
+	// Fill is painted under the areas the transformed image does not cover; nil leaves them transparent
+	Fill color.Color
+	// Bilinear interpolates between source pixels instead of taking the nearest one
+	Bilinear bool
+}
+
+// ParseMatrix reads a rows×cols matrix written like a kernel, such as "1,0.2,0; 0,1,0"
+func ParseMatrix(s string, rows, cols int) ([][]float64, error) {
+	m, err := parseRows(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid matrix: %v", err)
+	}
+	if len(m) != rows {
+		return nil, fmt.Errorf("matrix must have %d rows, got %d", rows, len(m))
+	}
+	for i, row := range m {
+		if len(row) != cols {
+			return nil, fmt.Errorf("matrix row %d must have %d values, got %d", i+1, cols, len(row))
+		}
+	}
+	return m, nil
+}
+
+// AffineTransform maps the image through a 2×3 matrix taking source pixel
+// coordinates to output coordinates, both measured from the top-left corner
+func AffineTransform(img image.Image, m [2][3]float64, opts WarpOptions) (image.Image, error) {
+	return PerspectiveTransform(img, [3][3]float64{m[0], m[1], {0, 0, 1}}, opts)
+}
+
+// PerspectiveTransform maps the image through a 3×3 homography taking source pixel
+// coordinates to output coordinates, both measured from the top-left corner
+func PerspectiveTransform(img image.Image, h [3][3]float64, opts WarpOptions) (image.Image, error) {
+	bounds := img.Bounds()
+	w, ht := float64(bounds.Dx()), float64(bounds.Dy())
+	det := h[0][0]*(h[1][1]*h[2][2]-h[1][2]*h[2][1]) -
+		h[0][1]*(h[1][0]*h[2][2]-h[1][2]*h[2][0]) +
+		h[0][2]*(h[1][0]*h[2][1]-h[1][1]*h[2][0])
+	if math.Abs(det) < 1e-12 || math.IsNaN(det) {
+		return nil, fmt.Errorf("transform matrix is singular")
+	}
+	if centre := mulVector(h, [3]float64{w / 2, ht / 2, 1}); centre[2] < 0 {
+		// A homography and its negation are the same mapping; keep the image in front
+		for i := range h {
+			for j := range h[i] {
+				h[i][j] = -h[i][j]
+			}
+		}
+	}
+
+	// Output canvas, translated so the transformed corners fit when requested
+	out := image.Rect(0, 0, bounds.Dx(), bounds.Dy())
+	if opts.Fit {
+		minX, minY := math.Inf(1), math.Inf(1)
+		maxX, maxY := math.Inf(-1), math.Inf(-1)
+		for _, c := range [][3]float64{{0, 0, 1}, {w, 0, 1}, {0, ht, 1}, {w, ht, 1}} {
+			p := mulVector(h, c)
+			if p[2] <= 0 {
+				return nil, fmt.Errorf("transform maps an image corner to infinity; fit is only possible when the whole image stays in front")
+			}
+			minX, maxX = math.Min(minX, p[0]/p[2]), math.Max(maxX, p[0]/p[2])
+			minY, maxY = math.Min(minY, p[1]/p[2]), math.Max(maxY, p[1]/p[2])
+		}
+		if maxX-minX > MaxOutputDimension || maxY-minY > MaxOutputDimension {
+			return nil, fmt.Errorf("transformed image exceeds the maximum of %d pixels per side", MaxOutputDimension)
+		}
+		x0, y0 := int(math.Floor(minX+1e-9)), int(math.Floor(minY+1e-9))
+		out = image.Rect(x0, y0, int(math.Ceil(maxX-1e-9)), int(math.Ceil(maxY-1e-9)))
+		if out.Empty() {
+			return nil, fmt.Errorf("transformed image is empty")
+		}
+		if err := checkOutputSize("transformed image", out.Dx(), out.Dy()); err != nil {
+			return nil, err
+		}
+	}
+
+	inverse := invertMatrix(h)
+	src := ToLinear(img)
+	dst := NewLinearImage(image.Rect(0, 0, out.Dx(), out.Dy()))
+	dst.HighBitDepth = src.HighBitDepth
+	parallelRows(0, out.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := dst.Pix[y*dst.Stride:]
+			for x := 0; x < out.Dx(); x++ {
+				// Map the output pixel centre back into the source
+				p := mulVector(inverse, [3]float64{float64(out.Min.X+x) + 0.5, float64(out.Min.Y+y) + 0.5, 1})
+				if p[2] <= 1e-12 {
+					continue
+				}
+				sampleLinear(src, p[0]/p[2]-0.5, p[1]/p[2]-0.5, opts.Bilinear, row[4*x:4*x+4])
+			}
+		}
+	})
+
+	if opts.Fill != nil {
+		fillUnder(dst, opts.Fill)
+	}
+	return dst.Image(), nil
+}
+
+// sampleLinear reads the image at a fractional pixel position relative to its
+// top-left pixel. Positions beyond the image's edges are transparent; bilinear
+// samples inside them repeat the border pixels rather than fading out.
+func sampleLinear(img *LinearImage, fx, fy float64, bilinear bool, dst []float32) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if fx < -0.5 || fy < -0.5 || fx >= float64(w)-0.5 || fy >= float64(h)-0.5 {
+		return
+	}
+	if !bilinear {
+		x, y := int(math.Floor(fx+0.5)), int(math.Floor(fy+0.5))
+		copy(dst, img.Pix[y*img.Stride+4*x:])
+		return
+	}
+
+	x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+	tx, ty := float32(fx-float64(x0)), float32(fy-float64(y0))
+	xs := [2]int{max(x0, 0), min(x0+1, w-1)}
+	ys := [2]int{max(y0, 0), min(y0+1, h-1)}
+	wx := [2]float32{1 - tx, tx}
+	wy := [2]float32{1 - ty, ty}
+	for j, y := range ys {
+		for i, x := range xs {
+			p := img.Pix[y*img.Stride+4*x:]
+			for c := 0; c < 4; c++ {
+				dst[c] += p[c] * wx[i] * wy[j]
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"strings"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestOutputSizeLimits(t *testing.T) {
+	small := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	// A square resized to the maximum height keeps its aspect ratio at 16384×16384
+	square := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	perSide := fmt.Sprintf("maximum of %d pixels per side", MaxOutputDimension)
+	inAll := fmt.Sprintf("maximum of %d pixels", MaxOutputPixels)
+
+	tests := []struct {
+		name string
+		run  func() (image.Image, error)
+		want string
+	}{
+		{"resize over a side", func() (image.Image, error) {
+			return Resize(small, MaxOutputDimension+1, 1, imaging.Box)
+		}, perSide},
+		{"resize over the area", func() (image.Image, error) {
+			return Resize(small, MaxOutputDimension, MaxOutputDimension, imaging.Box)
+		}, inAll},
+		{"resize over the area keeping the aspect ratio", func() (image.Image, error) {
+			return Resize(square, 0, MaxOutputDimension, imaging.Box)
+		}, inAll},
+		{"affine fit over the area", func() (image.Image, error) {
+			return AffineTransform(small, [2][3]float64{{4096, 0, 0}, {0, 4096, 0}}, WarpOptions{Fit: true})
+		}, inAll},
+		{"perspective fit over a side", func() (image.Image, error) {
+			return PerspectiveTransform(small, [3][3]float64{{5000, 0, 0}, {0, 1, 0}, {0, 0, 1}}, WarpOptions{Fit: true})
+		}, perSide},
+	}
+	for _, tt := range tests {
+		out, err := tt.run()
+		if err == nil {
+			t.Errorf("%s: got a %v image, want an error", tt.name, out.Bounds())
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %q does not mention %q", tt.name, err, tt.want)
+		}
+	}
+
+	// Just inside the limits still works
+	if _, err := Resize(small, MaxOutputDimension, 4, imaging.NearestNeighbor); err != nil {
+		t.Errorf("resize to the maximum width: %v", err)
+	}
+	if _, err := AffineTransform(small, [2][3]float64{{2, 0, 0}, {0, 2, 0}}, WarpOptions{Fit: true}); err != nil {
+		t.Errorf("affine fit at twice the size: %v", err)
+	}
+}
+
+func TestCropLimits(t *testing.T) {
+	if err := checkOutputSize("cropped image", MaxOutputDimension, MaxOutputPixels/MaxOutputDimension+1); err == nil {
+		t.Error("crop over the area was accepted")
+	}
+
+	op, ok := LookupOperation("crop")
+	if !ok {
+		t.Fatal("crop is not registered")
+	}
+	for _, name := range []string{"x", "y", "width", "height"} {
+		if _, errs := ValidateParams(op, map[string]interface{}{name: 1e300}); len(errs) == 0 {
+			t.Errorf("crop accepted %s = 1e300", name)
+		}
+	}
+	p, errs := ValidateParams(op, map[string]interface{}{"x": 2.0, "y": 1.0})
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	out, _, err := op.Run(image.NewNRGBA(image.Rect(0, 0, 4, 4)), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.Bounds().Size(); got != image.Pt(2, 3) {
+		t.Errorf("crop from 2,1 to the corner is %v, want 2x3", got)
+	}
+}
@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// GridVisions lists the simulated panels shown after the original in a comparison grid
+var GridVisions = []string{
+	"protanopia",
+	"deuteranopia",
+	"tritanopia",
+	"protanomaly",
+	"deuteranomaly",
+	"tritanomaly",
+	"achromatopsia",
+	"monochromacy",
+}
+
+const captionHeight = 20
+
+// GridOptions controls the layout of a comparison grid
+type GridOptions struct {
+	Columns   int  // panels per row, 3 by default
+	CellWidth int  // width of each panel in pixels, 320 by default
+	Padding   int  // gap between panels in pixels
+	Captions  bool // draw the vision type under each panel
+}
+
+// DefaultGridOptions returns a 3×3 layout with captions
+func DefaultGridOptions() GridOptions {
+	return GridOptions{Columns: 3, CellWidth: 320, Padding: 8, Captions: true}
+}
+
+// SimulateVision renders the image as seen with a named vision type. The legacy model
+// keeps the fixed anomaly, achromatopsia and monochromacy matrices.
+func SimulateVision(img image.Image, name string, model SimulationModel) (image.Image, error) {
+	if model == ModelLegacy {
+		switch name {
+		case "protanomaly":
+			return SimulateColorBlindness(img, ProtanomalyMatrix), nil
+		case "deuteranomaly":
+			return SimulateColorBlindness(img, DeuteranomalyMatrix), nil
+		case "tritanomaly":
+			return SimulateColorBlindness(img, TritanomalyMatrix), nil
+		case "achromatopsia":
+			return SimulateColorBlindness(img, AchromatopsiaMatrix), nil
+		case "monochromacy":
+			return SimulateColorBlindness(img, MonochromacyMatrix), nil
+		}
+	}
+	if name == "protanopia" || name == "deuteranopia" || name == "tritanopia" {
+		d, _ := ParseDeficiency(name)
+		return SimulateDeficiency(img, d, model, 1)
+	}
+	transform, err := VisionTransform(name, model)
+	if err != nil {
+		return nil, err
+	}
+	return ApplyColorTransform(img, transform), nil
+}
+
+// ComparisonGrid lays out the original image and every simulation in GridVisions
+// as a single labelled image
+func ComparisonGrid(img image.Image, model SimulationModel, opts GridOptions) (image.Image, error) {
+	if opts.Columns < 1 || opts.CellWidth < 16 || opts.Padding < 0 {
+		return nil, fmt.Errorf("invalid grid layout: %d columns, %dpx cells, %dpx padding", opts.Columns, opts.CellWidth, opts.Padding)
+	}
+
+	cell := imaging.Resize(img, opts.CellWidth, 0, imaging.Lanczos)
+	cellHeight := cell.Bounds().Dy()
+	labelHeight := 0
+	if opts.Captions {
+		labelHeight = captionHeight
+	}
+
+	panels := []image.Image{cell}
+	labels := []string{"original"}
+	for _, vision := range GridVisions {
+		sim, err := SimulateVision(cell, vision, model)
+		if err != nil {
+			return nil, err
+		}
+		panels = append(panels, sim)
+		labels = append(labels, vision)
+	}
+
+	rows := (len(panels) + opts.Columns - 1) / opts.Columns
+	width := opts.Columns*(opts.CellWidth+opts.Padding) + opts.Padding
+	height := rows*(cellHeight+labelHeight+opts.Padding) + opts.Padding
+	out := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(out, out.Bounds(), image.White, image.Point{}, draw.Src)
+
+	for i, panel := range panels {
+		x := opts.Padding + (i%opts.Columns)*(opts.CellWidth+opts.Padding)
+		y := opts.Padding + (i/opts.Columns)*(cellHeight+labelHeight+opts.Padding)
+		draw.Draw(out, image.Rect(x, y, x+opts.CellWidth, y+cellHeight), panel, panel.Bounds().Min, draw.Over)
+		if opts.Captions {
+			drawCaption(out, labels[i], x, y+cellHeight+captionHeight-6)
+		}
+	}
+	return out, nil
+}
+
+// drawCaption writes a label with its baseline at (x, y)
+func drawCaption(img draw.Image, text string, x, y int) {
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(color.Black),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(text)
+}
@@ -0,0 +1,404 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+)
+
+// ICC profile connection space white (D50) in XYZ
+var pcsWhiteXYZ = [3]float64{0.9642, 1.0, 0.8249}
+
+// Bradford cone response matrix used for chromatic adaptation
+var bradford = [3][3]float64{
+	{0.8951, 0.2664, -0.1614},
+	{-0.7502, 1.7135, 0.0367},
+	{0.0389, -0.0685, 1.0296},
+}
+
+// Bradford adaptation between the sRGB white (D65) and the PCS white (D50)
+var (
+	xyzD65FromD50 = adaptationMatrix(pcsWhiteXYZ, whiteXYZ)
+	xyzD50FromD65 = invertMatrix(xyzD65FromD50)
+)
+
+// adaptationMatrix returns the Bradford transform of XYZ values from one white point to another
+func adaptationMatrix(from, to [3]float64) [3][3]float64 {
+	src := mulVector(bradford, from)
+	dst := mulVector(bradford, to)
+	scale := [3][3]float64{{dst[0] / src[0], 0, 0}, {0, dst[1] / src[1], 0}, {0, 0, dst[2] / src[2]}}
+	return mulMatrix(invertMatrix(bradford), mulMatrix(scale, bradford))
+}
+
+// srgbFromPCSLab converts a D50 PCS Lab color to gamma-encoded sRGB, clipped to the gamut
+func srgbFromPCSLab(lab Lab) (float64, float64, float64) {
+	fy := (lab.L + 16) / 116
+	fx := fy + lab.A/500
+	fz := fy - lab.B/200
+	xyz := [3]float64{labFInv(fx) * pcsWhiteXYZ[0], labFInv(fy) * pcsWhiteXYZ[1], labFInv(fz) * pcsWhiteXYZ[2]}
+	rgb := mulVector(linearRGBFromXYZ, mulVector(xyzD65FromD50, xyz))
+	return LinearToSRGB(clampUnit(rgb[0])), LinearToSRGB(clampUnit(rgb[1])), LinearToSRGB(clampUnit(rgb[2]))
+}
+
+// pcsLabFromSRGB converts a gamma-encoded sRGB color to D50 PCS Lab
+func pcsLabFromSRGB(r, g, b float64) Lab {
+	xyz := mulVector(xyzD50FromD65, mulVector(xyzFromLinearRGB, [3]float64{SRGBToLinear(r), SRGBToLinear(g), SRGBToLinear(b)}))
+	fx := labF(xyz[0] / pcsWhiteXYZ[0])
+	fy := labF(xyz[1] / pcsWhiteXYZ[1])
+	fz := labF(xyz[2] / pcsWhiteXYZ[2])
+	return Lab{L: 116*fy - 16, A: 500 * (fx - fy), B: 200 * (fy - fz)}
+}
+
+// iccTag is one tagged element of a profile
+type iccTag struct {
+	sig  string
+	data []byte
+}
+
+// s15Fixed16 encodes a number in the ICC signed 15.16 fixed-point format
+func s15Fixed16(v float64) uint32 {
+	return uint32(int32(math.Round(v * 65536)))
+}
+
+// iccText encodes an ASCII string as a v2 textType
+func iccText(s string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("text")
+	buf.Write(make([]byte, 4))
+	buf.WriteString(s)
+	buf.WriteByte(0)
+	return buf.Bytes()
+}
+
+// iccDescription encodes an ASCII string as a v2 textDescriptionType
+func iccDescription(s string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("desc")
+	buf.Write(make([]byte, 4))
+	binary.Write(&buf, binary.BigEndian, uint32(len(s)+1))
+	buf.WriteString(s)
+	buf.WriteByte(0)
+	// Empty Unicode and ScriptCode descriptions
+	buf.Write(make([]byte, 4+4+2+1+67))
+	return buf.Bytes()
+}
+
+// iccXYZ encodes one XYZ value as an XYZType
+func iccXYZ(xyz [3]float64) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("XYZ ")
+	buf.Write(make([]byte, 4))
+	for _, v := range xyz {
+		binary.Write(&buf, binary.BigEndian, s15Fixed16(v))
+	}
+	return buf.Bytes()
+}
+
+// writeICCProfile assembles a v2.1 profile of the given class and color spaces
+func writeICCProfile(w io.Writer, class, colorSpace, pcs string, tags []iccTag) error {
+	const headerSize = 128
+	tableSize := 4 + 12*len(tags)
+
+	// Lay out tag data after the tag table, each element aligned to four bytes
+	offsets := make([]int, len(tags))
+	size := headerSize + tableSize
+	for i, tag := range tags {
+		offsets[i] = size
+		size += (len(tag.data) + 3) &^ 3
+	}
+
+	var buf bytes.Buffer
+	be := func(v interface{}) { binary.Write(&buf, binary.BigEndian, v) }
+	now := time.Now().UTC()
+
+	be(uint32(size))
+	buf.Write(make([]byte, 4)) // preferred CMM
+	be(uint32(0x02100000))
+	buf.WriteString(class)
+	buf.WriteString(colorSpace)
+	buf.WriteString(pcs)
+	be([6]uint16{uint16(now.Year()), uint16(now.Month()), uint16(now.Day()),
+		uint16(now.Hour()), uint16(now.Minute()), uint16(now.Second())})
+	buf.WriteString("acsp")
+	buf.Write(make([]byte, 4+4+4+4+8)) // platform, flags, manufacturer, model, attributes
+	be(uint32(0))                      // perceptual rendering intent
+	for _, v := range pcsWhiteXYZ {
+		be(s15Fixed16(v))
+	}
+	buf.Write(make([]byte, 4+16+28)) // creator, profile ID, reserved
+
+	be(uint32(len(tags)))
+	for i, tag := range tags {
+		if len(tag.sig) != 4 {
+			return fmt.Errorf("invalid ICC tag signature %q", tag.sig)
+		}
+		buf.WriteString(tag.sig)
+		be(uint32(offsets[i]))
+		be(uint32(len(tag.data)))
+	}
+	for _, tag := range tags {
+		buf.Write(tag.data)
+		buf.Write(make([]byte, (4-len(tag.data)%4)%4))
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// lut16LabTransform samples a transform on a grid of PCS Lab values and encodes it
+// as a lut16Type mapping Lab to Lab
+func lut16LabTransform(transform ColorTransform, grid int) []byte {
+	var buf bytes.Buffer
+	be := func(v interface{}) { binary.Write(&buf, binary.BigEndian, v) }
+
+	buf.WriteString("mft2")
+	buf.Write(make([]byte, 4))
+	buf.Write([]byte{3, 3, byte(grid), 0})
+	for i := 0; i < 9; i++ {
+		if i%4 == 0 {
+			be(s15Fixed16(1))
+		} else {
+			be(uint32(0))
+		}
+	}
+	// Two-entry identity input and output curves
+	be(uint16(2))
+	be(uint16(2))
+	for c := 0; c < 3; c++ {
+		be([2]uint16{0, 0xffff})
+	}
+
+	// Legacy 16-bit PCS Lab encoding: L* 0..100 maps to 0..0xff00, a* and b* -128..127.996 to 0..0xffff
+	decode := func(v uint16) (float64, float64) {
+		return float64(v) * 100 / 0xff00, float64(v)/256 - 128
+	}
+	encodeL := func(l float64) uint16 { return uint16(math.Round(math.Max(0, math.Min(l*0xff00/100, 0xffff)))) }
+	encodeAB := func(a float64) uint16 { return uint16(math.Round(math.Max(0, math.Min((a+128)*256, 0xffff)))) }
+
+	// The first input channel varies slowest
+	for li := 0; li < grid; li++ {
+		for ai := 0; ai < grid; ai++ {
+			for bi := 0; bi < grid; bi++ {
+				l, _ := decode(uint16(li * 0xffff / (grid - 1)))
+				_, a := decode(uint16(ai * 0xffff / (grid - 1)))
+				_, b := decode(uint16(bi * 0xffff / (grid - 1)))
+				out := pcsLabFromSRGB(transform(srgbFromPCSLab(Lab{math.Min(l, 100), a, b})))
+				be([3]uint16{encodeL(out.L), encodeAB(out.A), encodeAB(out.B)})
+			}
+		}
+	}
+	for c := 0; c < 3; c++ {
+		be([2]uint16{0, 0xffff})
+	}
+	return buf.Bytes()
+}
+
+// WriteAbstractProfile writes an ICC v2 abstract profile that applies the transform
+// in PCS Lab, sampled on a grid³ table, for use as a proofing step in design tools
+func WriteAbstractProfile(w io.Writer, description string, transform ColorTransform, grid int) error {
+	if grid < 2 || grid > 255 {
+		return fmt.Errorf("ICC grid size must be between 2 and 255, got %d", grid)
+	}
+	return writeICCProfile(w, "abst", "Lab ", "Lab ", []iccTag{
+		{"desc", iccDescription(description)},
+		{"cprt", iccText("No copyright, use freely")},
+		{"wtpt", iccXYZ(pcsWhiteXYZ)},
+		{"A2B0", lut16LabTransform(transform, grid)},
+	})
+}
+
+// iccCurve encodes a tone curve as a curveType table of n entries
+func iccCurve(curve func(float64) float64, n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("curv")
+	buf.Write(make([]byte, 4))
+	binary.Write(&buf, binary.BigEndian, uint32(n))
+	for i := 0; i < n; i++ {
+		binary.Write(&buf, binary.BigEndian, uint16(math.Round(clampUnit(curve(float64(i)/float64(n-1)))*0xffff)))
+	}
+	return buf.Bytes()
+}
+
+// srgbICCProfile returns an ICC v2 display profile for sRGB, used to tag saved images
+var srgbICCProfile = sync.OnceValue(func() []byte {
+	// Colorants are the sRGB primaries adapted to the D50 PCS
+	colorants := mulMatrix(xyzD50FromD65, xyzFromLinearRGB)
+	column := func(c int) [3]float64 { return [3]float64{colorants[0][c], colorants[1][c], colorants[2][c]} }
+	trc := iccCurve(SRGBToLinear, 1024)
+
+	var buf bytes.Buffer
+	writeICCProfile(&buf, "mntr", "RGB ", "XYZ ", []iccTag{
+		{"desc", iccDescription("sRGB IEC61966-2.1")},
+		{"cprt", iccText("No copyright, use freely")},
+		{"wtpt", iccXYZ(pcsWhiteXYZ)},
+		{"rXYZ", iccXYZ(column(0))},
+		{"gXYZ", iccXYZ(column(1))},
+		{"bXYZ", iccXYZ(column(2))},
+		{"rTRC", trc},
+		{"gTRC", trc},
+		{"bTRC", trc},
+	})
+	return buf.Bytes()
+})
+
+// ParseICCProfile reads an RGB matrix/TRC profile, the kind used for Display P3,
+// Adobe RGB and sRGB. Profiles built only from lookup tables, and CMYK or
+// grayscale profiles, are not supported.
+func ParseICCProfile(data []byte) (*ColorProfile, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, fmt.Errorf("not an ICC profile")
+	}
+	if space := string(data[16:20]); space != "RGB " {
+		return nil, fmt.Errorf("unsupported ICC color space %q", space)
+	}
+	if pcs := string(data[20:24]); pcs != "XYZ " {
+		return nil, fmt.Errorf("unsupported ICC connection space %q", pcs)
+	}
+
+	tags := map[string][]byte{}
+	count := int(binary.BigEndian.Uint32(data[128:]))
+	if count > (len(data)-132)/12 {
+		return nil, fmt.Errorf("truncated ICC tag table")
+	}
+	for i := 0; i < count; i++ {
+		entry := data[132+12*i:]
+		offset, size := binary.BigEndian.Uint32(entry[4:]), binary.BigEndian.Uint32(entry[8:])
+		if uint64(offset)+uint64(size) > uint64(len(data)) || size < 8 {
+			return nil, fmt.Errorf("ICC tag %q lies outside the profile", entry[:4])
+		}
+		tags[string(entry[:4])] = data[offset : offset+size]
+	}
+
+	profile := &ColorProfile{Name: iccDescriptionText(tags["desc"])}
+	var colorants [3][3]float64
+	for c, channel := range []string{"r", "g", "b"} {
+		xyz, err := parseICCXYZ(tags[channel+"XYZ"])
+		if err != nil {
+			return nil, fmt.Errorf("ICC %sXYZ tag: %v", channel, err)
+		}
+		for i := range xyz {
+			colorants[i][c] = xyz[i]
+		}
+		if profile.curves[c], err = parseICCCurve(tags[channel+"TRC"]); err != nil {
+			return nil, fmt.Errorf("ICC %sTRC tag: %v", channel, err)
+		}
+	}
+	if math.Abs(dot(colorants[0], cross(colorants[1], colorants[2]))) < 1e-9 {
+		return nil, fmt.Errorf("ICC colorants are not independent")
+	}
+	profile.toLinearSRGB = mulMatrix(linearRGBFromXYZ, mulMatrix(xyzD65FromD50, colorants))
+	return profile, nil
+}
+
+// iccDescriptionText returns the text of a v2 textDescriptionType or the first
+// record of a v4 multiLocalizedUnicodeType
+func iccDescriptionText(tag []byte) string {
+	switch {
+	case len(tag) >= 12 && string(tag[:4]) == "desc":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		if n > len(tag)-12 {
+			return ""
+		}
+		return string(bytes.TrimRight(tag[12:12+n], "\x00"))
+	case len(tag) >= 28 && string(tag[:4]) == "mluc":
+		if binary.BigEndian.Uint32(tag[8:]) == 0 {
+			return ""
+		}
+		n, offset := int(binary.BigEndian.Uint32(tag[20:])), int(binary.BigEndian.Uint32(tag[24:]))
+		if offset+n > len(tag) {
+			return ""
+		}
+		units := make([]uint16, n/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(tag[offset+2*i:])
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	}
+	return ""
+}
+
+// parseICCXYZ reads the first value of an XYZType
+func parseICCXYZ(tag []byte) ([3]float64, error) {
+	if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+		return [3]float64{}, fmt.Errorf("missing or not an XYZType")
+	}
+	var xyz [3]float64
+	for i := range xyz {
+		xyz[i] = float64(int32(binary.BigEndian.Uint32(tag[8+4*i:]))) / 65536
+	}
+	return xyz, nil
+}
+
+// parseICCCurve reads a curveType or parametricCurveType as a function decoding
+// a channel value to linear light
+func parseICCCurve(tag []byte) (func(float64) float64, error) {
+	if len(tag) < 12 {
+		return nil, fmt.Errorf("missing or truncated curve")
+	}
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		if n > (len(tag)-12)/2 {
+			return nil, fmt.Errorf("truncated curve")
+		}
+		switch n {
+		case 0:
+			return func(v float64) float64 { return v }, nil
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return func(v float64) float64 { return math.Pow(v, gamma) }, nil
+		}
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 0xffff
+		}
+		return func(v float64) float64 {
+			pos := clampUnit(v) * float64(n-1)
+			i := min(int(pos), n-2)
+			f := pos - float64(i)
+			return table[i]*(1-f) + table[i+1]*f
+		}, nil
+
+	case "para":
+		// Parameters g, a, b, c, d, e, f of the five function types
+		counts := []int{1, 3, 4, 5, 7}
+		kind := int(binary.BigEndian.Uint16(tag[8:]))
+		if kind >= len(counts) || len(tag) < 12+4*counts[kind] {
+			return nil, fmt.Errorf("unsupported parametric curve type %d", kind)
+		}
+		var p [7]float64
+		for i := 0; i < counts[kind]; i++ {
+			p[i] = float64(int32(binary.BigEndian.Uint32(tag[12+4*i:]))) / 65536
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+		pow := func(v float64) float64 { return math.Pow(math.Max(v, 0), g) }
+		switch kind {
+		case 0:
+			return pow, nil
+		case 1:
+			return func(v float64) float64 { return pow(a*v + b) }, nil
+		case 2:
+			return func(v float64) float64 { return pow(a*v+b) + c }, nil
+		case 3:
+			return func(v float64) float64 {
+				if v >= d {
+					return pow(a*v + b)
+				}
+				return c * v
+			}, nil
+		}
+		return func(v float64) float64 {
+			if v >= d {
+				return pow(a*v+b) + e
+			}
+			return c*v + f
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported curve type %q", tag[:4])
+}
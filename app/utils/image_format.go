@@ -0,0 +1,235 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/disintegration/imaging"
+)
+
+// ImageFormat is a file format results can be saved in
+type ImageFormat string
+
+const (
+	FormatAuto ImageFormat = ""     // PNG when the image has transparency or 16-bit channels, JPEG otherwise
+	FormatPNG  ImageFormat = "png"  // lossless, keeps alpha and 16-bit channels
+	FormatJPEG ImageFormat = "jpeg" // lossy 8-bit; transparent areas are flattened onto white
+	FormatWebP ImageFormat = "webp" // only when an encoder has been registered
+	FormatTIFF ImageFormat = "tiff" // lossless, keeps alpha and 16-bit channels
+)
+
+// JPEG quality used for saved results
+const jpegQuality = 95
+
+// webpEncoder is set by RegisterWebPEncoder; the standard library and x/image only decode WebP
+var webpEncoder = struct {
+	sync.RWMutex
+	encode func(io.Writer, image.Image) error
+}{}
+
+// RegisterWebPEncoder makes the WebP format available, typically from a build
+// that links a cgo or WASM encoder
+func RegisterWebPEncoder(encode func(io.Writer, image.Image) error) {
+	webpEncoder.Lock()
+	defer webpEncoder.Unlock()
+	webpEncoder.encode = encode
+}
+
+// ImageFormats lists the formats that can currently be encoded
+func ImageFormats() []ImageFormat {
+	formats := []ImageFormat{FormatPNG, FormatJPEG, FormatTIFF}
+	webpEncoder.RLock()
+	defer webpEncoder.RUnlock()
+	if webpEncoder.encode != nil {
+		formats = append(formats, FormatWebP)
+	}
+	return formats
+}
+
+// ParseImageFormat validates a format name or file extension. "" and "auto" select FormatAuto.
+func ParseImageFormat(name string) (ImageFormat, error) {
+	switch strings.ToLower(strings.TrimPrefix(name, ".")) {
+	case "", "auto":
+		return FormatAuto, nil
+	case "png":
+		return FormatPNG, nil
+	case "jpeg", "jpg":
+		return FormatJPEG, nil
+	case "tiff", "tif":
+		return FormatTIFF, nil
+	case "webp":
+		if !FormatWebP.Available() {
+			return "", fmt.Errorf("webp output is not available in this build")
+		}
+		return FormatWebP, nil
+	}
+	return "", fmt.Errorf("unknown image format %q", name)
+}
+
+// Available reports whether the format can be encoded
+func (f ImageFormat) Available() bool {
+	for _, available := range ImageFormats() {
+		if f == available {
+			return true
+		}
+	}
+	return f == FormatAuto
+}
+
+// Extension returns the file extension of the format, without the dot
+func (f ImageFormat) Extension() string {
+	if f == FormatJPEG {
+		return "jpg"
+	}
+	return string(f)
+}
+
+// ContentType returns the MIME type of the format
+func (f ImageFormat) ContentType() string {
+	return "image/" + string(f)
+}
+
+// NegotiateFormat picks the format from an Accept header listing image types,
+// honouring q-values. Wildcards and non-image types are ignored, so a header such
+// as "text/event-stream" or "*/*" leaves the choice to FormatAuto.
+func NegotiateFormat(accept string) ImageFormat {
+	type candidate struct {
+		format ImageFormat
+		q      float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		subtype, ok := strings.CutPrefix(mediaType, "image/")
+		if !ok {
+			continue
+		}
+		format, err := ParseImageFormat(subtype)
+		if err != nil || format == FormatAuto {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil || q <= 0 {
+				continue
+			}
+		}
+		candidates = append(candidates, candidate{format, q})
+	}
+	if len(candidates) == 0 {
+		return FormatAuto
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].format
+}
+
+// Resolve replaces FormatAuto with the format suited to the image
+func (f ImageFormat) Resolve(img image.Image) ImageFormat {
+	if f != FormatAuto {
+		return f
+	}
+	if IsHighBitDepth(img) || !isOpaque(img) {
+		return FormatPNG
+	}
+	return FormatJPEG
+}
+
+// isOpaque reports whether every pixel of the image is fully opaque
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// EncodeImage writes the image in the given format. PNG and TIFF keep alpha and
+// 16-bit channels; JPEG flattens transparency onto white. PNG and JPEG files are
+// tagged as sRGB.
+func EncodeImage(w io.Writer, img image.Image, format ImageFormat) error {
+	switch format.Resolve(img) {
+	case FormatPNG:
+		// An sRGB chunk with the perceptual intent, right after the 33-byte signature and IHDR
+		return writeTagged(w, 33, pngChunk("sRGB", []byte{0}), func(w io.Writer) error {
+			return imaging.Encode(w, img, imaging.PNG)
+		})
+	case FormatTIFF:
+		return imaging.Encode(w, img, imaging.TIFF)
+	case FormatJPEG:
+		if !isOpaque(img) {
+			flat := image.NewRGBA(img.Bounds())
+			draw.Draw(flat, flat.Rect, image.NewUniform(color.White), image.Point{}, draw.Src)
+			draw.Draw(flat, flat.Rect, img, img.Bounds().Min, draw.Over)
+			img = flat
+		}
+		// An APP2 segment with the sRGB ICC profile, right after the start of image marker
+		return writeTagged(w, 2, jpegICCSegment(srgbICCProfile()), func(w io.Writer) error {
+			return imaging.Encode(w, img, imaging.JPEG, imaging.JPEGQuality(jpegQuality))
+		})
+	case FormatWebP:
+		webpEncoder.RLock()
+		encode := webpEncoder.encode
+		webpEncoder.RUnlock()
+		if encode == nil {
+			return fmt.Errorf("webp output is not available in this build")
+		}
+		return encode(w, img)
+	}
+	return fmt.Errorf("unknown image format %q", format)
+}
+
+// writeTagged encodes into a buffer and writes the result with tag inserted after
+// the first offset bytes
+func writeTagged(w io.Writer, offset int, tag []byte, encode func(io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := encode(&buf); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	if len(data) < offset {
+		return fmt.Errorf("encoded image is too short to tag")
+	}
+	for _, part := range [][]byte{data[:offset], tag, data[offset:]} {
+		if _, err := w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pngChunk frames data as a PNG chunk of the given type
+func pngChunk(kind string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, kind...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// jpegICCSegment wraps an ICC profile of up to 64 KB in a single APP2 segment
+func jpegICCSegment(icc []byte) []byte {
+	payload := append([]byte("ICC_PROFILE\x00\x01\x01"), icc...)
+	segment := []byte{0xff, 0xe2}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(2+len(payload)))
+	return append(segment, payload...)
+}
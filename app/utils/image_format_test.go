@@ -0,0 +1,207 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   ImageFormat
+	}{
+		{"", FormatAuto},
+		{"image/png", FormatPNG},
+		{"image/jpeg", FormatJPEG},
+		{"image/tiff", FormatTIFF},
+		// The highest q-value wins, whatever the order
+		{"image/jpeg;q=0.5, image/png", FormatPNG},
+		{"image/png;q=0.4, image/tiff;q=0.9, image/jpeg;q=0.6", FormatTIFF},
+		{"image/png; q=0.8, image/jpeg; q=0.9", FormatJPEG},
+		// Equal q-values keep the order of the header
+		{"image/tiff, image/png", FormatTIFF},
+		{"image/png;q=0.7, image/jpeg;q=0.7", FormatPNG},
+		// q=0 means not acceptable
+		{"image/png;q=0, image/jpeg;q=0.1", FormatJPEG},
+		{"image/png;q=0", FormatAuto},
+		// Wildcards and other types leave the choice to auto
+		{"*/*", FormatAuto},
+		{"image/*", FormatAuto},
+		{"text/html, application/xhtml+xml, */*;q=0.8", FormatAuto},
+		{"image/*;q=0.9, image/jpeg;q=0.2", FormatJPEG},
+		{"text/event-stream", FormatAuto},
+		// Unknown and unavailable image types are skipped
+		{"image/avif, image/jpeg;q=0.5", FormatJPEG},
+		{"image/webp", FormatAuto},
+		{"image/png;q=abc, image/tiff;q=0.1", FormatTIFF},
+	}
+	for _, tt := range tests {
+		if got := NegotiateFormat(tt.accept); got != tt.want {
+			t.Errorf("NegotiateFormat(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestResolveFormat(t *testing.T) {
+	opaque := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	for i := 3; i < len(opaque.Pix); i += 4 {
+		opaque.Pix[i] = 255
+	}
+	translucent := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	tests := []struct {
+		name string
+		img  image.Image
+		want ImageFormat
+	}{
+		{"opaque 8-bit", opaque, FormatJPEG},
+		{"translucent", translucent, FormatPNG},
+		{"16-bit", image.NewNRGBA64(image.Rect(0, 0, 2, 2)), FormatPNG},
+		{"gray", image.NewGray(image.Rect(0, 0, 2, 2)), FormatJPEG},
+	}
+	for _, tt := range tests {
+		if got := FormatAuto.Resolve(tt.img); got != tt.want {
+			t.Errorf("%s: auto resolves to %q, want %q", tt.name, got, tt.want)
+		}
+		if got := FormatTIFF.Resolve(tt.img); got != FormatTIFF {
+			t.Errorf("%s: explicit TIFF resolves to %q", tt.name, got)
+		}
+	}
+}
+
+func TestEncodeJPEGFlattensOntoWhite(t *testing.T) {
+	// 16×16 blocks keep JPEG ringing away from the sampled block centres
+	img := image.NewNRGBA(image.Rect(0, 0, 48, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 48; x++ {
+			switch {
+			case x < 16:
+				img.SetNRGBA(x, y, color.NRGBA{0, 0, 0, 0})
+			case x < 32:
+				img.SetNRGBA(x, y, color.NRGBA{0, 0, 0, 128})
+			default:
+				img.SetNRGBA(x, y, color.NRGBA{200, 30, 30, 255})
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeImage(&buf, img, FormatJPEG); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// JPEG chroma subsampling and quantisation allow a few levels of error
+	const tolerance = 4
+	tests := []struct {
+		x    int
+		want color.NRGBA
+	}{
+		{8, color.NRGBA{255, 255, 255, 255}},  // transparent becomes white
+		{24, color.NRGBA{127, 127, 127, 255}}, // half-transparent black over white
+		{40, color.NRGBA{200, 30, 30, 255}},   // opaque stays as it was
+	}
+	for _, tt := range tests {
+		got := color.NRGBAModel.Convert(decoded.At(tt.x, 8)).(color.NRGBA)
+		if absDiff8(got.R, tt.want.R) > tolerance || absDiff8(got.G, tt.want.G) > tolerance || absDiff8(got.B, tt.want.B) > tolerance {
+			t.Errorf("pixel %d,8 is %v, want %v ± %d", tt.x, got, tt.want, tolerance)
+		}
+	}
+}
+
+func absDiff8(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+// pngChunks splits a PNG file into its chunk types and payloads, checking the CRCs
+func pngChunks(t *testing.T, data []byte) (kinds []string, payloads [][]byte) {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")) {
+		t.Fatal("missing PNG signature")
+	}
+	for rest := data[8:]; len(rest) > 0; {
+		if len(rest) < 12 {
+			t.Fatal("truncated PNG chunk")
+		}
+		n := int(binary.BigEndian.Uint32(rest))
+		chunk := rest[4 : 8+n]
+		if crc := binary.BigEndian.Uint32(rest[8+n:]); crc != crc32.ChecksumIEEE(chunk) {
+			t.Fatalf("bad CRC on %q chunk", chunk[:4])
+		}
+		kinds = append(kinds, string(chunk[:4]))
+		payloads = append(payloads, chunk[4:])
+		rest = rest[12+n:]
+	}
+	return kinds, payloads
+}
+
+func TestEncodePNGTagsSRGBAfterIHDR(t *testing.T) {
+	images := map[string]image.Image{
+		"8-bit":  image.NewNRGBA(image.Rect(0, 0, 5, 3)),
+		"16-bit": deepSource(),
+		"gray":   image.NewGray(image.Rect(0, 0, 4, 4)),
+	}
+	for name, img := range images {
+		var buf bytes.Buffer
+		if err := EncodeImage(&buf, img, FormatPNG); err != nil {
+			t.Fatal(err)
+		}
+		kinds, payloads := pngChunks(t, buf.Bytes())
+		if len(kinds) < 3 || kinds[0] != "IHDR" || kinds[1] != "sRGB" {
+			t.Fatalf("%s: chunks %v, want IHDR then sRGB", name, kinds)
+		}
+		if !bytes.Equal(payloads[1], []byte{0}) {
+			t.Errorf("%s: sRGB rendering intent %v, want perceptual (0)", name, payloads[1])
+		}
+		count := 0
+		for _, kind := range kinds {
+			if kind == "sRGB" || kind == "iCCP" || kind == "gAMA" {
+				count++
+			}
+		}
+		if count != 1 {
+			t.Errorf("%s: %d color space chunks in %v, want only sRGB", name, count, kinds)
+		}
+
+		decoded, err := png.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: tagged file does not decode: %v", name, err)
+		}
+		if decoded.Bounds() != img.Bounds() {
+			t.Errorf("%s: decoded bounds %v, want %v", name, decoded.Bounds(), img.Bounds())
+		}
+	}
+}
+
+func TestEncodePNGKeepsHighBitDepth(t *testing.T) {
+	src := deepSource()
+	var buf bytes.Buffer
+	if err := EncodeImage(&buf, src, FormatAuto); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeImage(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsHighBitDepth(decoded) {
+		t.Fatalf("decoded %T, want 16 bits per channel", decoded)
+	}
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			if got, want := color.NRGBA64Model.Convert(decoded.At(x, y)), src.NRGBA64At(x, y); got != want {
+				t.Fatalf("pixel %d,%d is %v after saving, want %v", x, y, got, want)
+			}
+		}
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"image"
 	"image/color"
 	"io"
-	"math"
 
 	"github.com/disintegration/imaging"
 )
@@ -21,33 +20,8 @@ var (
 	MonochromacyMatrix  = [3][3]float64{{0.33, 0.33, 0.33}, {0.33, 0.33, 0.33}, {0.33, 0.33, 0.33}}
 )
 
-// Box blur matrix
-var boxBlurMatrix = [3][3]float64{
-	{1.0 / 9, 1.0 / 9, 1.0 / 9},
-	{1.0 / 9, 1.0 / 9, 1.0 / 9},
-	{1.0 / 9, 1.0 / 9, 1.0 / 9},
-}
-
-// Gaussian blur matrix
-var gaussianBlurMatrix = [3][3]float64{
-	{0.0625, 0.125, 0.0625},
-	{0.125, 0.25, 0.125},
-	{0.0625, 0.125, 0.0625},
-}
-
-// Sobel operators for edge detection
-var (
-	sobelX = [3][3]float64{
-		{-1, 0, 1},
-		{-2, 0, 2},
-		{-1, 0, 1},
-	}
-	sobelY = [3][3]float64{
-		{-1, -2, -1},
-		{0, 0, 0},
-		{1, 2, 1},
-	}
-)
+// Binomial approximation of a Gaussian used by ApplyGaussianBlur
+var binomialKernel = []float64{0.25, 0.5, 0.25}
 
 func clamp(value float64) float64 {
 	if value < 0 {
@@ -61,14 +35,7 @@ func clamp(value float64) float64 {
 
 // FlipImage flips the image upside down
 func FlipImage(img image.Image) image.Image {
-	bounds := img.Bounds()
-	out := image.NewRGBA(bounds)
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			out.Set(x, bounds.Max.Y-y-1, img.At(x, y))
-		}
-	}
-	return out
+	return FlipVertical(img)
 }
 
 // RotateImage rotates the image by the given angle
@@ -76,186 +43,139 @@ func RotateImage(img image.Image, angle float64) image.Image {
 	return imaging.Rotate(img, angle, color.Transparent)
 }
 
-// RotateImageWithShear rotates the image using three shear matrices
+// RotateImageWithShear rotates the image about its centre using three shears,
+// expanding the canvas and leaving the uncovered corners transparent
 func RotateImageWithShear(img image.Image, angle float64) image.Image {
-	// Convert angle to radians
-	theta := angle * math.Pi / 180.0
-
-	// Calculate shear matrices
-	alpha := -math.Tan(theta / 2)
-	beta := math.Sin(theta)
-	gamma := -math.Tan(theta / 2)
-
-	// Apply shears
-	img = applyShear(img, alpha, 0) // First shear
-	img = applyShear(img, beta, 1)  // Second shear
-	img = applyShear(img, gamma, 0) // Third shear
-
-	return img
-}
-
-func applyShear(img image.Image, factor float64, axis int) image.Image {
-	bounds := img.Bounds()
-	out := image.NewRGBA(bounds)
-
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			var newX, newY int
-			if axis == 0 {
-				newX = x + int(float64(y)*factor)
-				newY = y
-			} else {
-				newX = x
-				newY = y + int(float64(x)*factor)
-			}
-
-			if newX >= bounds.Min.X && newX < bounds.Max.X &&
-				newY >= bounds.Min.Y && newY < bounds.Max.Y {
-				out.Set(newX, newY, img.At(x, y))
-			}
-		}
-	}
-	return out
+	return RotateShear(img, angle, ShearRotateOptions{Antialias: true})
 }
 
-// ConvertToGrayscale converts the image to grayscale
+// ConvertToGrayscale converts the image to grayscale using the luminance of its linear-light color
 func ConvertToGrayscale(img image.Image) image.Image {
-	bounds := img.Bounds()
-	out := image.NewRGBA(bounds)
+	lin := ToLinear(img)
 
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			originalColor := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
-			gray := uint8(0.299*float64(originalColor.R) +
-				0.587*float64(originalColor.G) +
-				0.114*float64(originalColor.B))
-			out.Set(x, y, color.RGBA{gray, gray, gray, originalColor.A})
+	parallelRows(lin.Rect.Min.Y, lin.Rect.Max.Y, func(y0, y1 int) {
+		for i := lin.PixOffset(lin.Rect.Min.X, y0); i < lin.PixOffset(lin.Rect.Min.X, y1); i += 4 {
+			y := 0.2126*lin.Pix[i] + 0.7152*lin.Pix[i+1] + 0.0722*lin.Pix[i+2]
+			lin.Pix[i], lin.Pix[i+1], lin.Pix[i+2] = y, y, y
 		}
-	}
-	return out
+	})
+	return lin.Image()
 }
 
-// ApplyBoxBlur applies a box blur filter to the image
+// ApplyBoxBlur applies a 3×3 box blur filter to the image
 func ApplyBoxBlur(img image.Image) image.Image {
-	return applyConvolution(img, boxBlurMatrix)
+	out, _ := BoxBlur(img, 1, EdgeClamp)
+	return out
 }
 
-// ApplyGaussianBlur applies a Gaussian blur filter to the image
+// ApplyGaussianBlur applies a 3×3 Gaussian blur filter to the image
 func ApplyGaussianBlur(img image.Image) image.Image {
-	return applyConvolution(img, gaussianBlurMatrix)
+	out, _ := ConvolveSeparable(img, binomialKernel, binomialKernel, EdgeClamp)
+	return out
 }
 
 // ApplyEdgeDetection applies Sobel edge detection to the image
 func ApplyEdgeDetection(img image.Image) image.Image {
-	bounds := img.Bounds()
-	out := image.NewRGBA(bounds)
-
-	// Convert to grayscale first
-	grayImg := ConvertToGrayscale(img)
-
-	for y := bounds.Min.Y + 1; y < bounds.Max.Y-1; y++ {
-		for x := bounds.Min.X + 1; x < bounds.Max.X-1; x++ {
-			var gx, gy float64
-
-			// Apply Sobel operators
-			for ky := -1; ky <= 1; ky++ {
-				for kx := -1; kx <= 1; kx++ {
-					pixel := color.RGBAModel.Convert(grayImg.At(x+kx, y+ky)).(color.RGBA)
-					gray := float64(pixel.R)
-					gx += gray * sobelX[ky+1][kx+1]
-					gy += gray * sobelY[ky+1][kx+1]
-				}
-			}
-
-			// Calculate gradient magnitude
-			magnitude := math.Sqrt(gx*gx + gy*gy)
-			magnitude = clamp(magnitude)
-
-			out.Set(x, y, color.RGBA{uint8(magnitude), uint8(magnitude), uint8(magnitude), 255})
-		}
-	}
+	out, _ := DetectEdges(img, DefaultEdgeOptions())
 	return out
 }
 
-func applyConvolution(img image.Image, kernel [3][3]float64) image.Image {
-	bounds := img.Bounds()
-	out := image.NewRGBA(bounds)
+// SimulateColorBlindness applies color blindness simulation. The matrices work on
+// gamma-encoded values, so this is the legacy model kept for reproducible output;
+// see SimulateDeficiency for the linear-light models.
+func SimulateColorBlindness(img image.Image, matrix [3][3]float64) image.Image {
+	return legacyFilter(img, func(r, g, b float64) (float64, float64, float64) {
+		return clamp(r*matrix[0][0] + g*matrix[0][1] + b*matrix[0][2]),
+			clamp(r*matrix[1][0] + g*matrix[1][1] + b*matrix[1][2]),
+			clamp(r*matrix[2][0] + g*matrix[2][1] + b*matrix[2][2])
+	})
+}
 
-	for y := bounds.Min.Y + 1; y < bounds.Max.Y-1; y++ {
-		for x := bounds.Min.X + 1; x < bounds.Max.X-1; x++ {
-			var r, g, b float64
+// Daltonize applies daltonization to the image. Like SimulateColorBlindness it works
+// on gamma-encoded values; DaltonizeDeficiency is the linear-light version.
+func Daltonize(img image.Image, cbMatrix [3][3]float64) image.Image {
+	return legacyFilter(img, func(r, g, b float64) (float64, float64, float64) {
+		simR := clamp(r*cbMatrix[0][0] + g*cbMatrix[0][1] + b*cbMatrix[0][2])
+		simG := clamp(r*cbMatrix[1][0] + g*cbMatrix[1][1] + b*cbMatrix[1][2])
+		simB := clamp(r*cbMatrix[2][0] + g*cbMatrix[2][1] + b*cbMatrix[2][2])
 
-			for ky := -1; ky <= 1; ky++ {
-				for kx := -1; kx <= 1; kx++ {
-					pixel := color.RGBAModel.Convert(img.At(x+kx, y+ky)).(color.RGBA)
-					r += float64(pixel.R) * kernel[ky+1][kx+1]
-					g += float64(pixel.G) * kernel[ky+1][kx+1]
-					b += float64(pixel.B) * kernel[ky+1][kx+1]
-				}
-			}
+		errR := r - simR
+		errG := g - simG
+		errB := b - simB
 
-			out.Set(x, y, color.RGBA{
-				uint8(clamp(r)),
-				uint8(clamp(g)),
-				uint8(clamp(b)),
-				255,
-			})
-		}
-	}
-	return out
+		return clamp(r + errR*DefaultDaltonizeStrength),
+			clamp(g + errG*DefaultDaltonizeStrength),
+			clamp(b + errB*DefaultDaltonizeStrength)
+	})
 }
 
-// SimulateColorBlindness applies color blindness simulation
-func SimulateColorBlindness(img image.Image, matrix [3][3]float64) image.Image {
+// legacyFilter maps the premultiplied color of every pixel through fn on a 0..255
+// scale, as the original 8-bit filters did. 8-bit images give exactly their old
+// output; 16-bit images keep their precision.
+func legacyFilter(img image.Image, fn func(r, g, b float64) (float64, float64, float64)) image.Image {
 	bounds := img.Bounds()
-	out := image.NewRGBA(bounds)
-
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			originalColor := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
-			r, g, b := float64(originalColor.R), float64(originalColor.G), float64(originalColor.B)
-
-			newR := clamp(r*matrix[0][0] + g*matrix[0][1] + b*matrix[0][2])
-			newG := clamp(r*matrix[1][0] + g*matrix[1][1] + b*matrix[1][2])
-			newB := clamp(r*matrix[2][0] + g*matrix[2][1] + b*matrix[2][2])
-
-			out.Set(x, y, color.RGBA{uint8(newR), uint8(newG), uint8(newB), originalColor.A})
-		}
+	if IsHighBitDepth(img) {
+		out := image.NewRGBA64(bounds)
+		parallelRows(bounds.Min.Y, bounds.Max.Y, func(y0, y1 int) {
+			row := make([]uint32, 4*bounds.Dx())
+			for y := y0; y < y1; y++ {
+				premultipliedRow(img, y, bounds.Min.X, bounds.Max.X, row)
+				pix := out.Pix[out.PixOffset(bounds.Min.X, y):]
+				for i := 0; i < len(row); i += 4 {
+					r, g, b := fn(float64(row[i])/257, float64(row[i+1])/257, float64(row[i+2])/257)
+					// Keep the premultiplied color within alpha
+					a := float64(row[i+3])
+					putNRGBA64(pix[2*i:], uint16(min(r*257+0.5, a)), uint16(min(g*257+0.5, a)), uint16(min(b*257+0.5, a)), uint16(row[i+3]))
+				}
+			}
+		})
+		return out
 	}
-	return out
-}
 
-// Daltonize applies daltonization to the image
-func Daltonize(img image.Image, cbMatrix [3][3]float64) image.Image {
-	bounds := img.Bounds()
 	out := image.NewRGBA(bounds)
-
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			originalColor := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
-			r, g, b := float64(originalColor.R), float64(originalColor.G), float64(originalColor.B)
-
-			simR := clamp(r*cbMatrix[0][0] + g*cbMatrix[0][1] + b*cbMatrix[0][2])
-			simG := clamp(r*cbMatrix[1][0] + g*cbMatrix[1][1] + b*cbMatrix[1][2])
-			simB := clamp(r*cbMatrix[2][0] + g*cbMatrix[2][1] + b*cbMatrix[2][2])
-
-			errR := r - simR
-			errG := g - simG
-			errB := b - simB
-
-			newR := clamp(r + errR*0.6)
-			newG := clamp(g + errG*0.6)
-			newB := clamp(b + errB*0.6)
-
-			out.Set(x, y, color.RGBA{uint8(newR), uint8(newG), uint8(newB), originalColor.A})
+	parallelRows(bounds.Min.Y, bounds.Max.Y, func(y0, y1 int) {
+		row := make([]uint32, 4*bounds.Dx())
+		for y := y0; y < y1; y++ {
+			premultipliedRow(img, y, bounds.Min.X, bounds.Max.X, row)
+			pix := out.Pix[out.PixOffset(bounds.Min.X, y):]
+			for i := 0; i < len(row); i += 4 {
+				r, g, b := fn(float64(row[i]>>8), float64(row[i+1]>>8), float64(row[i+2]>>8))
+				pix[i+0] = uint8(r)
+				pix[i+1] = uint8(g)
+				pix[i+2] = uint8(b)
+				pix[i+3] = uint8(row[i+3] >> 8)
+			}
 		}
-	}
+	})
 	return out
 }
 
-// Helper to decode an image from an io.Reader
+// Helper to decode an image from an io.Reader, turning it upright according to its
+// EXIF orientation and converting it to sRGB; see DecodeImageProfile
 func DecodeImage(r io.Reader) (image.Image, error) {
-	return imaging.Decode(r)
+	img, _, err := DecodeImageProfile(r)
+	return img, err
+}
+
+// DecodeImageProfile decodes an image and converts it to sRGB, the working space of
+// every operation, from the color profile embedded in it. The profile is returned
+// when a conversion was made. Like browsers, it treats images whose profile cannot
+// be read as sRGB. HEIF and AVIF files are handled the same way once a decoder for
+// them has been registered with image.RegisterFormat.
+func DecodeImageProfile(r io.Reader) (image.Image, *ColorProfile, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, nil, err
+	}
+	profile, err := ReadColorProfile(data)
+	if err != nil || profile == nil || profile.IsSRGB() {
+		return img, nil, nil
+	}
+	return profile.Convert(img), profile, nil
 }
 
 // Helper to encode an image to JPEG in a buffer
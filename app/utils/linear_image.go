@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+)
+
+// LinearImage holds premultiplied RGBA in linear light, one float32 per channel in [0,1].
+// Filters that average or weight colors work on it so results are gamma-correct.
+type LinearImage struct {
+	Pix    []float32
+	Stride int
+	Rect   image.Rectangle
+	// HighBitDepth records that the source had 16 bits per channel, so Image keeps them
+	HighBitDepth bool
+}
+
+// sRGB decode table for 8-bit values and encode table indexed by linear value * 65535
+var (
+	srgb8ToLinear  [256]float32
+	linearToSRGB16 [65536]uint8
+)
+
+func init() {
+	for i := range srgb8ToLinear {
+		srgb8ToLinear[i] = float32(SRGBToLinear(float64(i) / 255))
+	}
+	for i := range linearToSRGB16 {
+		linearToSRGB16[i] = uint8(LinearToSRGB(float64(i)/65535)*255 + 0.5)
+	}
+}
+
+// NewLinearImage returns a transparent linear image with the given bounds
+func NewLinearImage(r image.Rectangle) *LinearImage {
+	return &LinearImage{
+		Pix:    make([]float32, 4*r.Dx()*r.Dy()),
+		Stride: 4 * r.Dx(),
+		Rect:   r,
+	}
+}
+
+// Bounds returns the domain of the image
+func (p *LinearImage) Bounds() image.Rectangle {
+	return p.Rect
+}
+
+// PixOffset returns the index of the first element of Pix for the pixel at (x, y)
+func (p *LinearImage) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+}
+
+// DecodeSRGB16 converts a 16-bit gamma-encoded sRGB component to linear light
+func DecodeSRGB16(v uint16) float32 {
+	if v%257 == 0 {
+		return srgb8ToLinear[v/257]
+	}
+	return float32(SRGBToLinear(float64(v) / 0xffff))
+}
+
+// EncodeSRGB8 converts a linear-light component to an 8-bit gamma-encoded sRGB value
+func EncodeSRGB8(v float32) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 1 {
+		return 255
+	}
+	return linearToSRGB16[int(v*65535+0.5)]
+}
+
+// IsHighBitDepth reports whether the image stores 16 bits per channel
+func IsHighBitDepth(img image.Image) bool {
+	switch img.ColorModel() {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model:
+		return true
+	}
+	return false
+}
+
+// ToLinear decodes any image into linear light
+func ToLinear(img image.Image) *LinearImage {
+	bounds := img.Bounds()
+	out := NewLinearImage(bounds)
+	out.HighBitDepth = IsHighBitDepth(img)
+
+	parallelRows(bounds.Min.Y, bounds.Max.Y, func(y0, y1 int) {
+		row := make([]uint32, 4*bounds.Dx())
+		for y := y0; y < y1; y++ {
+			premultipliedRow(img, y, bounds.Min.X, bounds.Max.X, row)
+			pix := out.Pix[out.PixOffset(bounds.Min.X, y):]
+			for i := 0; i < len(row); i += 4 {
+				r, g, b := unpremultiply16(row[i], row[i+1], row[i+2], row[i+3])
+				a := float32(row[i+3]) / 0xffff
+				pix[i+0] = DecodeSRGB16(uint16(r)) * a
+				pix[i+1] = DecodeSRGB16(uint16(g)) * a
+				pix[i+2] = DecodeSRGB16(uint16(b)) * a
+				pix[i+3] = a
+			}
+		}
+	})
+	return out
+}
+
+// ToSRGB encodes the image back to 8-bit sRGB with straight alpha
+func (p *LinearImage) ToSRGB() *image.NRGBA {
+	out := image.NewNRGBA(p.Rect)
+
+	parallelRows(p.Rect.Min.Y, p.Rect.Max.Y, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			i := p.PixOffset(p.Rect.Min.X, y)
+			j := out.PixOffset(p.Rect.Min.X, y)
+			for x := p.Rect.Min.X; x < p.Rect.Max.X; x++ {
+				a := p.Pix[i+3]
+				if a > 0 {
+					out.Pix[j+0] = EncodeSRGB8(p.Pix[i+0] / a)
+					out.Pix[j+1] = EncodeSRGB8(p.Pix[i+1] / a)
+					out.Pix[j+2] = EncodeSRGB8(p.Pix[i+2] / a)
+					out.Pix[j+3] = uint8(clampUnit(float64(a))*255 + 0.5)
+				}
+				i += 4
+				j += 4
+			}
+		}
+	})
+	return out
+}
+
+// ToSRGB16 encodes the image back to 16-bit sRGB with straight alpha
+func (p *LinearImage) ToSRGB16() *image.NRGBA64 {
+	out := image.NewNRGBA64(p.Rect)
+	encode := func(v float32) uint16 {
+		return toUint16(LinearToSRGB(clampUnit(float64(v))))
+	}
+
+	parallelRows(p.Rect.Min.Y, p.Rect.Max.Y, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			i := p.PixOffset(p.Rect.Min.X, y)
+			j := out.PixOffset(p.Rect.Min.X, y)
+			for x := p.Rect.Min.X; x < p.Rect.Max.X; x++ {
+				if a := p.Pix[i+3]; a > 0 {
+					putNRGBA64(out.Pix[j:], encode(p.Pix[i]/a), encode(p.Pix[i+1]/a), encode(p.Pix[i+2]/a), toUint16(float64(a)))
+				}
+				i += 4
+				j += 8
+			}
+		}
+	})
+	return out
+}
+
+// Image encodes the image back to sRGB at the bit depth of its source
+func (p *LinearImage) Image() image.Image {
+	if p.HighBitDepth {
+		return p.ToSRGB16()
+	}
+	return p.ToSRGB()
+}
@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestSRGBTransferFunctions(t *testing.T) {
+	// Reference values from the IEC 61966-2-1 piecewise definition
+	const tolerance = 1e-7
+	tests := []struct {
+		encoded, linear float64
+	}{
+		{0, 0},
+		{0.04045, 0.0031308},
+		{128.0 / 255, 0.21586050},
+		{0.5, 0.21404114},
+		{0.73535698, 0.5},
+		{0.46135613, 0.18},
+		{1, 1},
+	}
+	for _, tt := range tests {
+		if got := SRGBToLinear(tt.encoded); math.Abs(got-tt.linear) > tolerance {
+			t.Errorf("SRGBToLinear(%v) = %v, want %v", tt.encoded, got, tt.linear)
+		}
+		if got := LinearToSRGB(tt.linear); math.Abs(got-tt.encoded) > tolerance {
+			t.Errorf("LinearToSRGB(%v) = %v, want %v", tt.linear, got, tt.encoded)
+		}
+	}
+}
+
+func TestSRGB8RoundTrip(t *testing.T) {
+	for v := 0; v < 256; v++ {
+		if got := EncodeSRGB8(DecodeSRGB16(uint16(v * 257))); got != uint8(v) {
+			t.Errorf("8-bit value %d round-trips to %d", v, got)
+		}
+	}
+}
+
+func TestConvertToGrayscaleLuminance(t *testing.T) {
+	// Rec. 709 relative luminance computed in linear light and re-encoded as sRGB,
+	// allowing one level of 8-bit rounding
+	tests := []struct {
+		in   color.NRGBA
+		want float64
+	}{
+		{color.NRGBA{255, 0, 0, 255}, 127.10},
+		{color.NRGBA{0, 255, 0, 255}, 219.93},
+		{color.NRGBA{0, 0, 255, 255}, 75.96},
+		{color.NRGBA{255, 255, 0, 255}, 246.73},
+		{color.NRGBA{128, 64, 200, 255}, 98.81},
+		{color.NRGBA{255, 255, 255, 255}, 255},
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, len(tests), 1))
+	for i, tt := range tests {
+		img.SetNRGBA(i, 0, tt.in)
+	}
+	gray := ConvertToGrayscale(img).(*image.NRGBA)
+	for i, tt := range tests {
+		got := gray.NRGBAAt(i, 0)
+		if got.R != got.G || got.G != got.B {
+			t.Errorf("%v: output %v is not gray", tt.in, got)
+		}
+		if math.Abs(float64(got.R)-tt.want) > 1 {
+			t.Errorf("%v: gray level %d, want %.2f ± 1", tt.in, got.R, tt.want)
+		}
+		if got.A != tt.in.A {
+			t.Errorf("%v: alpha %d, want %d", tt.in, got.A, tt.in.A)
+		}
+	}
+}
+
+// deepSource is an opaque 256×256 NRGBA64 image whose red channel takes every 16-bit value
+func deepSource() *image.NRGBA64 {
+	img := image.NewNRGBA64(image.Rect(0, 0, 256, 256))
+	for k := 0; k < 256*256; k++ {
+		img.SetNRGBA64(k%256, k/256, color.NRGBA64{uint16(k), uint16(0xffff - k), uint16(k * 7919), 0xffff})
+	}
+	return img
+}
+
+func TestHighBitDepthRoundTrip(t *testing.T) {
+	src := deepSource()
+	identity, err := ParseKernel("0,0,0; 0,1,0; 0,0,0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filters := map[string]func(image.Image) image.Image{
+		"linear light": func(img image.Image) image.Image { return ToLinear(img).Image() },
+		"convolution": func(img image.Image) image.Image {
+			out, err := Convolve(img, identity, EdgeClamp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return out
+		},
+		"shear rotation":  func(img image.Image) image.Image { return RotateShear(img, 360, ShearRotateOptions{Antialias: true}) },
+		"flips":           func(img image.Image) image.Image { return FlipVertical(FlipHorizontal(QuarterTurn(img, 2))) },
+		"color transform": func(img image.Image) image.Image { return ApplyColorTransform(img, matrixTransform(identityMatrix)) },
+		"legacy filter":   func(img image.Image) image.Image { return SimulateColorBlindness(img, identityMatrix) },
+	}
+
+	for name, filter := range filters {
+		out := filter(src)
+		if !IsHighBitDepth(out) {
+			t.Errorf("%s: returned %T, want 16 bits per channel", name, out)
+			continue
+		}
+		mismatches := 0
+		for y := 0; y < 256; y++ {
+			for x := 0; x < 256; x++ {
+				want := src.NRGBA64At(x, y)
+				if got := color.NRGBA64Model.Convert(out.At(x, y)).(color.NRGBA64); got != want {
+					if mismatches++; mismatches <= 3 {
+						t.Errorf("%s: pixel %d,%d is %v, want %v", name, x, y, got, want)
+					}
+				}
+			}
+		}
+		if mismatches > 3 {
+			t.Errorf("%s: %d pixels changed in total", name, mismatches)
+		}
+	}
+}
+
+func TestGrayscaleKeepsHighBitDepthGray(t *testing.T) {
+	img := image.NewNRGBA64(image.Rect(0, 0, 256, 256))
+	for k := 0; k < 256*256; k++ {
+		img.SetNRGBA64(k%256, k/256, color.NRGBA64{uint16(k), uint16(k), uint16(k), 0xffff})
+	}
+	out, ok := ConvertToGrayscale(img).(*image.NRGBA64)
+	if !ok {
+		t.Fatalf("ConvertToGrayscale returned %T, want *image.NRGBA64", out)
+	}
+	for k := 0; k < 256*256; k++ {
+		// Gray is its own luminance, so every 16-bit level comes back unchanged
+		if got, want := out.NRGBA64At(k%256, k/256), img.NRGBA64At(k%256, k/256); got != want {
+			t.Fatalf("gray %d became %v", k, got)
+		}
+	}
+}
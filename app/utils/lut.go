@@ -0,0 +1,237 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"sync"
+)
+
+const (
+	// DefaultLUTSize is the number of grid points along each axis of a baked LUT
+	DefaultLUTSize = 33
+	// MaxLUTSize is the largest LUT size accepted, which keeps a table under 30 MB
+	MaxLUTSize = 129
+	// Number of baked LUTs kept in the cache
+	maxCachedLUTs = 64
+)
+
+// Interpolation selects how a LUT is sampled between grid points
+type Interpolation string
+
+const (
+	InterpolationTrilinear   Interpolation = "trilinear"
+	InterpolationTetrahedral Interpolation = "tetrahedral"
+)
+
+// ParseInterpolation validates an interpolation name, defaulting to tetrahedral
+func ParseInterpolation(name string) (Interpolation, error) {
+	switch interp := Interpolation(name); interp {
+	case "":
+		return InterpolationTetrahedral, nil
+	case InterpolationTrilinear, InterpolationTetrahedral:
+		return interp, nil
+	}
+	return "", fmt.Errorf("unknown interpolation %q", name)
+}
+
+// LUT3D is a color transform sampled on a Size×Size×Size grid of gamma-encoded
+// sRGB inputs. Data holds the output RGB of each grid point with red varying
+// fastest, the order used by .cube files.
+type LUT3D struct {
+	Title string
+	Size  int
+	Data  []float32
+}
+
+// BakeLUT samples a color transform on a size³ grid
+func BakeLUT(transform ColorTransform, size int) (*LUT3D, error) {
+	if size < 2 || size > MaxLUTSize {
+		return nil, fmt.Errorf("LUT size must be between 2 and %d, got %d", MaxLUTSize, size)
+	}
+
+	lut := &LUT3D{Size: size, Data: make([]float32, 3*size*size*size)}
+	scale := 1 / float64(size-1)
+	parallelRows(0, size, func(b0, b1 int) {
+		for b := b0; b < b1; b++ {
+			for g := 0; g < size; g++ {
+				for r := 0; r < size; r++ {
+					i := lut.index(r, g, b)
+					outR, outG, outB := transform(float64(r)*scale, float64(g)*scale, float64(b)*scale)
+					lut.Data[i] = float32(clampUnit(outR))
+					lut.Data[i+1] = float32(clampUnit(outG))
+					lut.Data[i+2] = float32(clampUnit(outB))
+				}
+			}
+		}
+	})
+	return lut, nil
+}
+
+// index returns the position in Data of the grid point (r, g, b)
+func (l *LUT3D) index(r, g, b int) int {
+	return 3 * ((b*l.Size+g)*l.Size + r)
+}
+
+// at returns the output color of the grid point (r, g, b)
+func (l *LUT3D) at(r, g, b int) [3]float64 {
+	i := l.index(r, g, b)
+	return [3]float64{float64(l.Data[i]), float64(l.Data[i+1]), float64(l.Data[i+2])}
+}
+
+// Lookup maps a color with components in [0,1] through the LUT
+func (l *LUT3D) Lookup(r, g, b float64, interp Interpolation) (float64, float64, float64) {
+	// Grid cell and position inside it along each axis
+	cell := func(v float64) (int, float64) {
+		v = clampUnit(v) * float64(l.Size-1)
+		i := min(int(v), l.Size-2)
+		return i, v - float64(i)
+	}
+	ri, fr := cell(r)
+	gi, fg := cell(g)
+	bi, fb := cell(b)
+
+	var out [3]float64
+	if interp == InterpolationTrilinear {
+		for corner := 0; corner < 8; corner++ {
+			dr, dg, db := corner&1, corner>>1&1, corner>>2&1
+			w := weight(fr, dr) * weight(fg, dg) * weight(fb, db)
+			c := l.at(ri+dr, gi+dg, bi+db)
+			for k := range out {
+				out[k] += w * c[k]
+			}
+		}
+		return out[0], out[1], out[2]
+	}
+
+	// Tetrahedral: split the cube along its main diagonal into six tetrahedra and
+	// blend the four corners of the one containing the point
+	c000 := l.at(ri, gi, bi)
+	c111 := l.at(ri+1, gi+1, bi+1)
+	var c1, c2 [3]float64
+	var w0, w1, w2, w3 float64
+	switch {
+	case fr >= fg && fg >= fb:
+		c1, c2 = l.at(ri+1, gi, bi), l.at(ri+1, gi+1, bi)
+		w0, w1, w2, w3 = 1-fr, fr-fg, fg-fb, fb
+	case fr >= fb && fb >= fg:
+		c1, c2 = l.at(ri+1, gi, bi), l.at(ri+1, gi, bi+1)
+		w0, w1, w2, w3 = 1-fr, fr-fb, fb-fg, fg
+	case fb >= fr && fr >= fg:
+		c1, c2 = l.at(ri, gi, bi+1), l.at(ri+1, gi, bi+1)
+		w0, w1, w2, w3 = 1-fb, fb-fr, fr-fg, fg
+	case fg >= fr && fr >= fb:
+		c1, c2 = l.at(ri, gi+1, bi), l.at(ri+1, gi+1, bi)
+		w0, w1, w2, w3 = 1-fg, fg-fr, fr-fb, fb
+	case fg >= fb && fb >= fr:
+		c1, c2 = l.at(ri, gi+1, bi), l.at(ri, gi+1, bi+1)
+		w0, w1, w2, w3 = 1-fg, fg-fb, fb-fr, fr
+	default: // fb >= fg >= fr
+		c1, c2 = l.at(ri, gi, bi+1), l.at(ri, gi+1, bi+1)
+		w0, w1, w2, w3 = 1-fb, fb-fg, fg-fr, fr
+	}
+	for k := range out {
+		out[k] = w0*c000[k] + w1*c1[k] + w2*c2[k] + w3*c111[k]
+	}
+	return out[0], out[1], out[2]
+}
+
+// weight is the linear interpolation weight of the lower (d = 0) or upper (d = 1) grid point
+func weight(f float64, d int) float64 {
+	if d == 0 {
+		return 1 - f
+	}
+	return f
+}
+
+// Transform returns the LUT as a ColorTransform
+func (l *LUT3D) Transform(interp Interpolation) ColorTransform {
+	return func(r, g, b float64) (float64, float64, float64) {
+		return l.Lookup(r, g, b, interp)
+	}
+}
+
+// Apply maps every pixel of the image through the LUT, keeping alpha
+func (l *LUT3D) Apply(img image.Image, interp Interpolation) image.Image {
+	return ApplyColorTransform(img, l.Transform(interp))
+}
+
+// WriteCube writes the LUT in the Adobe/Resolve .cube format
+func (l *LUT3D) WriteCube(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if l.Title != "" {
+		fmt.Fprintf(bw, "TITLE %q\n", l.Title)
+	}
+	fmt.Fprintf(bw, "LUT_3D_SIZE %d\n", l.Size)
+	fmt.Fprintf(bw, "DOMAIN_MIN 0.0 0.0 0.0\n")
+	fmt.Fprintf(bw, "DOMAIN_MAX 1.0 1.0 1.0\n")
+	for i := 0; i < len(l.Data); i += 3 {
+		fmt.Fprintf(bw, "%.6f %.6f %.6f\n", l.Data[i], l.Data[i+1], l.Data[i+2])
+	}
+	return bw.Flush()
+}
+
+// lutCache keeps baked LUTs by transform name and size
+var lutCache = struct {
+	sync.Mutex
+	luts map[string]*LUT3D
+}{luts: map[string]*LUT3D{}}
+
+// CachedLUT returns the LUT baked for the named transform, baking it with
+// newTransform on first use. The name must identify the transform and all of
+// its parameters.
+func CachedLUT(name string, size int, newTransform func() (ColorTransform, error)) (*LUT3D, error) {
+	key := fmt.Sprintf("%s@%d", name, size)
+	lutCache.Lock()
+	lut, ok := lutCache.luts[key]
+	lutCache.Unlock()
+	if ok {
+		return lut, nil
+	}
+
+	transform, err := newTransform()
+	if err != nil {
+		return nil, err
+	}
+	if lut, err = BakeLUT(transform, size); err != nil {
+		return nil, err
+	}
+	lut.Title = name
+
+	lutCache.Lock()
+	defer lutCache.Unlock()
+	if len(lutCache.luts) >= maxCachedLUTs {
+		// Drop an arbitrary entry; baking again is cheap compared to unbounded growth
+		for k := range lutCache.luts {
+			delete(lutCache.luts, k)
+			break
+		}
+	}
+	lutCache.luts[key] = lut
+	return lut, nil
+}
+
+// SimulationLUT returns the cached LUT of a deficiency simulation
+func SimulationLUT(d Deficiency, model SimulationModel, severity float64, size int) (*LUT3D, error) {
+	severity = roundAmount(severity)
+	name := fmt.Sprintf("%s simulation (%s, severity %g)", d, model, severity)
+	return CachedLUT(name, size, func() (ColorTransform, error) {
+		return NewSimulation(d, model, severity)
+	})
+}
+
+// DaltonizationLUT returns the cached LUT of a daltonization
+func DaltonizationLUT(d Deficiency, strength float64, size int) (*LUT3D, error) {
+	strength = roundAmount(strength)
+	name := fmt.Sprintf("%s daltonization (strength %g)", d, strength)
+	return CachedLUT(name, size, func() (ColorTransform, error) {
+		return NewDaltonization(d, strength)
+	})
+}
+
+// roundAmount rounds a severity or strength so nearly equal values share a cache entry
+func roundAmount(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
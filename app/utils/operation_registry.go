@@ -0,0 +1,239 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// ParamKind is the JSON type of an operation parameter
+type ParamKind string
+
+const (
+	ParamNumber  ParamKind = "number"
+	ParamString  ParamKind = "string"
+	ParamBoolean ParamKind = "boolean"
+)
+
+// Param describes one typed parameter an operation accepts
+type Param struct {
+	Name        string      `json:"name"`
+	Kind        ParamKind   `json:"type"`
+	Default     interface{} `json:"default,omitempty"`
+	Min         *float64    `json:"min,omitempty"`
+	Max         *float64    `json:"max,omitempty"`
+	Enum        []string    `json:"enum,omitempty"`
+	Description string      `json:"description"`
+	// EnumFunc, when set, lists the allowed values in place of Enum, for choices
+	// that can be registered after the operation
+	EnumFunc func() []string `json:"-"`
+}
+
+// RunFunc applies an operation to an image. Analysis operations also return a
+// JSON-serialisable report.
+type RunFunc func(img image.Image, p Params) (image.Image, interface{}, error)
+
+// Operation is an image operation shared by the HTTP pipeline and the UDP protocol.
+// Code is the numeric operation type used in UDP packets.
+type Operation struct {
+	Name   string  `json:"name"`
+	Code   uint32  `json:"code"`
+	Params []Param `json:"params"`
+	Run    RunFunc `json:"-"`
+	// ChangesSize reports whether the output can differ in size from the input with
+	// the given parameters; nil means never
+	ChangesSize func(p Params) bool `json:"-"`
+	// Check reports a problem with a combination of otherwise valid parameters;
+	// nil means every combination is accepted
+	Check func(p Params) error `json:"-"`
+}
+
+// alwaysChangesSize is the ChangesSize of operations whose output size is set by their parameters
+func alwaysChangesSize(Params) bool { return true }
+
+var (
+	operationsByName = map[string]Operation{}
+	operationsByCode = map[uint32]Operation{}
+)
+
+// RegisterOperation adds an operation to the registry, panicking on a duplicate name or code
+func RegisterOperation(op Operation) {
+	if _, ok := operationsByName[op.Name]; ok {
+		panic(fmt.Sprintf("operation %q registered twice", op.Name))
+	}
+	if other, ok := operationsByCode[op.Code]; ok {
+		panic(fmt.Sprintf("operation code %d used by both %q and %q", op.Code, other.Name, op.Name))
+	}
+	if op.Params == nil {
+		op.Params = []Param{}
+	}
+	operationsByName[op.Name] = op
+	operationsByCode[op.Code] = op
+}
+
+// LookupOperation finds an operation by name
+func LookupOperation(name string) (Operation, bool) {
+	op, ok := operationsByName[name]
+	return op, ok
+}
+
+// OperationByCode finds an operation by its numeric code
+func OperationByCode(code uint32) (Operation, bool) {
+	op, ok := operationsByCode[code]
+	return op, ok
+}
+
+// Operations lists every registered operation ordered by code, with the current
+// values of any enums listed by an EnumFunc
+func Operations() []Operation {
+	ops := make([]Operation, 0, len(operationsByName))
+	for _, op := range operationsByName {
+		op.Params = append([]Param(nil), op.Params...)
+		for i, param := range op.Params {
+			op.Params[i].Enum = param.enum()
+		}
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Code < ops[j].Code })
+	return ops
+}
+
+// ValidateParams checks raw parameters against the operation's schema and fills in
+// defaults. Every problem is returned, sorted by message.
+func ValidateParams(op Operation, raw map[string]interface{}) (Params, []error) {
+	var errs []error
+	params := Params{}
+	known := map[string]bool{}
+
+	for _, param := range op.Params {
+		known[param.Name] = true
+		value, given := raw[param.Name]
+		if !given {
+			if param.Default != nil {
+				params[param.Name] = param.Default
+			}
+			continue
+		}
+		v, err := param.Validate(value)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		params[param.Name] = v
+	}
+
+	for name := range raw {
+		if !known[name] {
+			errs = append(errs, fmt.Errorf("unknown parameter %q", name))
+		}
+	}
+	if len(errs) == 0 && op.Check != nil {
+		if err := op.Check(params); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return params, errs
+}
+
+// Params holds the validated parameters of a step, with defaults filled in
+type Params map[string]interface{}
+
+// Has reports whether the parameter was given or has a default
+func (p Params) Has(name string) bool {
+	_, ok := p[name]
+	return ok
+}
+
+// Number returns a numeric parameter, or 0 when absent
+func (p Params) Number(name string) float64 {
+	v, _ := p[name].(float64)
+	return v
+}
+
+// String returns a string parameter, or "" when absent
+func (p Params) String(name string) string {
+	v, _ := p[name].(string)
+	return v
+}
+
+// Bool returns a boolean parameter, or false when absent
+func (p Params) Bool(name string) bool {
+	v, _ := p[name].(bool)
+	return v
+}
+
+func paramBound(v float64) *float64 {
+	return &v
+}
+
+// enum returns the allowed values of a string parameter, none meaning any
+func (p Param) enum() []string {
+	if p.EnumFunc != nil {
+		return p.EnumFunc()
+	}
+	return p.Enum
+}
+
+// Validate checks a raw value against the parameter schema
+func (p Param) Validate(raw interface{}) (interface{}, error) {
+	switch p.Kind {
+	case ParamNumber:
+		v, ok := raw.(float64)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a number", p.Name)
+		}
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("%s must be a finite number", p.Name)
+		}
+		if p.Min != nil && v < *p.Min {
+			return nil, fmt.Errorf("%s must be at least %v", p.Name, *p.Min)
+		}
+		if p.Max != nil && v > *p.Max {
+			return nil, fmt.Errorf("%s must be at most %v", p.Name, *p.Max)
+		}
+		return v, nil
+	case ParamBoolean:
+		v, ok := raw.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s must be true or false", p.Name)
+		}
+		return v, nil
+	case ParamString:
+		v, ok := raw.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a string", p.Name)
+		}
+		if enum := p.enum(); len(enum) > 0 {
+			for _, allowed := range enum {
+				if v == allowed {
+					return v, nil
+				}
+			}
+			return nil, fmt.Errorf("%s must be one of %v", p.Name, enum)
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("%s has unsupported type %q", p.Name, p.Kind)
+}
+
+// ParseString converts a query-string value to the parameter's JSON type
+func (p Param) ParseString(s string) (interface{}, error) {
+	switch p.Kind {
+	case ParamNumber:
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a number, got %q", p.Name, s)
+		}
+		return v, nil
+	case ParamBoolean:
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false, got %q", p.Name, s)
+		}
+		return v, nil
+	}
+	return s, nil
+}
@@ -0,0 +1,446 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Numeric operation codes used by the UDP protocol
+const (
+	OpFlip                  = 1
+	OpRotate                = 2
+	OpRotateShear           = 3
+	OpGrayscale             = 4
+	OpBoxBlur               = 5
+	OpGaussianBlur          = 6
+	OpEdgeDetection         = 7
+	OpProtanopia            = 8
+	OpDeuteranopia          = 9
+	OpTritanopia            = 10
+	OpProtanomaly           = 11
+	OpDeuteranomaly         = 12
+	OpTritanomaly           = 13
+	OpAchromatopsia         = 14
+	OpMonochromacy          = 15
+	OpDaltonize             = 16
+	OpDaltonizeProtanopia   = 17
+	OpDaltonizeDeuteranopia = 18
+	OpDaltonizeTritanopia   = 19
+	OpRecolor               = 20
+	OpConfusionMap          = 21
+	OpWCAGAudit             = 22
+	OpGrid                  = 23
+	OpConvolve              = 24
+	OpFlipHorizontal        = 25
+	OpTranspose             = 26
+	OpCrop                  = 27
+	OpResize                = 28
+	OpAffine                = 29
+	OpPerspective           = 30
+)
+
+var (
+	angleParam = Param{Name: "angle", Kind: ParamNumber, Default: 0.0, Min: paramBound(-360), Max: paramBound(360),
+		Description: "Rotation angle in degrees"}
+	modelParam = Param{Name: "model", Kind: ParamString, Default: string(ModelLegacy),
+		Enum:        []string{string(ModelLegacy), string(ModelBrettel), string(ModelVienot), string(ModelMachado)},
+		Description: "Color vision simulation model"}
+	severityParam = Param{Name: "severity", Kind: ParamNumber, Min: paramBound(0), Max: paramBound(1),
+		Description: "0 is normal vision, 1 is full dichromacy"}
+	strengthParam = Param{Name: "strength", Kind: ParamNumber, Default: DefaultDaltonizeStrength, Min: paramBound(0), Max: paramBound(1),
+		Description: "How much of the lost contrast to restore"}
+	lutParam = Param{Name: "lut", Kind: ParamString, Default: "none",
+		Enum:        []string{"none", string(InterpolationTrilinear), string(InterpolationTetrahedral)},
+		Description: "Apply through a cached 33³ LUT with this interpolation, faster but approximate"}
+	edgeParam = Param{Name: "edge", Kind: ParamString, Default: string(EdgeClamp), Enum: edgeModeNames(),
+		Description: "How pixels beyond the border are sampled"}
+	fillParam = Param{Name: "fill", Kind: ParamString, Default: "transparent",
+		Description: "Color of the uncovered areas as #rrggbb, or transparent"}
+	deficiencyParam = Param{Name: "deficiency", Kind: ParamString, Default: "protan",
+		Enum: []string{"protan", "deutan", "tritan"}, Description: "Deficiency to correct or analyse for"}
+)
+
+// edgeModeNames lists the edge modes for the edge parameter's enum
+func edgeModeNames() []string {
+	names := make([]string, len(EdgeModes))
+	for i, mode := range EdgeModes {
+		names[i] = string(mode)
+	}
+	return names
+}
+
+// edgeOperatorNames lists the edge operators for the operator parameter's enum
+func edgeOperatorNames() []string {
+	names := make([]string, len(EdgeOperators))
+	for i, op := range EdgeOperators {
+		names[i] = string(op)
+	}
+	return names
+}
+
+// edgeDetectionOp detects edges, optionally drawing them over the original or a simulated image
+func edgeDetectionOp(img image.Image, p Params) (image.Image, interface{}, error) {
+	lineColor, err := ParseHexColor(p.String("color"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.String("overlay") == "simulated" {
+		transform, err := VisionTransform(p.String("vision"), SimulationModel(p.String("model")))
+		if err != nil {
+			return nil, nil, err
+		}
+		img = ApplyColorTransform(img, transform)
+	}
+
+	edges, err := DetectEdges(img, EdgeOptions{
+		Operator: EdgeOperator(p.String("operator")),
+		Sigma:    p.Number("sigma"),
+		Low:      p.Number("low"),
+		High:     p.Number("high"),
+		Edge:     EdgeMode(p.String("edge")),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.String("overlay") == "none" {
+		return edges, nil, nil
+	}
+	return OverlayEdges(img, edges, lineColor), nil, nil
+}
+
+// simpleOp wraps an operation without parameters or errors
+func simpleOp(fn func(image.Image) image.Image) RunFunc {
+	return func(img image.Image, p Params) (image.Image, interface{}, error) {
+		return fn(img), nil, nil
+	}
+}
+
+// applyLUT maps the image through a cached LUT with the interpolation named by the lut parameter
+func applyLUT(img image.Image, p Params, lut func() (*LUT3D, error)) (image.Image, interface{}, error) {
+	l, err := lut()
+	if err != nil {
+		return nil, nil, err
+	}
+	return l.Apply(img, Interpolation(p.String("lut"))), nil, nil
+}
+
+// matrixSimulationOp wraps a fixed legacy simulation matrix
+func matrixSimulationOp(matrix [3][3]float64) RunFunc {
+	return simpleOp(func(img image.Image) image.Image {
+		return SimulateColorBlindness(img, matrix)
+	})
+}
+
+// dichromacyOp simulates full dichromacy, or a weaker deficiency when a severity is given
+func dichromacyOp(d Deficiency) RunFunc {
+	return func(img image.Image, p Params) (image.Image, interface{}, error) {
+		model := SimulationModel(p.String("model"))
+		severity := 1.0
+		if p.Has("severity") {
+			severity = p.Number("severity")
+		}
+		if p.String("lut") != "none" {
+			return applyLUT(img, p, func() (*LUT3D, error) {
+				return SimulationLUT(d, model, severity, DefaultLUTSize)
+			})
+		}
+		out, err := SimulateDeficiency(img, d, model, severity)
+		return out, nil, err
+	}
+}
+
+// anomalyOp keeps the fixed legacy anomaly matrices unless a severity or physiological model was requested
+func anomalyOp(d Deficiency, legacy [3][3]float64) RunFunc {
+	return func(img image.Image, p Params) (image.Image, interface{}, error) {
+		model := SimulationModel(p.String("model"))
+		severity := DefaultAnomalySeverity
+		useLUT := p.String("lut") != "none"
+		if p.Has("severity") {
+			severity = p.Number("severity")
+		} else if model == ModelLegacy {
+			if useLUT {
+				return applyLUT(img, p, func() (*LUT3D, error) {
+					return CachedLUT(d.String()+" legacy anomaly", DefaultLUTSize, func() (ColorTransform, error) {
+						return matrixTransform(legacy), nil
+					})
+				})
+			}
+			return SimulateColorBlindness(img, legacy), nil, nil
+		}
+		if useLUT {
+			return applyLUT(img, p, func() (*LUT3D, error) {
+				return SimulationLUT(d, model, severity, DefaultLUTSize)
+			})
+		}
+		out, err := SimulateDeficiency(img, d, model, severity)
+		return out, nil, err
+	}
+}
+
+func daltonizationOp(d Deficiency) RunFunc {
+	return func(img image.Image, p Params) (image.Image, interface{}, error) {
+		return daltonize(img, p, d, p.Number("strength"))
+	}
+}
+
+// daltonize corrects the image for a deficiency, through a cached LUT when the lut parameter asks for one
+func daltonize(img image.Image, p Params, d Deficiency, strength float64) (image.Image, interface{}, error) {
+	if p.String("lut") != "none" {
+		return applyLUT(img, p, func() (*LUT3D, error) {
+			return DaltonizationLUT(d, strength, DefaultLUTSize)
+		})
+	}
+	out, err := DaltonizeDeficiency(img, d, strength)
+	return out, nil, err
+}
+
+// paramFill returns the fill parameter as a color, or nil for transparent
+func paramFill(p Params) (color.Color, error) {
+	fill := p.String("fill")
+	if fill == "transparent" {
+		return nil, nil
+	}
+	c, err := ParseHexColor(fill)
+	if err != nil {
+		return nil, err
+	}
+	return color.NRGBA{toByte(c[0]), toByte(c[1]), toByte(c[2]), 255}, nil
+}
+
+// warpOp applies an affine or perspective transform read from the matrix parameter
+func warpOp(rows int) RunFunc {
+	return func(img image.Image, p Params) (image.Image, interface{}, error) {
+		m, err := ParseMatrix(p.String("matrix"), rows, 3)
+		if err != nil {
+			return nil, nil, err
+		}
+		fill, err := paramFill(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts := WarpOptions{Fit: p.Bool("fit"), Fill: fill, Bilinear: p.String("interpolation") == "bilinear"}
+		if rows == 2 {
+			out, err := AffineTransform(img, [2][3]float64{[3]float64(m[0]), [3]float64(m[1])}, opts)
+			return out, nil, err
+		}
+		out, err := PerspectiveTransform(img, [3][3]float64{[3]float64(m[0]), [3]float64(m[1]), [3]float64(m[2])}, opts)
+		return out, nil, err
+	}
+}
+
+// rotationChangesSize reports whether a rotation turns the canvas by anything but a half turn
+func rotationChangesSize(p Params) bool {
+	return math.Mod(p.Number("angle"), 180) != 0
+}
+
+// warpChangesSize reports whether a warp fits the canvas to the transformed image
+func warpChangesSize(p Params) bool {
+	return p.Bool("fit")
+}
+
+func paramDeficiency(p Params) Deficiency {
+	d, _ := ParseDeficiency(p.String("deficiency"))
+	return d
+}
+
+func init() {
+	RegisterOperation(Operation{Name: "flip", Code: OpFlip, Run: simpleOp(FlipImage)})
+	RegisterOperation(Operation{Name: "rotate", Code: OpRotate, Params: []Param{angleParam},
+		Run: func(img image.Image, p Params) (image.Image, interface{}, error) {
+			return RotateImage(img, p.Number("angle")), nil, nil
+		}, ChangesSize: rotationChangesSize})
+	RegisterOperation(Operation{Name: "rotate_shear", Code: OpRotateShear, Params: []Param{angleParam, fillParam,
+		{Name: "antialias", Kind: ParamBoolean, Default: true, Description: "Resample the shears at subpixel offsets"},
+	}, Run: func(img image.Image, p Params) (image.Image, interface{}, error) {
+		fill, err := paramFill(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		return RotateShear(img, p.Number("angle"), ShearRotateOptions{Fill: fill, Antialias: p.Bool("antialias")}), nil, nil
+	}, ChangesSize: rotationChangesSize})
+	RegisterOperation(Operation{Name: "grayscale", Code: OpGrayscale, Run: simpleOp(ConvertToGrayscale)})
+	RegisterOperation(Operation{Name: "flip_horizontal", Code: OpFlipHorizontal, Run: simpleOp(FlipHorizontal)})
+	RegisterOperation(Operation{Name: "transpose", Code: OpTranspose, Run: simpleOp(Transpose), ChangesSize: alwaysChangesSize})
+	RegisterOperation(Operation{Name: "crop", Code: OpCrop, Params: []Param{
+		{Name: "x", Kind: ParamNumber, Default: 0.0, Min: paramBound(0), Max: paramBound(math.MaxInt32), Description: "Left edge in pixels"},
+		{Name: "y", Kind: ParamNumber, Default: 0.0, Min: paramBound(0), Max: paramBound(math.MaxInt32), Description: "Top edge in pixels"},
+		{Name: "width", Kind: ParamNumber, Default: 0.0, Min: paramBound(0), Max: paramBound(MaxOutputDimension),
+			Description: "Width in pixels, 0 for the rest of the row"},
+		{Name: "height", Kind: ParamNumber, Default: 0.0, Min: paramBound(0), Max: paramBound(MaxOutputDimension),
+			Description: "Height in pixels, 0 for the rest of the column"},
+	}, Run: func(img image.Image, p Params) (image.Image, interface{}, error) {
+		x, y := int(p.Number("x")), int(p.Number("y"))
+		w, h := int(p.Number("width")), int(p.Number("height"))
+		if w == 0 {
+			w = img.Bounds().Dx() - x
+		}
+		if h == 0 {
+			h = img.Bounds().Dy() - y
+		}
+		out, err := Crop(img, image.Rect(x, y, x+w, y+h))
+		return out, nil, err
+	}, ChangesSize: alwaysChangesSize})
+	RegisterOperation(Operation{Name: "resize", Code: OpResize, Params: []Param{
+		{Name: "width", Kind: ParamNumber, Default: 0.0, Min: paramBound(0), Max: paramBound(MaxOutputDimension),
+			Description: "Width in pixels, 0 to keep the aspect ratio"},
+		{Name: "height", Kind: ParamNumber, Default: 0.0, Min: paramBound(0), Max: paramBound(MaxOutputDimension),
+			Description: "Height in pixels, 0 to keep the aspect ratio"},
+		{Name: "filter", Kind: ParamString, Default: "lanczos", Enum: ResampleFilterNames(), Description: "Resampling filter"},
+	}, Run: func(img image.Image, p Params) (image.Image, interface{}, error) {
+		filter, err := ParseResampleFilter(p.String("filter"))
+		if err != nil {
+			return nil, nil, err
+		}
+		out, err := Resize(img, int(p.Number("width")), int(p.Number("height")), filter)
+		return out, nil, err
+	}, ChangesSize: alwaysChangesSize})
+	warpParams := []Param{
+		{Name: "fit", Kind: ParamBoolean, Default: true, Description: "Resize the canvas to the transformed image"},
+		fillParam,
+		{Name: "interpolation", Kind: ParamString, Default: "bilinear", Enum: []string{"nearest", "bilinear"},
+			Description: "How source pixels are sampled"},
+	}
+	RegisterOperation(Operation{Name: "affine", Code: OpAffine, Params: append([]Param{
+		{Name: "matrix", Kind: ParamString, Default: "1,0,0; 0,1,0",
+			Description: "2×3 matrix mapping source to output pixel coordinates, rows separated by ';'"},
+	}, warpParams...), Run: warpOp(2), ChangesSize: warpChangesSize})
+	RegisterOperation(Operation{Name: "perspective", Code: OpPerspective, Params: append([]Param{
+		{Name: "matrix", Kind: ParamString, Default: "1,0,0; 0,1,0; 0,0,1",
+			Description: "3×3 homography mapping source to output pixel coordinates, rows separated by ';'"},
+	}, warpParams...), Run: warpOp(3), ChangesSize: warpChangesSize})
+	RegisterOperation(Operation{Name: "box_blur", Code: OpBoxBlur, Params: []Param{
+		{Name: "radius", Kind: ParamNumber, Default: 1.0, Min: paramBound(1), Max: paramBound(MaxKernelSize / 2),
+			Description: "Blur radius in pixels"},
+		edgeParam,
+	}, Run: func(img image.Image, p Params) (image.Image, interface{}, error) {
+		out, err := BoxBlur(img, int(p.Number("radius")), EdgeMode(p.String("edge")))
+		return out, nil, err
+	}})
+	RegisterOperation(Operation{Name: "gaussian_blur", Code: OpGaussianBlur, Params: []Param{
+		{Name: "sigma", Kind: ParamNumber, Min: paramBound(0.1), Max: paramBound(MaxKernelSize / 6),
+			Description: "Standard deviation in pixels; without it a 3×3 binomial kernel is used"},
+		{Name: "radius", Kind: ParamNumber, Default: 0.0, Min: paramBound(0), Max: paramBound(MaxKernelSize / 2),
+			Description: "Kernel radius in pixels, 0 for three standard deviations"},
+		edgeParam,
+	}, Run: func(img image.Image, p Params) (image.Image, interface{}, error) {
+		edge := EdgeMode(p.String("edge"))
+		if !p.Has("sigma") {
+			out, err := ConvolveSeparable(img, binomialKernel, binomialKernel, edge)
+			return out, nil, err
+		}
+		out, err := GaussianBlur(img, p.Number("sigma"), int(p.Number("radius")), edge)
+		return out, nil, err
+	}})
+	edgeDefaults := DefaultEdgeOptions()
+	RegisterOperation(Operation{Name: "edge_detection", Code: OpEdgeDetection, Params: []Param{
+		{Name: "operator", Kind: ParamString, Default: string(edgeDefaults.Operator), Enum: edgeOperatorNames(),
+			Description: "Edge detection algorithm"},
+		{Name: "sigma", Kind: ParamNumber, Default: edgeDefaults.Sigma, Min: paramBound(0.1), Max: paramBound(MaxKernelSize / 6),
+			Description: "Gaussian smoothing in pixels before the log and canny operators"},
+		{Name: "low", Kind: ParamNumber, Default: edgeDefaults.Low, Min: paramBound(0), Max: paramBound(1),
+			Description: "Canny low threshold, and the smallest log zero-crossing slope, as a fraction of the strongest"},
+		{Name: "high", Kind: ParamNumber, Default: edgeDefaults.High, Min: paramBound(0), Max: paramBound(1),
+			Description: "Canny high threshold as a fraction of the strongest gradient"},
+		edgeParam,
+		{Name: "overlay", Kind: ParamString, Default: "none", Enum: []string{"none", "original", "simulated"},
+			Description: "Draw the edges over the original image, or detect and draw them on the simulated image"},
+		{Name: "vision", Kind: ParamString, Default: "deuteranopia", Enum: VisionTypes,
+			Description: "Vision type simulated for the simulated overlay"},
+		modelParam,
+		{Name: "color", Kind: ParamString, Default: "#000000", Description: "Overlay line color as #rrggbb"},
+	}, Run: edgeDetectionOp})
+
+	RegisterOperation(Operation{Name: "protanopia", Code: OpProtanopia, Params: []Param{modelParam, severityParam, lutParam}, Run: dichromacyOp(Protan)})
+	RegisterOperation(Operation{Name: "deuteranopia", Code: OpDeuteranopia, Params: []Param{modelParam, severityParam, lutParam}, Run: dichromacyOp(Deutan)})
+	RegisterOperation(Operation{Name: "tritanopia", Code: OpTritanopia, Params: []Param{modelParam, severityParam, lutParam}, Run: dichromacyOp(Tritan)})
+	RegisterOperation(Operation{Name: "protanomaly", Code: OpProtanomaly, Params: []Param{modelParam, severityParam, lutParam},
+		Run: anomalyOp(Protan, ProtanomalyMatrix)})
+	RegisterOperation(Operation{Name: "deuteranomaly", Code: OpDeuteranomaly, Params: []Param{modelParam, severityParam, lutParam},
+		Run: anomalyOp(Deutan, DeuteranomalyMatrix)})
+	RegisterOperation(Operation{Name: "tritanomaly", Code: OpTritanomaly, Params: []Param{modelParam, severityParam, lutParam},
+		Run: anomalyOp(Tritan, TritanomalyMatrix)})
+	RegisterOperation(Operation{Name: "achromatopsia", Code: OpAchromatopsia, Run: matrixSimulationOp(AchromatopsiaMatrix)})
+	RegisterOperation(Operation{Name: "monochromacy", Code: OpMonochromacy, Run: matrixSimulationOp(MonochromacyMatrix)})
+
+	// Without a deficiency or strength, daltonize keeps the original protan-only filter
+	RegisterOperation(Operation{Name: "daltonize", Code: OpDaltonize, Params: []Param{
+		{Name: "deficiency", Kind: ParamString, Enum: []string{"protan", "deutan", "tritan"},
+			Description: "Deficiency to correct for, protan by default; without this or strength the legacy protan filter is applied"},
+		{Name: "strength", Kind: ParamNumber, Min: paramBound(0), Max: paramBound(1),
+			Description: "How much of the lost contrast to restore, 0.6 by default"},
+		lutParam,
+	}, Run: func(img image.Image, p Params) (image.Image, interface{}, error) {
+		if !p.Has("deficiency") && !p.Has("strength") {
+			return Daltonize(img, ProtanopiaMatrix), nil, nil
+		}
+		strength := DefaultDaltonizeStrength
+		if p.Has("strength") {
+			strength = p.Number("strength")
+		}
+		return daltonize(img, p, paramDeficiency(p), strength)
+	}, Check: func(p Params) error {
+		if !p.Has("deficiency") && !p.Has("strength") && p.String("lut") != "none" {
+			return fmt.Errorf("lut needs a deficiency or strength; the legacy protan filter has no LUT")
+		}
+		return nil
+	}})
+	RegisterOperation(Operation{Name: "daltonize_protanopia", Code: OpDaltonizeProtanopia, Params: []Param{strengthParam, lutParam}, Run: daltonizationOp(Protan)})
+	RegisterOperation(Operation{Name: "daltonize_deuteranopia", Code: OpDaltonizeDeuteranopia, Params: []Param{strengthParam, lutParam}, Run: daltonizationOp(Deutan)})
+	RegisterOperation(Operation{Name: "daltonize_tritanopia", Code: OpDaltonizeTritanopia, Params: []Param{strengthParam, lutParam}, Run: daltonizationOp(Tritan)})
+	RegisterOperation(Operation{Name: "recolor", Code: OpRecolor, Params: []Param{
+		{Name: "algorithm", Kind: ParamString, Default: "daltonize", EnumFunc: RecolorerNames,
+			Description: "Recoloring algorithm"},
+		deficiencyParam,
+		strengthParam,
+	}, Run: func(img image.Image, p Params) (image.Image, interface{}, error) {
+		out, err := Recolor(img, p.String("algorithm"), paramDeficiency(p), p.Number("strength"))
+		return out, nil, err
+	}})
+
+	RegisterOperation(Operation{Name: "confusion_map", Code: OpConfusionMap, Params: []Param{modelParam, deficiencyParam},
+		Run: func(img image.Image, p Params) (image.Image, interface{}, error) {
+			return ConfusionMap(img, paramDeficiency(p), SimulationModel(p.String("model")))
+		}})
+	RegisterOperation(Operation{Name: "wcag_audit", Code: OpWCAGAudit, Params: []Param{modelParam,
+		{Name: "min_contrast", Kind: ParamNumber, Default: WCAGMinContrast, Min: paramBound(1), Max: paramBound(21),
+			Description: "Smallest passing WCAG contrast ratio"},
+	}, Run: func(img image.Image, p Params) (image.Image, interface{}, error) {
+		return AuditContrast(img, SimulationModel(p.String("model")), p.Number("min_contrast"))
+	}})
+
+	RegisterOperation(Operation{Name: "convolve", Code: OpConvolve, Params: []Param{
+		{Name: "kernel", Kind: ParamString, Default: "0,0,0; 0,1,0; 0,0,0",
+			Description: "Square kernel of odd size, rows separated by ';' and values by ','"},
+		{Name: "normalize", Kind: ParamBoolean, Default: true,
+			Description: "Scale the weights to sum to one unless they sum to zero"},
+		edgeParam,
+	}, Run: func(img image.Image, p Params) (image.Image, interface{}, error) {
+		k, err := ParseKernel(p.String("kernel"))
+		if err != nil {
+			return nil, nil, err
+		}
+		if p.Bool("normalize") {
+			k = k.Normalized()
+		}
+		out, err := Convolve(img, k, EdgeMode(p.String("edge")))
+		return out, nil, err
+	}})
+
+	defaults := DefaultGridOptions()
+	RegisterOperation(Operation{Name: "grid", Code: OpGrid, Params: []Param{modelParam,
+		{Name: "columns", Kind: ParamNumber, Default: float64(defaults.Columns), Min: paramBound(1), Max: paramBound(9),
+			Description: "Panels per row"},
+		{Name: "width", Kind: ParamNumber, Default: float64(defaults.CellWidth), Min: paramBound(16), Max: paramBound(2048),
+			Description: "Width of each panel in pixels"},
+		{Name: "captions", Kind: ParamBoolean, Default: defaults.Captions, Description: "Label each panel"},
+	}, Run: func(img image.Image, p Params) (image.Image, interface{}, error) {
+		opts := DefaultGridOptions()
+		opts.Columns = int(p.Number("columns"))
+		opts.CellWidth = int(p.Number("width"))
+		opts.Captions = p.Bool("captions")
+		out, err := ComparisonGrid(img, SimulationModel(p.String("model")), opts)
+		return out, nil, err
+	}, ChangesSize: alwaysChangesSize})
+}
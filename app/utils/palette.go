@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultPaletteThreshold is the ΔE00 below which two palette colors count as indistinguishable
+const DefaultPaletteThreshold = 10.0
+
+// VisionTypes lists the color vision deficiencies a palette is checked against
+var VisionTypes = []string{
+	"protanopia",
+	"deuteranopia",
+	"tritanopia",
+	"protanomaly",
+	"deuteranomaly",
+	"tritanomaly",
+	"achromatopsia",
+}
+
+// ColorPair identifies two palette entries and how far apart they are
+type ColorPair struct {
+	First  int     `json:"first"`
+	Second int     `json:"second"`
+	DeltaE float64 `json:"delta_e"`
+}
+
+// PaletteCheck reports how a palette looks to one type of color vision
+type PaletteCheck struct {
+	Vision            string      `json:"vision"`
+	Simulated         []string    `json:"simulated"`
+	DeltaE            [][]float64 `json:"delta_e"`
+	Indistinguishable []ColorPair `json:"indistinguishable"`
+}
+
+// ParseHexColor parses "#rrggbb" or "#rgb" (with or without the hash) into sRGB components in [0,1]
+func ParseHexColor(s string) ([3]float64, error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return [3]float64{}, fmt.Errorf("invalid color %q", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return [3]float64{}, fmt.Errorf("invalid color %q", s)
+	}
+	return [3]float64{float64(v>>16) / 255, float64(v>>8&0xff) / 255, float64(v&0xff) / 255}, nil
+}
+
+// HexColor formats sRGB components in [0,1] as "#rrggbb"
+func HexColor(c [3]float64) string {
+	return fmt.Sprintf("#%02x%02x%02x", toByte(c[0]), toByte(c[1]), toByte(c[2]))
+}
+
+// VisionTransform returns the simulation for a vision type name such as
+// "deuteranopia", "tritanomaly" or "achromatopsia"
+func VisionTransform(name string, model SimulationModel) (ColorTransform, error) {
+	switch name {
+	case "normal":
+		return func(r, g, b float64) (float64, float64, float64) { return r, g, b }, nil
+	case "achromatopsia", "monochromacy":
+		return achromatopsiaTransform, nil
+	}
+
+	d, err := ParseDeficiency(name)
+	if err != nil {
+		return nil, err
+	}
+	severity := 1.0
+	if strings.HasSuffix(name, "anomaly") {
+		severity = DefaultAnomalySeverity
+	}
+	return NewSimulation(d, model, severity)
+}
+
+// achromatopsiaTransform keeps only the linear-light luminance of a color
+func achromatopsiaTransform(r, g, b float64) (float64, float64, float64) {
+	y := LinearToSRGB(0.2126*SRGBToLinear(r) + 0.7152*SRGBToLinear(g) + 0.0722*SRGBToLinear(b))
+	return y, y, y
+}
+
+// CheckPalette simulates each palette color for every vision type and reports the
+// pairwise CIEDE2000 differences and the pairs that fall below the threshold
+func CheckPalette(colors []string, model SimulationModel, threshold float64) ([]PaletteCheck, error) {
+	parsed := make([][3]float64, len(colors))
+	for i, c := range colors {
+		rgb, err := ParseHexColor(c)
+		if err != nil {
+			return nil, err
+		}
+		parsed[i] = rgb
+	}
+	if threshold <= 0 || math.IsNaN(threshold) {
+		threshold = DefaultPaletteThreshold
+	}
+
+	checks := make([]PaletteCheck, 0, len(VisionTypes)+1)
+	for _, vision := range append([]string{"normal"}, VisionTypes...) {
+		transform, err := VisionTransform(vision, model)
+		if err != nil {
+			return nil, err
+		}
+
+		check := PaletteCheck{
+			Vision:            vision,
+			Simulated:         make([]string, len(parsed)),
+			DeltaE:            make([][]float64, len(parsed)),
+			Indistinguishable: []ColorPair{},
+		}
+		labs := make([]Lab, len(parsed))
+		for i, c := range parsed {
+			r, g, b := transform(c[0], c[1], c[2])
+			check.Simulated[i] = HexColor([3]float64{r, g, b})
+			labs[i] = LabFromSRGB(r, g, b)
+		}
+		for i := range labs {
+			check.DeltaE[i] = make([]float64, len(labs))
+			for j := range labs {
+				check.DeltaE[i][j] = math.Round(CIEDE2000(labs[i], labs[j])*100) / 100
+				if j > i && check.DeltaE[i][j] < threshold {
+					check.Indistinguishable = append(check.Indistinguishable, ColorPair{First: i, Second: j, DeltaE: check.DeltaE[i][j]})
+				}
+			}
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
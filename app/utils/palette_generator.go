@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// Levels per sRGB channel in the candidate color grid
+	paletteGridLevels = 8
+	// Refinement passes after the greedy selection
+	paletteRefinePasses = 3
+)
+
+// GeneratedPalette is a palette chosen to stay distinguishable for a set of vision types
+type GeneratedPalette struct {
+	Colors     []string           `json:"colors"`
+	MinDeltaE  float64            `json:"min_delta_e"`
+	PerVision  map[string]float64 `json:"per_vision"`
+	Visions    []string           `json:"visions"`
+	FixedCount int                `json:"fixed_count"`
+}
+
+// GeneratePalette picks count colors, starting with the fixed ones, that maximise the
+// smallest simulated CIEDE2000 difference between any two colors across normal
+// vision and every requested vision type
+func GeneratePalette(count int, fixed []string, visions []string, model SimulationModel) (GeneratedPalette, error) {
+	if len(visions) == 0 {
+		visions = VisionTypes
+	}
+	if count < len(fixed) || count < 2 {
+		return GeneratedPalette{}, fmt.Errorf("count %d must be at least 2 and cover the %d fixed colors", count, len(fixed))
+	}
+
+	allVisions := append([]string{"normal"}, visions...)
+	transforms := make([]ColorTransform, len(allVisions))
+	for i, vision := range allVisions {
+		t, err := VisionTransform(vision, model)
+		if err != nil {
+			return GeneratedPalette{}, err
+		}
+		transforms[i] = t
+	}
+
+	// Fixed colors come first, followed by the candidate grid
+	var colors [][3]float64
+	for _, c := range fixed {
+		rgb, err := ParseHexColor(c)
+		if err != nil {
+			return GeneratedPalette{}, err
+		}
+		colors = append(colors, rgb)
+	}
+	for r := 0; r < paletteGridLevels; r++ {
+		for g := 0; g < paletteGridLevels; g++ {
+			for b := 0; b < paletteGridLevels; b++ {
+				step := float64(paletteGridLevels - 1)
+				colors = append(colors, [3]float64{float64(r) / step, float64(g) / step, float64(b) / step})
+			}
+		}
+	}
+
+	// Simulated Lab of every color under every vision type
+	labs := make([][]Lab, len(transforms))
+	for v, t := range transforms {
+		labs[v] = make([]Lab, len(colors))
+		for i, c := range colors {
+			labs[v][i] = LabFromSRGB(t(c[0], c[1], c[2]))
+		}
+	}
+	distance := func(i, j int) float64 {
+		d := math.Inf(1)
+		for v := range labs {
+			d = math.Min(d, CIEDE2000(labs[v][i], labs[v][j]))
+		}
+		return d
+	}
+	// minDistance is the smallest distance from candidate to the chosen colors, skipping one slot
+	minDistance := func(chosen []int, candidate, skip int) float64 {
+		d := math.Inf(1)
+		for k, c := range chosen {
+			if k != skip {
+				d = math.Min(d, distance(candidate, c))
+			}
+		}
+		return d
+	}
+
+	chosen := make([]int, 0, count)
+	for i := range fixed {
+		chosen = append(chosen, i)
+	}
+	if len(chosen) == 0 {
+		// Start from the candidate closest to mid gray so the result is deterministic
+		midGray := Lab{L: 50}
+		seed, seedDist := len(fixed), math.Inf(1)
+		for i := len(fixed); i < len(colors); i++ {
+			if d := LabFromSRGB(colors[i][0], colors[i][1], colors[i][2]).DistanceTo(midGray); d < seedDist {
+				seed, seedDist = i, d
+			}
+		}
+		chosen = append(chosen, seed)
+	}
+
+	// Greedy farthest-point selection
+	for len(chosen) < count {
+		best, bestDist := -1, -1.0
+		for i := len(fixed); i < len(colors); i++ {
+			if d := minDistance(chosen, i, -1); d > bestDist {
+				best, bestDist = i, d
+			}
+		}
+		chosen = append(chosen, best)
+	}
+
+	// Swap free colors for candidates that raise their nearest-neighbor distance
+	for pass := 0; pass < paletteRefinePasses; pass++ {
+		improved := false
+		for k := len(fixed); k < len(chosen); k++ {
+			current := minDistance(chosen, chosen[k], k)
+			for i := len(fixed); i < len(colors); i++ {
+				if d := minDistance(chosen, i, k); d > current {
+					chosen[k], current = i, d
+					improved = true
+				}
+			}
+		}
+		if !improved {
+			break
+		}
+	}
+
+	result := GeneratedPalette{
+		Colors:     make([]string, len(chosen)),
+		MinDeltaE:  math.Inf(1),
+		PerVision:  make(map[string]float64, len(allVisions)),
+		Visions:    visions,
+		FixedCount: len(fixed),
+	}
+	for k, c := range chosen {
+		result.Colors[k] = HexColor(colors[c])
+	}
+	for v, vision := range allVisions {
+		worst := math.Inf(1)
+		for a := 0; a < len(chosen); a++ {
+			for b := a + 1; b < len(chosen); b++ {
+				worst = math.Min(worst, CIEDE2000(labs[v][chosen[a]], labs[v][chosen[b]]))
+			}
+		}
+		result.PerVision[vision] = math.Round(worst*100) / 100
+		result.MinDeltaE = math.Min(result.MinDeltaE, result.PerVision[vision])
+	}
+	return result, nil
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestGeneratePalette(t *testing.T) {
+	tests := []struct {
+		name    string
+		count   int
+		fixed   []string
+		visions []string
+		// Smallest acceptable MinDeltaE
+		atLeast float64
+	}{
+		// Black and white are as far apart as colors get, for every vision type
+		{"two colors", 2, nil, nil, 100},
+		{"five colors for every vision type", 5, nil, nil, 15},
+		{"keeps a fixed color", 4, []string{"#D62728"}, []string{"deuteranopia"}, 30},
+		{"only fixed colors", 2, []string{"#000000", "#0000ff"}, []string{"tritanopia"}, 0},
+		{"six colors for red-green deficiencies", 6, nil, []string{"protanopia", "deuteranopia"}, 30},
+	}
+	for _, tt := range tests {
+		got, err := GeneratePalette(tt.count, tt.fixed, tt.visions, ModelMachado)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(got.Colors) != tt.count || got.FixedCount != len(tt.fixed) {
+			t.Errorf("%s: %d colors with %d fixed, want %d with %d", tt.name, len(got.Colors), got.FixedCount, tt.count, len(tt.fixed))
+			continue
+		}
+		for i, c := range tt.fixed {
+			want, _ := ParseHexColor(c)
+			if got.Colors[i] != HexColor(want) {
+				t.Errorf("%s: color %d is %s, want the fixed %s", tt.name, i, got.Colors[i], c)
+			}
+		}
+		if got.MinDeltaE < tt.atLeast {
+			t.Errorf("%s: smallest ΔE %v, want at least %v", tt.name, got.MinDeltaE, tt.atLeast)
+		}
+		if again, _ := GeneratePalette(tt.count, tt.fixed, tt.visions, ModelMachado); !reflect.DeepEqual(again, got) {
+			t.Errorf("%s: a second run gave %v, want %v", tt.name, again.Colors, got.Colors)
+		}
+
+		// The reported distances agree with checking the palette. The generator measures
+		// its grid colors before they are rounded to 8-bit hex, which moves ΔE a little.
+		checks, err := CheckPalette(got.Colors, ModelMachado, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		smallest := math.Inf(1)
+		for _, check := range checks {
+			want, ok := got.PerVision[check.Vision]
+			if !ok {
+				continue
+			}
+			worst := math.Inf(1)
+			for i := range check.DeltaE {
+				for j := i + 1; j < len(check.DeltaE); j++ {
+					worst = math.Min(worst, check.DeltaE[i][j])
+				}
+			}
+			if math.Abs(worst-want) > 0.25 {
+				t.Errorf("%s: %s reported at %v, but the check finds %v", tt.name, check.Vision, want, worst)
+			}
+			smallest = math.Min(smallest, want)
+		}
+		if len(got.PerVision) != len(got.Visions)+1 || got.MinDeltaE != smallest {
+			t.Errorf("%s: MinDeltaE %v over %v, want the smallest of %d vision types", tt.name, got.MinDeltaE, got.PerVision, len(got.Visions)+1)
+		}
+	}
+}
+
+func TestGeneratePaletteRejectsBadRequests(t *testing.T) {
+	tests := []struct {
+		name    string
+		count   int
+		fixed   []string
+		visions []string
+	}{
+		{"one color", 1, nil, nil},
+		{"fewer colors than fixed", 2, []string{"#000", "#fff", "#f00"}, nil},
+		{"bad fixed color", 3, []string{"teal"}, nil},
+		{"unknown vision type", 3, nil, []string{"tetrachromacy"}},
+	}
+	for _, tt := range tests {
+		if got, err := GeneratePalette(tt.count, tt.fixed, tt.visions, ModelMachado); err == nil {
+			t.Errorf("%s: got %v, want an error", tt.name, got.Colors)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHexColor(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    [3]float64
+		wantErr bool
+	}{
+		{"#ff8000", [3]float64{1, 128.0 / 255, 0}, false},
+		{"FF8000", [3]float64{1, 128.0 / 255, 0}, false},
+		{" #f80 ", [3]float64{1, 136.0 / 255, 0}, false},
+		{"#000", [3]float64{0, 0, 0}, false},
+		{"#ff80", [3]float64{}, true},
+		{"#gg0000", [3]float64{}, true},
+		{"", [3]float64{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseHexColor(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseHexColor(%q) error %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseHexColor(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+		if !tt.wantErr {
+			if again, _ := ParseHexColor(HexColor(got)); again != got {
+				t.Errorf("%q does not survive HexColor, which gives %q", tt.in, HexColor(got))
+			}
+		}
+	}
+}
+
+func TestCheckPalette(t *testing.T) {
+	// Tableau red, green and blue with black and white
+	palette := []string{"#d62728", "#2ca02c", "#1f77b4", "#000", "#ffffff"}
+	checks, err := CheckPalette(palette, ModelMachado, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != len(VisionTypes)+1 || checks[0].Vision != "normal" {
+		t.Fatalf("got %d checks starting with %q, want normal vision and every vision type", len(checks), checks[0].Vision)
+	}
+
+	want := map[string][]ColorPair{
+		"normal":       {},
+		"protanopia":   {},
+		"deuteranopia": {{First: 0, Second: 1, DeltaE: 4.61}},
+		"tritanopia":   {},
+		// Red and blue have nearly the same luminance
+		"achromatopsia": {{First: 0, Second: 2, DeltaE: 1.12}, {First: 1, Second: 2, DeltaE: 9.69}},
+	}
+	for _, check := range checks {
+		if check.Vision == "normal" && !reflect.DeepEqual(check.Simulated, []string{"#d62728", "#2ca02c", "#1f77b4", "#000000", "#ffffff"}) {
+			t.Errorf("normal vision changes the palette to %v", check.Simulated)
+		}
+		if pairs, ok := want[check.Vision]; ok && !reflect.DeepEqual(check.Indistinguishable, pairs) {
+			t.Errorf("%s: indistinguishable %v, want %v", check.Vision, check.Indistinguishable, pairs)
+		}
+		for i := range check.DeltaE {
+			if check.DeltaE[i][i] != 0 {
+				t.Errorf("%s: color %d is %v from itself", check.Vision, i, check.DeltaE[i][i])
+			}
+			for j := range check.DeltaE[i] {
+				if check.DeltaE[i][j] != check.DeltaE[j][i] {
+					t.Errorf("%s: ΔE between %d and %d is not symmetric", check.Vision, i, j)
+				}
+			}
+		}
+	}
+
+	// A higher threshold flags more pairs, and bad colors are rejected
+	strict, err := CheckPalette(palette, ModelMachado, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flagged := func(checks []PaletteCheck) int {
+		n := 0
+		for _, check := range checks {
+			n += len(check.Indistinguishable)
+		}
+		return n
+	}
+	if flagged(strict) <= flagged(checks) {
+		t.Errorf("a threshold of 30 flags %d pairs, no more than the default's %d", flagged(strict), flagged(checks))
+	}
+	if _, err := CheckPalette([]string{"#d62728", "red"}, ModelMachado, 0); err == nil {
+		t.Error("a color name was accepted")
+	}
+}
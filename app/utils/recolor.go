@@ -0,0 +1,280 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"sync"
+)
+
+// RecolorFunc adapts an image so a viewer with the given deficiency can tell more colors apart.
+// Strength runs from 0 (unchanged) to 1 (full correction).
+type RecolorFunc func(img image.Image, d Deficiency, strength float64) (image.Image, error)
+
+var (
+	recolorersMu sync.RWMutex
+	recolorers   = map[string]RecolorFunc{
+		"daltonize":           DaltonizeDeficiency,
+		"fidaner":             recolorFidaner,
+		"hue_rotation":        recolorHueRotation,
+		"contrast_preserving": recolorContrastPreserving,
+	}
+)
+
+// RegisterRecolorer makes a recoloring algorithm available under the given name.
+// It is safe to call while images are being recolored.
+func RegisterRecolorer(name string, fn RecolorFunc) {
+	recolorersMu.Lock()
+	defer recolorersMu.Unlock()
+	recolorers[name] = fn
+}
+
+// RecolorerNames lists the registered recoloring algorithms in alphabetical order
+func RecolorerNames() []string {
+	recolorersMu.RLock()
+	defer recolorersMu.RUnlock()
+	names := make([]string, 0, len(recolorers))
+	for name := range recolorers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Recolor runs the named recoloring algorithm on the image
+func Recolor(img image.Image, algorithm string, d Deficiency, strength float64) (image.Image, error) {
+	recolorersMu.RLock()
+	fn, ok := recolorers[algorithm]
+	recolorersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown recoloring algorithm %q", algorithm)
+	}
+	if strength < 0 || strength > 1 || math.IsNaN(strength) {
+		return nil, fmt.Errorf("strength %v out of range [0,1]", strength)
+	}
+	if d < Protan || d > Tritan {
+		return nil, fmt.Errorf("unknown deficiency %d", int(d))
+	}
+	return fn(img, d, strength)
+}
+
+// recolorFidaner simulates the deficiency in LMS space, then redistributes the
+// lost contrast with the Fidaner error matrices
+func recolorFidaner(img image.Image, d Deficiency, strength float64) (image.Image, error) {
+	missing := int(d)
+	anchor := anchor575
+	if d == Tritan {
+		anchor = anchor660
+	}
+	normal := cross(whiteLMS, anchor)
+	redistribution := daltonizeErrorMatrices[d]
+
+	return ApplyColorTransform(img, func(r, g, b float64) (float64, float64, float64) {
+		orig := [3]float64{SRGBToLinear(r), SRGBToLinear(g), SRGBToLinear(b)}
+		lms := projectOntoPlane(mulVector(lmsFromLinearRGB, orig), normal, missing)
+		sim := mulVector(linearRGBFromLMS, lms)
+		shift := mulVector(redistribution, [3]float64{orig[0] - sim[0], orig[1] - sim[1], orig[2] - sim[2]})
+
+		return LinearToSRGB(clampUnit(orig[0] + shift[0]*strength)),
+			LinearToSRGB(clampUnit(orig[1] + shift[1]*strength)),
+			LinearToSRGB(clampUnit(orig[2] + shift[2]*strength))
+	}), nil
+}
+
+// Largest hue rotation applied to a color lying entirely on the confusion axis
+const maxHueRotation = math.Pi / 3
+
+// recolorHueRotation rotates hues in CIE LCh so chroma on the axis the viewer
+// cannot see (a* for red-green, b* for blue-yellow) moves onto the axis they can
+func recolorHueRotation(img image.Image, d Deficiency, strength float64) (image.Image, error) {
+	return ApplyColorTransform(img, func(r, g, b float64) (float64, float64, float64) {
+		lab := LabFromSRGB(r, g, b)
+		chroma := math.Hypot(lab.A, lab.B)
+		if chroma < 1e-6 {
+			return r, g, b
+		}
+
+		// Reds turn towards yellow and greens towards blue for protans and deutans;
+		// yellows turn towards red and blues towards cyan for tritans
+		angle := strength * maxHueRotation * math.Abs(lab.A) / chroma
+		if d == Tritan {
+			angle = -strength * maxHueRotation * math.Abs(lab.B) / chroma
+		}
+
+		hue := math.Atan2(lab.B, lab.A) + angle
+		lab.A, lab.B = chroma*math.Cos(hue), chroma*math.Sin(hue)
+		return lab.SRGB()
+	}), nil
+}
+
+const (
+	paletteSize       = 8
+	paletteSamples    = 10000
+	kMeansIterations  = 10
+	optimizerPasses   = 60
+	optimizerStep     = 16.0
+	displacementCost  = 0.05
+	paletteWeightBias = 1.0
+)
+
+// recolorContrastPreserving extracts the dominant palette of the image and shifts
+// each palette color in a*b* so the color differences between them survive the
+// simulated deficiency, then spreads those shifts smoothly over every pixel
+func recolorContrastPreserving(img image.Image, d Deficiency, strength float64) (image.Image, error) {
+	simulate, err := NewSimulation(d, ModelMachado, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	palette := extractPalette(img, paletteSize)
+	if len(palette) < 2 {
+		return img, nil
+	}
+	offsets := optimizePaletteOffsets(palette, simulate)
+
+	return ApplyColorTransform(img, func(r, g, b float64) (float64, float64, float64) {
+		lab := LabFromSRGB(r, g, b)
+
+		var da, db, total float64
+		for i, p := range palette {
+			dist := lab.DistanceTo(p)
+			w := 1 / (dist*dist + paletteWeightBias)
+			da += w * offsets[i][0]
+			db += w * offsets[i][1]
+			total += w
+		}
+		lab.A += strength * da / total
+		lab.B += strength * db / total
+		return lab.SRGB()
+	}), nil
+}
+
+// extractPalette clusters a sample of the image colors into at most k Lab centers
+func extractPalette(img image.Image, k int) []Lab {
+	bounds := img.Bounds()
+	step := int(math.Sqrt(float64(bounds.Dx()*bounds.Dy()) / paletteSamples))
+	if step < 1 {
+		step = 1
+	}
+
+	var samples []Lab
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			samples = append(samples, LabFromSRGB(float64(c.R)/255, float64(c.G)/255, float64(c.B)/255))
+		}
+	}
+	if len(samples) < k {
+		k = len(samples)
+	}
+	if k == 0 {
+		return nil
+	}
+
+	// Seed deterministically from samples sorted by lightness
+	sorted := append([]Lab(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].L < sorted[j].L })
+	centers := make([]Lab, k)
+	for i := range centers {
+		centers[i] = sorted[(2*i+1)*len(sorted)/(2*k)]
+	}
+
+	assignment := make([]int, len(samples))
+	for iter := 0; iter < kMeansIterations; iter++ {
+		for i, s := range samples {
+			best, bestDist := 0, math.Inf(1)
+			for j, c := range centers {
+				if dist := s.DistanceTo(c); dist < bestDist {
+					best, bestDist = j, dist
+				}
+			}
+			assignment[i] = best
+		}
+
+		sums := make([]Lab, k)
+		counts := make([]int, k)
+		for i, s := range samples {
+			j := assignment[i]
+			sums[j].L += s.L
+			sums[j].A += s.A
+			sums[j].B += s.B
+			counts[j]++
+		}
+		for j := range centers {
+			if counts[j] > 0 {
+				n := float64(counts[j])
+				centers[j] = Lab{sums[j].L / n, sums[j].A / n, sums[j].B / n}
+			}
+		}
+	}
+
+	// Drop duplicate centers left behind by empty clusters
+	var palette []Lab
+	for _, c := range centers {
+		duplicate := false
+		for _, p := range palette {
+			if c.DistanceTo(p) < 1 {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			palette = append(palette, c)
+		}
+	}
+	return palette
+}
+
+// optimizePaletteOffsets searches for a*b* offsets that make the simulated
+// differences between palette colors match the original ones, while keeping
+// each color close to where it started
+func optimizePaletteOffsets(palette []Lab, simulate ColorTransform) [][2]float64 {
+	offsets := make([][2]float64, len(palette))
+
+	simulated := func(i int) Lab {
+		c := palette[i]
+		c.A += offsets[i][0]
+		c.B += offsets[i][1]
+		return LabFromSRGB(simulate(c.SRGB()))
+	}
+	cost := func() float64 {
+		sims := make([]Lab, len(palette))
+		for i := range palette {
+			sims[i] = simulated(i)
+		}
+		var total float64
+		for i := range palette {
+			for j := i + 1; j < len(palette); j++ {
+				diff := palette[i].DistanceTo(palette[j]) - sims[i].DistanceTo(sims[j])
+				total += diff * diff
+			}
+			total += displacementCost * (offsets[i][0]*offsets[i][0] + offsets[i][1]*offsets[i][1])
+		}
+		return total
+	}
+
+	best := cost()
+	step := optimizerStep
+	for pass := 0; pass < optimizerPasses && step > 0.5; pass++ {
+		improved := false
+		for i := range offsets {
+			for axis := 0; axis < 2; axis++ {
+				for _, dir := range []float64{step, -step} {
+					offsets[i][axis] += dir
+					if c := cost(); c < best {
+						best = c
+						improved = true
+						break
+					}
+					offsets[i][axis] -= dir
+				}
+			}
+		}
+		if !improved {
+			step /= 2
+		}
+	}
+	return offsets
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// identityRecolor returns the image unchanged
+func identityRecolor(img image.Image, d Deficiency, strength float64) (image.Image, error) {
+	return img, nil
+}
+
+func TestRecolorerRegisteredAfterInit(t *testing.T) {
+	op, ok := LookupOperation("recolor")
+	if !ok {
+		t.Fatal("recolor is not registered")
+	}
+	raw := map[string]interface{}{"algorithm": "test_late"}
+	if _, errs := ValidateParams(op, raw); len(errs) == 0 {
+		t.Fatal("an unregistered algorithm was accepted")
+	}
+
+	RegisterRecolorer("test_late", identityRecolor)
+	p, errs := ValidateParams(op, raw)
+	if len(errs) > 0 {
+		t.Fatalf("a late algorithm was rejected: %v", errs)
+	}
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	if out, _, err := op.Run(src, p); err != nil || out != image.Image(src) {
+		t.Errorf("running the late algorithm gave %v, %v", out, err)
+	}
+
+	for _, listed := range Operations() {
+		if listed.Name != "recolor" {
+			continue
+		}
+		for _, param := range listed.Params {
+			if param.Name == "algorithm" && !slices.Contains(param.Enum, "test_late") {
+				t.Errorf("the operation list offers %v, without the late algorithm", param.Enum)
+			}
+		}
+	}
+}
+
+func TestRegisterRecolorerWhileRecoloring(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			RegisterRecolorer(fmt.Sprintf("test_concurrent_%d", i), identityRecolor)
+		}(i)
+		go func() {
+			defer wg.Done()
+			if _, err := Recolor(src, "hue_rotation", Deutan, 0.5); err != nil {
+				t.Error(err)
+			}
+			RecolorerNames()
+		}()
+	}
+	wg.Wait()
+}
+
+// stripesImage is a 300×300 image of vertical stripes in the given colors
+func stripesImage(colors ...color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 300, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 300; x++ {
+			img.SetNRGBA(x, y, colors[x*len(colors)/300])
+		}
+	}
+	return img
+}
+
+func TestExtractPalette(t *testing.T) {
+	red, green, navy := color.NRGBA{220, 30, 30, 255}, color.NRGBA{40, 180, 60, 255}, color.NRGBA{10, 20, 90, 255}
+	lab := func(c color.NRGBA) Lab { return LabFromSRGB(float64(c.R)/255, float64(c.G)/255, float64(c.B)/255) }
+
+	tests := []struct {
+		name string
+		img  image.Image
+		k    int
+		// Expected centers, darkest first as seeded
+		want []color.NRGBA
+	}{
+		{"one center per color", stripesImage(red, green, navy), 3, []color.NRGBA{navy, red, green}},
+		{"duplicate centers dropped", stripesImage(red, green), 4, []color.NRGBA{red, green}},
+		{"flat image", stripesImage(navy), 8, []color.NRGBA{navy}},
+		{"fewer pixels than centers", image.NewNRGBA(image.Rect(0, 0, 1, 1)), 3, []color.NRGBA{{}}},
+		{"empty image", image.NewNRGBA(image.Rectangle{}), 3, nil},
+	}
+	for _, tt := range tests {
+		got := extractPalette(tt.img, tt.k)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: %d centers %v, want %d", tt.name, len(got), got, len(tt.want))
+			continue
+		}
+		for i, c := range tt.want {
+			if d := got[i].DistanceTo(lab(c)); d > 1e-6 {
+				t.Errorf("%s: center %d is %v, %.3g from %v", tt.name, i, got[i], d, c)
+			}
+		}
+	}
+
+	// Unequal clusters pull their centers to the mean of their own colors only
+	img := stripesImage(red, red, red, navy)
+	got := extractPalette(img, 2)
+	if len(got) != 2 || got[0].DistanceTo(lab(navy)) > 1e-6 || got[1].DistanceTo(lab(red)) > 1e-6 {
+		t.Errorf("three parts red to one navy gave %v", got)
+	}
+}
@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// ShearRotateOptions configures RotateShear
+type ShearRotateOptions struct {
+	// Fill is painted under the areas the rotated image does not cover; nil leaves them transparent
+	Fill color.Color
+	// Antialias resamples each shear with linear interpolation instead of whole-pixel shifts
+	Antialias bool
+}
+
+// RotateShear rotates the image counter-clockwise about its centre using Paeth's
+// three-shear decomposition. The canvas grows to fit the rotated image. Quarter
+// turns are done exactly first, so the shears only cover the remaining ±45°.
+func RotateShear(img image.Image, angle float64, opts ShearRotateOptions) image.Image {
+	angle = math.Mod(angle, 360)
+	quarters := int(math.Round(angle / 90))
+	residual := angle - float64(quarters)*90
+
+	img = QuarterTurn(img, quarters)
+
+	lin := ToLinear(img)
+	deep := lin.HighBitDepth
+	lin.Rect = image.Rect(0, 0, lin.Rect.Dx(), lin.Rect.Dy())
+	w, h := lin.Rect.Dx(), lin.Rect.Dy()
+
+	if residual != 0 && w > 0 && h > 0 {
+		// x' = x + a·y, then y' = y + b·x, then x' = x + a·y again
+		theta := residual * math.Pi / 180
+		a, b := math.Tan(theta/2), -math.Sin(theta)
+		lin = shearX(lin, a, opts.Antialias)
+		lin = shearY(lin, b, opts.Antialias)
+		lin = shearX(lin, a, opts.Antialias)
+
+		// Trim the intermediate canvas to the rotated bounding box, with a pixel of
+		// margin for the interpolated fringe
+		sin, cos := math.Abs(math.Sin(theta)), math.Abs(math.Cos(theta))
+		margin := 0.0
+		if opts.Antialias {
+			margin = 1
+		}
+		fitW := growEven(w, float64(w)*cos+float64(h)*sin+margin)
+		fitH := growEven(h, float64(w)*sin+float64(h)*cos+margin)
+		lin = cropCentre(lin, min(fitW, lin.Rect.Dx()), min(fitH, lin.Rect.Dy()))
+	}
+
+	if opts.Fill != nil {
+		fillUnder(lin, opts.Fill)
+	}
+	lin.HighBitDepth = deep
+	return lin.Image()
+}
+
+// growEven returns the smallest size of at least want that differs from n by an
+// even number of pixels, so the image stays centred on whole pixels
+func growEven(n int, want float64) int {
+	extra := int(math.Ceil(want-1e-9)) - n
+	if extra < 0 {
+		extra = 0
+	}
+	return n + (extra+1)/2*2
+}
+
+// shearX shifts each row horizontally by k times its distance from the centre row
+func shearX(src *LinearImage, k float64, antialias bool) *LinearImage {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	outW := growEven(w, float64(w)+math.Abs(k)*float64(h))
+	out := NewLinearImage(image.Rect(0, 0, outW, h))
+
+	parallelRows(0, h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			shift := k*(float64(y)+0.5-float64(h)/2) + float64(outW-w)/2
+			dst := out.Pix[y*out.Stride : (y+1)*out.Stride]
+			for x := 0; x < outW; x++ {
+				shiftSample(src.Pix, y*src.Stride, 4, w, float64(x)-shift, antialias, dst[4*x:4*x+4])
+			}
+		}
+	})
+	return out
+}
+
+// shearY shifts each column vertically by k times its distance from the centre column
+func shearY(src *LinearImage, k float64, antialias bool) *LinearImage {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	outH := growEven(h, float64(h)+math.Abs(k)*float64(w))
+	out := NewLinearImage(image.Rect(0, 0, w, outH))
+
+	parallelRows(0, outH, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			dst := out.Pix[y*out.Stride : (y+1)*out.Stride]
+			for x := 0; x < w; x++ {
+				shift := k*(float64(x)+0.5-float64(w)/2) + float64(outH-h)/2
+				shiftSample(src.Pix, 4*x, src.Stride, h, float64(y)-shift, antialias, dst[4*x:4*x+4])
+			}
+		}
+	})
+	return out
+}
+
+// shiftSample reads the pixel at fractional position pos along a line of n pixels
+// starting at base and step elements apart. Positions beyond the line are transparent.
+func shiftSample(pix []float32, base, step, n int, pos float64, antialias bool, dst []float32) {
+	if !antialias {
+		i := int(math.Floor(pos + 0.5))
+		if i >= 0 && i < n {
+			copy(dst, pix[base+i*step:base+i*step+4])
+		}
+		return
+	}
+
+	i := int(math.Floor(pos))
+	f := float32(pos - float64(i))
+	if i >= 0 && i < n {
+		p := pix[base+i*step:]
+		for c := 0; c < 4; c++ {
+			dst[c] += p[c] * (1 - f)
+		}
+	}
+	if i+1 >= 0 && i+1 < n && f > 0 {
+		p := pix[base+(i+1)*step:]
+		for c := 0; c < 4; c++ {
+			dst[c] += p[c] * f
+		}
+	}
+}
+
+// cropCentre returns the w×h region in the middle of the image
+func cropCentre(src *LinearImage, w, h int) *LinearImage {
+	x0, y0 := (src.Rect.Dx()-w)/2, (src.Rect.Dy()-h)/2
+	out := NewLinearImage(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		copy(out.Pix[y*out.Stride:(y+1)*out.Stride], src.Pix[(y0+y)*src.Stride+4*x0:])
+	}
+	return out
+}
+
+// fillUnder composites the image over a solid color
+func fillUnder(img *LinearImage, fill color.Color) {
+	c := color.NRGBA64Model.Convert(fill).(color.NRGBA64)
+	a := float32(c.A) / 0xffff
+	under := [4]float32{DecodeSRGB16(c.R) * a, DecodeSRGB16(c.G) * a, DecodeSRGB16(c.B) * a, a}
+
+	parallelRows(0, img.Rect.Dy(), func(y0, y1 int) {
+		for i := y0 * img.Stride; i < y1*img.Stride; i += 4 {
+			cover := 1 - img.Pix[i+3]
+			for c := 0; c < 4; c++ {
+				img.Pix[i+c] += under[c] * cover
+			}
+		}
+	})
+}
@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// Largest per-channel difference, in 8-bit levels, allowed between RotateShear and
+// imaging.Rotate inside the rotated image. The source is a smooth gradient of at
+// most 5 levels per pixel. With antialiasing the only difference is interpolating
+// in linear rather than gamma-encoded light. Whole-pixel shears round the shifts
+// to the nearest pixel, which can move a sample by about a pixel.
+const (
+	rotateAntialiasTolerance = 1
+	rotateNearestTolerance   = 5
+)
+
+var rotateAngles = []float64{0, 30, 45, 90, 137, -60}
+
+// rotateSource is an opaque gradient with odd width and even height, so canvas
+// parity is checked on both axes
+func rotateSource() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 61, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 61; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(40 + 3*x), uint8(30 + 4*y), uint8(200 - 2*x - y), 255})
+		}
+	}
+	return img
+}
+
+// bilinearAt samples img at continuous pixel coordinates where integers are pixel
+// centres, reporting false unless all four neighbours are inside and opaque
+func bilinearAt(img *image.NRGBA, fx, fy float64) ([4]float64, bool) {
+	x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+	tx, ty := fx-float64(x0), fy-float64(y0)
+	var out [4]float64
+	for j := 0; j < 2; j++ {
+		for i := 0; i < 2; i++ {
+			p := image.Pt(x0+i, y0+j)
+			if !p.In(img.Rect) {
+				return out, false
+			}
+			c := img.NRGBAAt(p.X, p.Y)
+			if c.A != 255 {
+				return out, false
+			}
+			w := (1 - tx + float64(i)*(2*tx-1)) * (1 - ty + float64(j)*(2*ty-1))
+			out[0] += w * float64(c.R)
+			out[1] += w * float64(c.G)
+			out[2] += w * float64(c.B)
+			out[3] += w * float64(c.A)
+		}
+	}
+	return out, true
+}
+
+// expectedCanvas returns the canvas RotateShear should produce: the rotated
+// bounding box, plus a pixel of margin when antialiasing, grown so each side
+// differs from the quarter-turned source by an even number of pixels
+func expectedCanvas(w, h int, angle float64, antialias bool) (int, int) {
+	quarters := int(math.Round(math.Mod(angle, 360) / 90))
+	if quarters%2 != 0 {
+		w, h = h, w
+	}
+	residual := (math.Mod(angle, 360) - float64(quarters)*90) * math.Pi / 180
+	if residual == 0 {
+		return w, h
+	}
+	sin, cos := math.Abs(math.Sin(residual)), math.Abs(math.Cos(residual))
+	margin := 0.0
+	if antialias {
+		margin = 1
+	}
+	return growEven(w, float64(w)*cos+float64(h)*sin+margin), growEven(h, float64(w)*sin+float64(h)*cos+margin)
+}
+
+func TestRotateShearCanvas(t *testing.T) {
+	src := rotateSource()
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	for _, angle := range rotateAngles {
+		for _, antialias := range []bool{false, true} {
+			out := RotateShear(src, angle, ShearRotateOptions{Antialias: antialias})
+			gotW, gotH := out.Bounds().Dx(), out.Bounds().Dy()
+			wantW, wantH := expectedCanvas(w, h, angle, antialias)
+			if gotW != wantW || gotH != wantH {
+				t.Errorf("angle %v antialias %v: canvas %dx%d, want %dx%d", angle, antialias, gotW, gotH, wantW, wantH)
+			}
+
+			// The canvas must hold the whole rotated rectangle
+			theta := angle * math.Pi / 180
+			sin, cos := math.Abs(math.Sin(theta)), math.Abs(math.Cos(theta))
+			if float64(gotW) < float64(w)*cos+float64(h)*sin-1e-9 || float64(gotH) < float64(w)*sin+float64(h)*cos-1e-9 {
+				t.Errorf("angle %v antialias %v: canvas %dx%d is smaller than the rotated image", angle, antialias, gotW, gotH)
+			}
+
+			// An opaque source rotated about its centre has its centroid on the canvas centre
+			var sum, sx, sy float64
+			for y := 0; y < gotH; y++ {
+				for x := 0; x < gotW; x++ {
+					_, _, _, a := out.At(out.Bounds().Min.X+x, out.Bounds().Min.Y+y).RGBA()
+					sum += float64(a)
+					sx += float64(a) * (float64(x) + 0.5)
+					sy += float64(a) * (float64(y) + 0.5)
+				}
+			}
+			if dx, dy := sx/sum-float64(gotW)/2, sy/sum-float64(gotH)/2; math.Abs(dx) > 0.25 || math.Abs(dy) > 0.25 {
+				t.Errorf("angle %v antialias %v: image centred %.2f, %.2f pixels off the canvas centre", angle, antialias, dx, dy)
+			}
+		}
+	}
+}
+
+func TestRotateShearMatchesImaging(t *testing.T) {
+	src := rotateSource()
+	fills := map[string]color.Color{"transparent": nil, "solid": color.NRGBA{20, 90, 160, 255}}
+
+	for _, angle := range rotateAngles {
+		for _, antialias := range []bool{false, true} {
+			for fillName, fill := range fills {
+				name := fmt.Sprintf("angle %v antialias %v %s fill", angle, antialias, fillName)
+				tolerance := float64(rotateNearestTolerance)
+				if antialias {
+					tolerance = rotateAntialiasTolerance
+				}
+				if math.Mod(angle, 90) == 0 {
+					// Quarter turns are exact in both
+					tolerance = 0
+				}
+
+				bg := fill
+				if bg == nil {
+					bg = color.Transparent
+				}
+				want := imaging.Rotate(src, angle, bg)
+				got := imaging.Clone(RotateShear(src, angle, ShearRotateOptions{Fill: fill, Antialias: antialias}))
+				gotW, gotH := got.Rect.Dx(), got.Rect.Dy()
+				sin, cos := math.Sincos(angle * math.Pi / 180)
+
+				compared, worst := 0, 0.0
+				for y := 0; y < gotH; y++ {
+					for x := 0; x < gotW; x++ {
+						// Offset of the pixel centre from the canvas centre, and where it came from
+						dx, dy := float64(x)+0.5-float64(gotW)/2, float64(y)+0.5-float64(gotH)/2
+						sx := dx*cos - dy*sin + float64(src.Rect.Dx())/2
+						sy := dx*sin + dy*cos + float64(src.Rect.Dy())/2
+						c := got.NRGBAAt(x, y)
+
+						// Well outside the source both show the fill
+						if sx < -1.5 || sy < -1.5 || sx > float64(src.Rect.Dx())+1.5 || sy > float64(src.Rect.Dy())+1.5 {
+							if fill == nil && c.A != 0 {
+								t.Fatalf("%s: pixel %d,%d outside the image is %v, want transparent", name, x, y, c)
+							}
+							if fill != nil && c != fill {
+								t.Fatalf("%s: pixel %d,%d outside the image is %v, want %v", name, x, y, c, fill)
+							}
+							continue
+						}
+						// Near the edges the fringes differ by design
+						if sx < 2 || sy < 2 || sx > float64(src.Rect.Dx())-2 || sy > float64(src.Rect.Dy())-2 {
+							continue
+						}
+
+						ref, ok := bilinearAt(want, dx+float64(want.Rect.Dx())/2-0.5, dy+float64(want.Rect.Dy())/2-0.5)
+						if !ok {
+							t.Fatalf("%s: pixel %d,%d inside the image maps outside imaging's output", name, x, y)
+						}
+						for ch, v := range []uint8{c.R, c.G, c.B, c.A} {
+							worst = math.Max(worst, math.Abs(float64(v)-ref[ch]))
+						}
+						compared++
+					}
+				}
+				if compared < src.Rect.Dx()*src.Rect.Dy()/2 {
+					t.Errorf("%s: only %d pixels compared", name, compared)
+				}
+				// Half a level covers the 8-bit rounding of the bilinear reference
+				if worst > tolerance+0.5 {
+					t.Errorf("%s: differs from imaging.Rotate by %.2f levels, want at most %v", name, worst, tolerance)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// SplitMode selects how a split view divides the original from the processed image
+type SplitMode string
+
+const (
+	SplitVertical SplitMode = "vertical" // original left of the split point, processed right
+	SplitDiagonal SplitMode = "diagonal" // original above-left of a line from bottom-left to top-right through the split point
+	SplitLens     SplitMode = "lens"     // processed inside a circle around the split point
+)
+
+// Width in pixels of the divider drawn along the split
+const splitDividerWidth = 2.0
+
+// SplitOptions positions the split. X, Y and Radius are fractions of the image
+// width, height and shorter side respectively.
+type SplitOptions struct {
+	Mode   SplitMode
+	X, Y   float64
+	Radius float64
+}
+
+// DefaultSplitOptions splits at the image centre with a lens a quarter of the shorter side
+func DefaultSplitOptions(mode SplitMode) SplitOptions {
+	return SplitOptions{Mode: mode, X: 0.5, Y: 0.5, Radius: 0.25}
+}
+
+// ParseSplitMode validates a split mode name
+func ParseSplitMode(name string) (SplitMode, error) {
+	switch mode := SplitMode(name); mode {
+	case SplitVertical, SplitDiagonal, SplitLens:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown split mode %q", name)
+}
+
+// Validate checks the mode and that the position and radius are fractions, with a
+// radius above zero
+func (o SplitOptions) Validate() error {
+	if _, err := ParseSplitMode(string(o.Mode)); err != nil {
+		return err
+	}
+	if o.X < 0 || o.X > 1 || o.Y < 0 || o.Y > 1 {
+		return fmt.Errorf("split position must be fractions between 0 and 1")
+	}
+	if o.Radius <= 0 || o.Radius > 1 {
+		return fmt.Errorf("lens radius must be a fraction above 0 and at most 1")
+	}
+	return nil
+}
+
+// SplitView combines the original and processed images into one comparison image,
+// with a white divider along the boundary. The result keeps 16 bits per channel
+// when either image has them.
+func SplitView(original, processed image.Image, opts SplitOptions) (image.Image, error) {
+	bounds := original.Bounds()
+	if processed.Bounds().Size() != bounds.Size() {
+		return nil, fmt.Errorf("split view needs images of the same size, got %v and %v",
+			bounds.Size(), processed.Bounds().Size())
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	cx := float64(bounds.Min.X) + opts.X*float64(bounds.Dx())
+	cy := float64(bounds.Min.Y) + opts.Y*float64(bounds.Dy())
+	radius := opts.Radius * float64(min(bounds.Dx(), bounds.Dy()))
+
+	// distance returns the signed distance from the split boundary; positive shows the processed image
+	var distance func(x, y float64) float64
+	switch opts.Mode {
+	case SplitVertical:
+		distance = func(x, y float64) float64 { return x - cx }
+	case SplitDiagonal:
+		distance = func(x, y float64) float64 { return ((x - cx) + (y - cy)) / math.Sqrt2 }
+	case SplitLens:
+		distance = func(x, y float64) float64 { return radius - math.Hypot(x-cx, y-cy) }
+	default:
+		return nil, fmt.Errorf("unknown split mode %q", opts.Mode)
+	}
+
+	var out draw.Image = image.NewNRGBA(bounds)
+	if IsHighBitDepth(original) || IsHighBitDepth(processed) {
+		out = image.NewNRGBA64(bounds)
+	}
+	draw.Draw(out, bounds, original, bounds.Min, draw.Src)
+	offset := processed.Bounds().Min.Sub(bounds.Min)
+	divider := color.NRGBA64{0xffff, 0xffff, 0xffff, 0xffff}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			d := distance(float64(x)+0.5, float64(y)+0.5)
+			switch {
+			case math.Abs(d) < splitDividerWidth/2:
+				out.Set(x, y, divider)
+			case d > 0:
+				out.Set(x, y, processed.At(x+offset.X, y+offset.Y))
+			}
+		}
+	}
+	return out, nil
+}
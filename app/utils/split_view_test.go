@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestSplitViewSides(t *testing.T) {
+	// Channel values that 8 bits cannot hold
+	original := color.NRGBA64{0x1234, 0x5678, 0x9abc, 0xffff}
+	processed := color.NRGBA64{0xfedc, 0xba98, 0x7654, 0xffff}
+	uniform := func(c color.NRGBA64, highBitDepth bool) image.Image {
+		if highBitDepth {
+			img := image.NewNRGBA64(image.Rect(0, 0, 40, 40))
+			for y := 0; y < 40; y++ {
+				for x := 0; x < 40; x++ {
+					img.SetNRGBA64(x, y, c)
+				}
+			}
+			return img
+		}
+		img := image.NewNRGBA(image.Rect(0, 0, 40, 40))
+		for y := 0; y < 40; y++ {
+			for x := 0; x < 40; x++ {
+				img.Set(x, y, c)
+			}
+		}
+		return img
+	}
+
+	tests := []struct {
+		name                        string
+		mode                        SplitMode
+		highOriginal, highProcessed bool
+		originalAt, processedAt     image.Point
+	}{
+		{"vertical", SplitVertical, true, true, image.Pt(5, 20), image.Pt(35, 20)},
+		{"diagonal", SplitDiagonal, true, true, image.Pt(5, 5), image.Pt(35, 35)},
+		{"diagonal 16-bit original only", SplitDiagonal, true, false, image.Pt(10, 2), image.Pt(38, 30)},
+		{"lens 16-bit processed only", SplitLens, false, true, image.Pt(2, 2), image.Pt(20, 20)},
+		{"vertical 8-bit", SplitVertical, false, false, image.Pt(5, 20), image.Pt(35, 20)},
+	}
+	for _, tt := range tests {
+		src, dst := uniform(original, tt.highOriginal), uniform(processed, tt.highProcessed)
+		out, err := SplitView(src, dst, DefaultSplitOptions(tt.mode))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := IsHighBitDepth(out), tt.highOriginal || tt.highProcessed; got != want {
+			t.Errorf("%s: output %T keeps 16 bits %v, want %v", tt.name, out, got, want)
+		}
+		for _, side := range []struct {
+			at   image.Point
+			from image.Image
+		}{{tt.originalAt, src}, {tt.processedAt, dst}} {
+			got := color.NRGBA64Model.Convert(out.At(side.at.X, side.at.Y))
+			if want := color.NRGBA64Model.Convert(side.from.At(side.at.X, side.at.Y)); got != want {
+				t.Errorf("%s: pixel %v is %v, want %v", tt.name, side.at, got, want)
+			}
+		}
+		// The divider runs through the split point
+		if got := color.NRGBA64Model.Convert(out.At(20, 20)); tt.mode != SplitLens && got != (color.NRGBA64{0xffff, 0xffff, 0xffff, 0xffff}) {
+			t.Errorf("%s: split point is %v, want the white divider", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"image"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// Rows per band below which splitting work across goroutines costs more than it saves
+	minBandRows = 16
+	// Bands handed out per worker, so a slow band does not leave the others idle
+	bandsPerWorker = 4
+)
+
+// parallelRows calls fn for horizontal bands [y0, y1) covering [minY, maxY), spread
+// over a pool of up to GOMAXPROCS goroutines. fn must write only to its own rows.
+func parallelRows(minY, maxY int, fn func(y0, y1 int)) {
+	rows := maxY - minY
+	workers := runtime.GOMAXPROCS(0)
+	bands := min(workers*bandsPerWorker, rows/minBandRows)
+	if bands <= 1 || workers == 1 {
+		if rows > 0 {
+			fn(minY, maxY)
+		}
+		return
+	}
+
+	bandRows := (rows + bands - 1) / bands
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, bands); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				y0 := minY + int(next.Add(1)-1)*bandRows
+				if y0 >= maxY {
+					return
+				}
+				fn(y0, min(y0+bandRows, maxY))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// premultipliedRow stores the 16-bit premultiplied RGBA of the pixels from x0 to x1
+// on row y in dst, four values per pixel. The values are exactly those returned by
+// img.At(x, y).RGBA(), read straight from Pix for the common image types.
+func premultipliedRow(img image.Image, y, x0, x1 int, dst []uint32) {
+	switch src := img.(type) {
+	case *image.RGBA:
+		pix := src.Pix[src.PixOffset(x0, y):]
+		for i := 0; i < 4*(x1-x0); i++ {
+			dst[i] = uint32(pix[i]) * 0x101
+		}
+	case *image.NRGBA:
+		pix := src.Pix[src.PixOffset(x0, y):]
+		for i := 0; i < 4*(x1-x0); i += 4 {
+			a := uint32(pix[i+3])
+			dst[i+0] = uint32(pix[i+0]) * 0x101 * a / 0xff
+			dst[i+1] = uint32(pix[i+1]) * 0x101 * a / 0xff
+			dst[i+2] = uint32(pix[i+2]) * 0x101 * a / 0xff
+			dst[i+3] = a * 0x101
+		}
+	case *image.YCbCr:
+		for x, i := x0, 0; x < x1; x, i = x+1, i+4 {
+			dst[i], dst[i+1], dst[i+2], dst[i+3] = src.YCbCrAt(x, y).RGBA()
+		}
+	default:
+		for x, i := x0, 0; x < x1; x, i = x+1, i+4 {
+			dst[i], dst[i+1], dst[i+2], dst[i+3] = img.At(x, y).RGBA()
+		}
+	}
+}
+
+// nrgbaRow stores the 8-bit straight-alpha color of the pixels from x0 to x1 on
+// row y in dst, as color.NRGBAModel would convert them. scratch needs room for
+// four values per pixel.
+func nrgbaRow(img image.Image, y, x0, x1 int, scratch []uint32, dst []uint8) {
+	if src, ok := img.(*image.NRGBA); ok {
+		copy(dst[:4*(x1-x0)], src.Pix[src.PixOffset(x0, y):])
+		return
+	}
+	premultipliedRow(img, y, x0, x1, scratch)
+	for i := 0; i < 4*(x1-x0); i += 4 {
+		r, g, b := unpremultiply16(scratch[i], scratch[i+1], scratch[i+2], scratch[i+3])
+		dst[i], dst[i+1], dst[i+2], dst[i+3] = uint8(r>>8), uint8(g>>8), uint8(b>>8), uint8(scratch[i+3]>>8)
+	}
+}
+
+// unpremultiply16 converts 16-bit premultiplied values to straight alpha the way
+// color.NRGBA64Model does
+func unpremultiply16(r, g, b, a uint32) (uint32, uint32, uint32) {
+	switch a {
+	case 0xffff:
+		return r, g, b
+	case 0:
+		return 0, 0, 0
+	}
+	return r * 0xffff / a, g * 0xffff / a, b * 0xffff / a
+}
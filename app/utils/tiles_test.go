@@ -0,0 +1,265 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"runtime"
+	"testing"
+)
+
+// Worker count used to force the banded path even on a single-CPU machine
+const testParallelProcs = 8
+
+// withProcs runs fn with GOMAXPROCS set to n
+func withProcs(n int, fn func()) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(n))
+	fn()
+}
+
+// parallelSources returns images of the types premultipliedRow reads directly, plus
+// one it reads through At, all with odd sizes and a non-zero origin
+func parallelSources(w, h int) map[string]image.Image {
+	rng := rand.New(rand.NewSource(17))
+	rect := image.Rect(3, 5, 3+w, 5+h)
+
+	nrgba := image.NewNRGBA(rect)
+	rng.Read(nrgba.Pix)
+
+	rgba := image.NewRGBA(rect)
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		a := uint8(rng.Intn(256))
+		for c := 0; c < 3; c++ {
+			rgba.Pix[i+c] = uint8(rng.Intn(int(a) + 1))
+		}
+		rgba.Pix[i+3] = a
+	}
+
+	ycbcr := image.NewYCbCr(rect, image.YCbCrSubsampleRatio420)
+	rng.Read(ycbcr.Y)
+	rng.Read(ycbcr.Cb)
+	rng.Read(ycbcr.Cr)
+
+	gray := image.NewGray(rect)
+	rng.Read(gray.Pix)
+
+	return map[string]image.Image{"NRGBA": nrgba, "RGBA": rgba, "YCbCr": ycbcr, "Gray": gray}
+}
+
+// serialMatrixFilter is SimulateColorBlindness as it was before it ran in parallel
+func serialMatrixFilter(img image.Image, matrix [3][3]float64) image.Image {
+	bounds := img.Bounds()
+	out := image.NewRGBA(bounds)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			originalColor := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			r, g, b := float64(originalColor.R), float64(originalColor.G), float64(originalColor.B)
+
+			newR := clamp(r*matrix[0][0] + g*matrix[0][1] + b*matrix[0][2])
+			newG := clamp(r*matrix[1][0] + g*matrix[1][1] + b*matrix[1][2])
+			newB := clamp(r*matrix[2][0] + g*matrix[2][1] + b*matrix[2][2])
+
+			out.Set(x, y, color.RGBA{uint8(newR), uint8(newG), uint8(newB), originalColor.A})
+		}
+	}
+	return out
+}
+
+// serialDaltonize is Daltonize as it was before it ran in parallel
+func serialDaltonize(img image.Image, cbMatrix [3][3]float64) image.Image {
+	bounds := img.Bounds()
+	out := image.NewRGBA(bounds)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			originalColor := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			r, g, b := float64(originalColor.R), float64(originalColor.G), float64(originalColor.B)
+
+			simR := clamp(r*cbMatrix[0][0] + g*cbMatrix[0][1] + b*cbMatrix[0][2])
+			simG := clamp(r*cbMatrix[1][0] + g*cbMatrix[1][1] + b*cbMatrix[1][2])
+			simB := clamp(r*cbMatrix[2][0] + g*cbMatrix[2][1] + b*cbMatrix[2][2])
+
+			newR := clamp(r + (r-simR)*DefaultDaltonizeStrength)
+			newG := clamp(g + (g-simG)*DefaultDaltonizeStrength)
+			newB := clamp(b + (b-simB)*DefaultDaltonizeStrength)
+
+			out.Set(x, y, color.RGBA{uint8(newR), uint8(newG), uint8(newB), originalColor.A})
+		}
+	}
+	return out
+}
+
+// serialGrayscale is ConvertToGrayscale as it was before it ran in parallel, with
+// ToLinear and ToSRGB inlined in their serial form
+func serialGrayscale(img image.Image) image.Image {
+	bounds := img.Bounds()
+	lin := NewLinearImage(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		i := lin.PixOffset(bounds.Min.X, y)
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			a := float32(c.A) / 0xffff
+			lin.Pix[i+0] = DecodeSRGB16(c.R) * a
+			lin.Pix[i+1] = DecodeSRGB16(c.G) * a
+			lin.Pix[i+2] = DecodeSRGB16(c.B) * a
+			lin.Pix[i+3] = a
+			i += 4
+		}
+	}
+
+	for i := 0; i < len(lin.Pix); i += 4 {
+		y := 0.2126*lin.Pix[i] + 0.7152*lin.Pix[i+1] + 0.0722*lin.Pix[i+2]
+		lin.Pix[i], lin.Pix[i+1], lin.Pix[i+2] = y, y, y
+	}
+
+	out := image.NewNRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		i := lin.PixOffset(bounds.Min.X, y)
+		j := out.PixOffset(bounds.Min.X, y)
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if a := lin.Pix[i+3]; a > 0 {
+				out.Pix[j+0] = EncodeSRGB8(lin.Pix[i+0] / a)
+				out.Pix[j+1] = EncodeSRGB8(lin.Pix[i+1] / a)
+				out.Pix[j+2] = EncodeSRGB8(lin.Pix[i+2] / a)
+				out.Pix[j+3] = uint8(clampUnit(float64(a))*255 + 0.5)
+			}
+			i += 4
+			j += 4
+		}
+	}
+	return out
+}
+
+// pixBytes returns the pixel buffer of the 8-bit image types the filters produce
+func pixBytes(t testing.TB, img image.Image) []byte {
+	switch img := img.(type) {
+	case *image.RGBA:
+		return img.Pix
+	case *image.NRGBA:
+		return img.Pix
+	}
+	t.Fatalf("unexpected output type %T", img)
+	return nil
+}
+
+func TestParallelFiltersMatchSerial(t *testing.T) {
+	filters := []struct {
+		name     string
+		parallel func(image.Image) image.Image
+		serial   func(image.Image) image.Image
+	}{
+		{"SimulateColorBlindness",
+			func(img image.Image) image.Image { return SimulateColorBlindness(img, DeuteranopiaMatrix) },
+			func(img image.Image) image.Image { return serialMatrixFilter(img, DeuteranopiaMatrix) }},
+		{"Daltonize",
+			func(img image.Image) image.Image { return Daltonize(img, ProtanopiaMatrix) },
+			func(img image.Image) image.Image { return serialDaltonize(img, ProtanopiaMatrix) }},
+		{"ConvertToGrayscale", ConvertToGrayscale, serialGrayscale},
+	}
+
+	for name, src := range parallelSources(211, 157) {
+		for _, f := range filters {
+			want := pixBytes(t, f.serial(src))
+			for _, procs := range []int{1, testParallelProcs} {
+				var got image.Image
+				withProcs(procs, func() { got = f.parallel(src) })
+				if got.Bounds() != src.Bounds() {
+					t.Errorf("%s on %s: bounds %v, want %v", f.name, name, got.Bounds(), src.Bounds())
+				}
+				if !bytes.Equal(pixBytes(t, got), want) {
+					t.Errorf("%s on %s with GOMAXPROCS=%d differs from the serial output", f.name, name, procs)
+				}
+			}
+		}
+	}
+}
+
+func TestParallelConvolutionMatchesSerial(t *testing.T) {
+	// The sharpen kernel is not separable, so it takes the 2D path
+	sharpen, err := ParseKernel("0,-1,0;-1,5,-1;0,-1,0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	convolutions := map[string]func(image.Image) (image.Image, error){
+		"Convolve":     func(img image.Image) (image.Image, error) { return Convolve(img, sharpen, EdgeMirror) },
+		"GaussianBlur": func(img image.Image) (image.Image, error) { return GaussianBlur(img, 2, 0, EdgeClamp) },
+	}
+
+	for name, src := range parallelSources(211, 157) {
+		for op, convolve := range convolutions {
+			var serial, parallel image.Image
+			withProcs(1, func() { serial, err = convolve(src) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			withProcs(testParallelProcs, func() { parallel, err = convolve(src) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(pixBytes(t, parallel), pixBytes(t, serial)) {
+				t.Errorf("%s on %s differs between serial and parallel runs", op, name)
+			}
+		}
+	}
+}
+
+// benchmarkSerialParallel runs a filter over a 4000×3000 photo-sized image. The
+// serial arm runs the serial reference copy when there is one, and otherwise the
+// filter itself with one worker; the parallel arm uses every CPU.
+func benchmarkSerialParallel(b *testing.B, serial, parallel func(image.Image)) {
+	src := parallelSources(4000, 3000)["NRGBA"]
+	b.Run("serial", func(b *testing.B) {
+		if serial == nil {
+			serial = parallel
+		}
+		withProcs(1, func() {
+			for i := 0; i < b.N; i++ {
+				serial(src)
+			}
+		})
+	})
+	b.Run("parallel", func(b *testing.B) {
+		if runtime.NumCPU() < 2 {
+			b.Skip("only one CPU available")
+		}
+		withProcs(runtime.NumCPU(), func() {
+			for i := 0; i < b.N; i++ {
+				parallel(src)
+			}
+		})
+	})
+}
+
+func BenchmarkSimulateColorBlindness(b *testing.B) {
+	benchmarkSerialParallel(b,
+		func(img image.Image) { serialMatrixFilter(img, DeuteranopiaMatrix) },
+		func(img image.Image) { SimulateColorBlindness(img, DeuteranopiaMatrix) })
+}
+
+func BenchmarkDaltonize(b *testing.B) {
+	benchmarkSerialParallel(b,
+		func(img image.Image) { serialDaltonize(img, ProtanopiaMatrix) },
+		func(img image.Image) { Daltonize(img, ProtanopiaMatrix) })
+}
+
+func BenchmarkConvertToGrayscale(b *testing.B) {
+	benchmarkSerialParallel(b,
+		func(img image.Image) { serialGrayscale(img) },
+		func(img image.Image) { ConvertToGrayscale(img) })
+}
+
+// The convolutions were banded from the start, so they have no serial copy
+
+func BenchmarkConvolve(b *testing.B) {
+	sharpen, err := ParseKernel("0,-1,0;-1,5,-1;0,-1,0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkSerialParallel(b, nil, func(img image.Image) { Convolve(img, sharpen, EdgeClamp) })
+}
+
+func BenchmarkGaussianBlur(b *testing.B) {
+	benchmarkSerialParallel(b, nil, func(img image.Image) { GaussianBlur(img, 2, 0, EdgeClamp) })
+}
@@ -1,23 +1,27 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
+	"runtime"
 	"strconv"
+	"strings"
+	"time"
 
+	"color-blind-simulator-1/app/handlers"
+	"color-blind-simulator-1/app/jobs"
 	"color-blind-simulator-1/app/models"
+	"color-blind-simulator-1/app/pipeline"
 	"color-blind-simulator-1/app/server"
-	"color-blind-simulator-1/app/utils"
+	"color-blind-simulator-1/app/storage"
 
-	"github.com/disintegration/imaging"
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -27,8 +31,14 @@ import (
 const (
 	outputDir = "output"
 	port      = ":8080"
+
+	// How often expired job output is swept
+	outputExpiryInterval = 5 * time.Minute
 )
 
+// outputs holds each job's images in its own directory under outputDir
+var outputs *storage.OutputStore
+
 // ImageProcessingRequest represents a request for image processing
 type ImageProcessingRequest struct {
 	Operation string  `json:"operation"`
@@ -51,36 +61,34 @@ func initializeMongoDB() error {
 	return nil
 }
 
-// Add this function to clean up old images
-func cleanupOutputDirectory() error {
-	// Remove all files in the output directory
-	files, err := os.ReadDir(outputDir)
-	if err != nil {
-		return err
-	}
-
-	for _, file := range files {
-		if !file.IsDir() {
-			err := os.Remove(filepath.Join(outputDir, file.Name()))
-			if err != nil {
-				return err
-			}
-		}
-	}
-	return nil
-}
-
-// Update the handleUpload function to clean up before processing
+// handleUpload runs the pipeline on the uploaded image, saving every step in a new job directory
 func handleUpload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Clean up old images before processing new ones
-	if err := cleanupOutputDirectory(); err != nil {
-		log.Printf("Error cleaning up output directory: %v", err)
-		// Continue processing even if cleanup fails
+	// Validate the whole pipeline before touching any files
+	spec, err := pipeline.SpecFromRequest(r)
+	if err != nil {
+		pipeline.WriteError(w, err)
+		return
+	}
+	split, err := pipeline.SplitOptionsFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	spec.Split = split
+	steps, err := pipeline.Compile(spec)
+	if err != nil {
+		pipeline.WriteError(w, err)
+		return
+	}
+	format, err := pipeline.FormatFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	file, _, err := r.FormFile("image")
@@ -96,86 +104,77 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	src, err := imaging.Decode(bytes.NewReader(imgData))
+	// Each request writes to its own directory so concurrent users never collide
+	job, err := outputs.Create()
 	if err != nil {
-		http.Error(w, "Error decoding image", http.StatusInternalServerError)
+		log.Printf("Error creating job output: %v", err)
+		http.Error(w, "Error saving results", http.StatusInternalServerError)
 		return
 	}
 
-	// Get all selected operations
-	operations := r.URL.Query()["operation"]
-	angleStr := r.URL.Query().Get("angle")
-	angle := 0.0
-	if angleStr != "" {
-		angle, _ = strconv.ParseFloat(angleStr, 64)
+	req := jobs.Request{Pipeline: steps, Image: imgData, Split: split, Format: format}
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		streamUpload(w, r, req, job)
+		return
 	}
 
-	// Create output directory
-	os.MkdirAll(outputDir, 0755)
-
-	// Process the image with all selected operations
-	processedImage := src
-	var imageURLs []string
-
-	// Save original image
-	originalPath := filepath.Join(outputDir, "original.jpg")
-	imaging.Save(processedImage, originalPath)
-	imageURLs = append(imageURLs, "/output/original.jpg")
-
-	// Apply each operation and save intermediate results
-	for i, operation := range operations {
-		switch operation {
-		case "flip":
-			processedImage = utils.FlipImage(processedImage)
-		case "rotate":
-			processedImage = utils.RotateImage(processedImage, angle)
-		case "rotate_shear":
-			processedImage = utils.RotateImageWithShear(processedImage, angle)
-		case "grayscale":
-			processedImage = utils.ConvertToGrayscale(processedImage)
-		case "box_blur":
-			processedImage = utils.ApplyBoxBlur(processedImage)
-		case "gaussian_blur":
-			processedImage = utils.ApplyGaussianBlur(processedImage)
-		case "edge_detection":
-			processedImage = utils.ApplyEdgeDetection(processedImage)
-		case "protanopia":
-			processedImage = utils.SimulateColorBlindness(processedImage, utils.ProtanopiaMatrix)
-		case "deuteranopia":
-			processedImage = utils.SimulateColorBlindness(processedImage, utils.DeuteranopiaMatrix)
-		case "tritanopia":
-			processedImage = utils.SimulateColorBlindness(processedImage, utils.TritanopiaMatrix)
-		case "protanomaly":
-			processedImage = utils.SimulateColorBlindness(processedImage, utils.ProtanomalyMatrix)
-		case "deuteranomaly":
-			processedImage = utils.SimulateColorBlindness(processedImage, utils.DeuteranomalyMatrix)
-		case "tritanomaly":
-			processedImage = utils.SimulateColorBlindness(processedImage, utils.TritanomalyMatrix)
-		case "achromatopsia":
-			processedImage = utils.SimulateColorBlindness(processedImage, utils.AchromatopsiaMatrix)
-		case "monochromacy":
-			processedImage = utils.SimulateColorBlindness(processedImage, utils.MonochromacyMatrix)
-		case "daltonize":
-			processedImage = utils.Daltonize(processedImage, utils.ProtanopiaMatrix)
-		}
-
-		// Save intermediate result
-		outputPath := filepath.Join(outputDir, fmt.Sprintf("step_%d_%s.jpg", i+1, operation))
-		imaging.Save(processedImage, outputPath)
-		imageURLs = append(imageURLs, "/output/step_"+fmt.Sprintf("%d_%s.jpg", i+1, operation))
+	// Return all image URLs, plus any analysis summaries
+	result, err := jobs.Execute(r.Context(), req, job, nil)
+	if errors.Is(err, jobs.ErrInvalidImage) {
+		http.Error(w, "Error decoding image", http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error processing image: %v", err), http.StatusBadRequest)
+		return
 	}
-
-	// Return all image URLs
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"images":     imageURLs,
-		"operations": operations,
+	json.NewEncoder(w).Encode(result)
+}
+
+// streamUpload runs the pipeline like handleUpload but reports progress as
+// server-sent events: a "progress" event with the result so far after the
+// original and each step are saved, then "done" with the full result or "error"
+func streamUpload(w http.ResponseWriter, r *http.Request, req jobs.Request, job *storage.JobOutput) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	total := req.Pipeline.Len()
+	result, err := jobs.Execute(r.Context(), req, job, func(partial jobs.Result) {
+		writeEvent(w, "progress", map[string]interface{}{
+			"completed": len(partial.Images) - 1,
+			"total":     total,
+			"result":    partial,
+		})
+		flusher.Flush()
 	})
+	if err != nil {
+		writeEvent(w, "error", map[string]interface{}{"error": err.Error()})
+	} else {
+		writeEvent(w, "done", result)
+	}
+	flusher.Flush()
+}
+
+// writeEvent writes one server-sent event with a JSON payload
+func writeEvent(w io.Writer, event string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", event, err)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
 }
 
+// setupStaticHandlers serves job output without directory listings
 func setupStaticHandlers() {
-	fs := http.FileServer(http.Dir(outputDir))
-	http.Handle("/output/", http.StripPrefix("/output/", fs))
+	http.Handle("/output/", http.StripPrefix("/output", outputs.Handler()))
 }
 
 func renderTemplate(w http.ResponseWriter, tmpl string, data interface{}) {
@@ -232,16 +231,34 @@ func main() {
 		log.Printf("Warning: Failed to insert sample quizzes: %v", err)
 	}
 
-	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
+	// Job output is kept for OUTPUT_RETENTION (e.g. "30m"), an hour by default
+	retention := storage.DefaultRetention
+	if v := os.Getenv("OUTPUT_RETENTION"); v != "" {
+		if retention, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid OUTPUT_RETENTION %q: %v", v, err)
+		}
+	}
+	outputs, err = storage.NewOutputStore(outputDir, "/output", retention)
+	if err != nil {
 		log.Fatalf("Failed to create output directory: %v", err)
 	}
+	outputs.StartExpiry(outputExpiryInterval, nil)
+
+	// Heavy pipelines can run in the background on JOB_WORKERS workers
+	workers := runtime.NumCPU()
+	if v := os.Getenv("JOB_WORKERS"); v != "" {
+		if workers, err = strconv.Atoi(v); err != nil || workers < 1 {
+			log.Fatalf("Invalid JOB_WORKERS %q", v)
+		}
+	}
+	jobManager := jobs.NewManager(jobs.NewMemoryQueue(jobs.DefaultQueueSize), outputs, workers)
+	jobManager.Start(context.Background())
 
 	// Start UDP server in a goroutine
-	go server.StartUDPServer()
+	go server.StartUDPServer(outputs)
 
-	// Serve static files
-	fs := http.FileServer(http.Dir(outputDir))
-	http.Handle("/output/", http.StripPrefix("/output/", fs))
+	// Serve processed images
+	setupStaticHandlers()
 
 	// Serve static assets
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
@@ -257,6 +274,12 @@ func main() {
 		renderTemplate(w, "quiz", nil)
 	})
 	http.HandleFunc("/visualize", visualizeHandler)
+	http.HandleFunc("/api/palette/check", handlers.PaletteCheckHandler)
+	http.HandleFunc("/api/palette/generate", handlers.PaletteGenerateHandler)
+	http.HandleFunc("/api/operations", handlers.OperationsHandler)
+	http.HandleFunc("/api/export/", handlers.ExportHandler)
+	http.HandleFunc("/api/jobs", handlers.JobsHandler(jobManager))
+	http.HandleFunc("/api/jobs/", handlers.JobsHandler(jobManager))
 
 	// API endpoint to get quizzes by level
 	http.HandleFunc("/api/quizzes", func(w http.ResponseWriter, r *http.Request) {
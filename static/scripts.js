@@ -152,14 +152,41 @@ document.addEventListener('DOMContentLoaded', function() {
             }
         });
 
+        const cropOptions = document.getElementById('cropOptions');
+        const resizeOptions = document.getElementById('resizeOptions');
+        const matrixOptions = document.getElementById('matrixOptions');
+        const matrixInput = document.getElementById('matrix');
+
         transformationSelect.addEventListener('change', function() {
-            if (this.value === 'rotate' || this.value === 'rotate_shear') {
-                rotationOptions.classList.remove('hidden');
+            rotationOptions.classList.toggle('hidden', this.value !== 'rotate' && this.value !== 'rotate_shear');
+            cropOptions.classList.toggle('hidden', this.value !== 'crop');
+            resizeOptions.classList.toggle('hidden', this.value !== 'resize');
+            matrixOptions.classList.toggle('hidden', this.value !== 'affine' && this.value !== 'perspective');
+            if (this.value === 'affine') {
+                matrixInput.value = '1,0.3,0; 0,1,0';
+            } else if (this.value === 'perspective') {
+                matrixInput.value = '1,0,0; 0,1,0; 0.0005,0,1';
+            }
+        });
+
+        // Show severity slider for anomalous trichromacy
+        const colorBlindnessSelect = document.getElementById('colorBlindness');
+        const severityOptions = document.getElementById('severityOptions');
+        const severityInput = document.getElementById('severity');
+        const severityValue = document.getElementById('severityValue');
+
+        colorBlindnessSelect.addEventListener('change', function() {
+            if (this.value.endsWith('anomaly')) {
+                severityOptions.classList.remove('hidden');
             } else {
-                rotationOptions.classList.add('hidden');
+                severityOptions.classList.add('hidden');
             }
         });
 
+        severityInput.addEventListener('input', function() {
+            severityValue.textContent = this.value + '%';
+        });
+
         angleInput.addEventListener('input', function() {
             angleValue.textContent = this.value + '°';
         });
@@ -178,13 +205,17 @@ document.addEventListener('DOMContentLoaded', function() {
             const transformation = document.getElementById('transformation').value;
             const filter = document.getElementById('filter').value;
             const angle = document.getElementById('angle').value;
+            const simulationModel = document.getElementById('simulationModel').value;
+            const split = document.getElementById('split').value;
 
             // Build the URL with query parameters
             let url = '/visualize?';
             const operations = [];
 
             // Add selected operations
-            if (colorBlindness !== 'none') {
+            if (colorBlindness.endsWith('anomaly')) {
+                operations.push(`${colorBlindness}:${severityInput.value / 100}`);
+            } else if (colorBlindness !== 'none') {
                 operations.push(colorBlindness);
             }
             if (transformation !== 'none') {
@@ -199,34 +230,109 @@ document.addEventListener('DOMContentLoaded', function() {
                 url += `operation=${op}&`;
             });
 
+            // Add simulation model if a physiological model was chosen
+            if (simulationModel !== 'legacy') {
+                url += `model=${simulationModel}&`;
+            }
+
+            // Add split view comparison if requested
+            if (split !== 'none') {
+                url += `split=${split}&`;
+            }
+
             // Add angle if needed
             if (transformation === 'rotate' || transformation === 'rotate_shear') {
                 url += `angle=${angle}&`;
             }
 
+            // Add geometry parameters
+            if (transformation === 'crop') {
+                url += `crop_x=${document.getElementById('cropX').value}&crop_y=${document.getElementById('cropY').value}&`;
+                url += `crop_width=${document.getElementById('cropWidth').value}&crop_height=${document.getElementById('cropHeight').value}&`;
+            } else if (transformation === 'resize') {
+                url += `resize_width=${document.getElementById('resizeWidth').value}&resize_height=${document.getElementById('resizeHeight').value}&`;
+                url += `resize_filter=${document.getElementById('resizeFilter').value}&`;
+            } else if (transformation === 'affine' || transformation === 'perspective') {
+                url += `${transformation}_matrix=${encodeURIComponent(matrixInput.value)}&`;
+            }
+
+            // Ask for server-sent progress events so each step shows up as soon as it is saved
             fetch(url, {
                 method: 'POST',
+                headers: { 'Accept': 'text/event-stream' },
                 body: formData
             })
-            .then(response => response.json())
-            .then(data => {
+            .then(response => {
+                if (!response.ok) {
+                    return response.text().then(text => {
+                        let message = text;
+                        try {
+                            message = JSON.parse(text).error;
+                        } catch (e) {
+                            // Plain text error
+                        }
+                        throw new Error(message);
+                    });
+                }
+
                 resultsSection.innerHTML = `
                     <h3>Processing Results</h3>
-                    <div class="results-grid">
-                        ${data.images.map((url, index) => `
+                    <p class="progress">Processing...</p>
+                    <div class="results-grid"></div>
+                `;
+                const progress = resultsSection.querySelector('.progress');
+                const grid = resultsSection.querySelector('.results-grid');
+                let shown = 0;
+
+                // Append a card for every image not yet on the page
+                function showImages(data) {
+                    data.images.slice(shown).forEach((url, offset) => {
+                        const index = shown + offset;
+                        grid.insertAdjacentHTML('beforeend', `
                             <div class="result-card">
                                 <h4>${index === 0 ? 'Original' : `Step ${index}: ${data.operations[index-1]}`}</h4>
                                 <img src="${url}" alt="Processed image">
+                                ${url.endsWith('.png') ? `<a href="${url}" download>Download</a>` : ''}
                             </div>
-                        `).join('')}
-                    </div>
-                    <div class="applied-operations">
-                        <h4>Applied Operations:</h4>
-                        <ul>
-                            ${operations.map(op => `<li>${op}</li>`).join('')}
-                        </ul>
-                    </div>
-                `;
+                        `);
+                    });
+                    shown = data.images.length;
+                }
+
+                return readEvents(response, (event, data) => {
+                    if (event === 'progress') {
+                        showImages(data.result);
+                        progress.textContent = `Completed ${data.completed} of ${data.total} steps...`;
+                    } else if (event === 'error') {
+                        throw new Error(data.error);
+                    } else if (event === 'done') {
+                        showImages(data);
+                        progress.remove();
+                        resultsSection.insertAdjacentHTML('beforeend', `
+                            ${data.split ? `
+                                <div class="result-card">
+                                    <h4>Original vs. Result</h4>
+                                    <img src="${data.split}" alt="Split view comparison">
+                                </div>
+                            ` : ''}
+                            ${(data.analysis || []).map(entry => `
+                                <div class="analysis-summary">
+                                    <h4>Step ${entry.step}: ${entry.operation}</h4>
+                                    ${entry.report.failing !== undefined
+                                        ? `<p>${entry.report.failing_count} of ${entry.report.region_count} text regions fail WCAG contrast (${entry.report.min_contrast}:1).</p>`
+                                        : `<p>${entry.report.affected_percent.toFixed(1)}% of the image loses color contrast for ${entry.report.deficiency} vision.</p>`}
+                                </div>
+                            `).join('')}
+                            ${data.color_profile ? `<p>Converted from the embedded ${data.color_profile} profile to sRGB.</p>` : ''}
+                            <div class="applied-operations">
+                                <h4>Applied Operations:</h4>
+                                <ul>
+                                    ${operations.map(op => `<li>${op}</li>`).join('')}
+                                </ul>
+                            </div>
+                        `);
+                    }
+                });
             })
             .catch(error => {
                 resultsSection.innerHTML = `
@@ -241,3 +347,41 @@ document.addEventListener('DOMContentLoaded', function() {
         });
     }
 });
+
+// readEvents parses a server-sent event stream from a fetch response, calling
+// onEvent with each event name and its JSON data
+function readEvents(response, onEvent) {
+    const reader = response.body.getReader();
+    const decoder = new TextDecoder();
+    let buffer = '';
+
+    function dispatch(block) {
+        let event = 'message';
+        let data = '';
+        block.split('\n').forEach(line => {
+            if (line.startsWith('event: ')) {
+                event = line.slice(7);
+            } else if (line.startsWith('data: ')) {
+                data += line.slice(6);
+            }
+        });
+        if (data) {
+            onEvent(event, JSON.parse(data));
+        }
+    }
+
+    function pump() {
+        return reader.read().then(({ done, value }) => {
+            buffer += decoder.decode(value || new Uint8Array(), { stream: !done });
+            let end;
+            while ((end = buffer.indexOf('\n\n')) >= 0) {
+                dispatch(buffer.slice(0, end));
+                buffer = buffer.slice(end + 2);
+            }
+            if (!done) {
+                return pump();
+            }
+        });
+    }
+    return pump();
+}